@@ -0,0 +1,68 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+// Snapshot is a copy of the store collections kept by the server, with how many entities of each collection it holds
+type Snapshot struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Genres    int       `json:"genres"`
+	Customers int       `json:"customers"`
+	Movies    int       `json:"movies"`
+	Rentals   int       `json:"rentals"`
+	Webhooks  int       `json:"webhooks"`
+}
+
+// CreateSnapshot has the server copy the store collections into a snapshot named name, replacing any snapshot with
+// the same name. The server names the snapshot when name is empty
+func (c *Client) CreateSnapshot(name string) (*Snapshot, error) {
+	buf := bytes.Buffer{}
+	err := json.NewEncoder(&buf).Encode(map[string]string{"name": name})
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.httpRequest("admin/snapshots", "POST", buf)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	snapshot := &Snapshot{}
+	err = json.NewDecoder(body).Decode(snapshot)
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// RestoreSnapshot has the server replace the store collections with the snapshot named name
+func (c *Client) RestoreSnapshot(name string) error {
+	body, err := c.httpRequest(fmt.Sprintf("admin/snapshots/%s/restore", url.PathEscape(name)), "POST", bytes.Buffer{})
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+// DeleteSnapshot has the server discard the snapshot named name
+func (c *Client) DeleteSnapshot(name string) error {
+	body, err := c.httpRequest(fmt.Sprintf("admin/snapshots/%s", url.PathEscape(name)), "DELETE", bytes.Buffer{})
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
+
+// ResetStore has the server empty the store collections or, when seed is true, put them back to its seed fixtures
+func (c *Client) ResetStore(seed bool) error {
+	body, err := c.httpRequest(fmt.Sprintf("admin/reset?seed=%t", seed), "POST", bytes.Buffer{})
+	if err != nil {
+		return err
+	}
+	return body.Close()
+}
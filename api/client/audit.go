@@ -0,0 +1,59 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/url"
+)
+
+// AuditEvent is a change, or attempted change, to the store recorded by the server
+type AuditEvent struct {
+	ID         string          `json:"_id"`
+	Time       string          `json:"time"`
+	Subject    string          `json:"subject"`
+	Token      string          `json:"token"`
+	Method     string          `json:"method"`
+	Route      string          `json:"route"`
+	Collection string          `json:"collection"`
+	EntityID   string          `json:"entityId"`
+	Status     int             `json:"status"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+}
+
+// AuditFilter narrows down the audit events returned by GetAuditEvents. Empty fields match every event
+type AuditFilter struct {
+	EntityID   string
+	Collection string
+	Subject    string
+}
+
+// GetAuditEvents retrieves the audit events matching filter from the server, in the order they were recorded
+func (c *Client) GetAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	query := url.Values{}
+	if filter.EntityID != "" {
+		query.Set("entity", filter.EntityID)
+	}
+	if filter.Collection != "" {
+		query.Set("collection", filter.Collection)
+	}
+	if filter.Subject != "" {
+		query.Set("subject", filter.Subject)
+	}
+	path := "api/audit"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+
+	body, err := c.httpRequest(path, "GET", bytes.Buffer{})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	events := []AuditEvent{}
+	err = json.NewDecoder(body).Decode(&events)
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
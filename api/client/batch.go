@@ -0,0 +1,182 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxBatchSize is the most items the server accepts in a bulk request
+const maxBatchSize = 1000
+
+// WithBatching makes the Client merge the creates, updates and deletes of genres, customers and movies that are made
+// within window of the first one into a single bulk request of up to maxSize items. Every call still gets the result
+// of its own item. Each call makes its own request when window is zero or less
+func WithBatching(window time.Duration, maxSize int) Option {
+	return func(c *Client) {
+		if window <= 0 {
+			return
+		}
+		if maxSize <= 0 || maxSize > maxBatchSize {
+			maxSize = maxBatchSize
+		}
+		c.batching = &batching{window: window, maxSize: maxSize, batchers: map[string]*batcher{}}
+	}
+}
+
+// batching holds the batching settings of a Client and a batcher for each bulk route it has sent items to
+type batching struct {
+	window   time.Duration
+	maxSize  int
+	batchers map[string]*batcher
+	sync.Mutex
+}
+
+// bulkRef names the entity an item of a bulk delete removes
+type bulkRef struct {
+	ID string `json:"_id"`
+}
+
+// bulkResult is the outcome of one item of a bulk request
+type bulkResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body"`
+	Error  string          `json:"error"`
+}
+
+// batchCall is an item waiting to be sent in a bulk request, and where to deliver its result
+type batchCall struct {
+	item   json.RawMessage
+	result chan batchOutcome
+}
+
+// batchOutcome is the result of a batchCall: the response body of its item, or the error it failed with
+type batchOutcome struct {
+	body json.RawMessage
+	err  error
+}
+
+// batcher collects the items for one bulk route until the batching window closes or the batch is full
+type batcher struct {
+	client  *Client
+	method  string
+	path    string
+	pending []*batchCall
+	timer   *time.Timer
+	sync.Mutex
+}
+
+// batched sends item to the bulk route at path along with the other items sent there within the batching window, and
+// returns the response body for item. Errors for the item are the same as those of the single-entity request
+func (c *Client) batched(method, path string, item interface{}) (io.ReadCloser, error) {
+	data, err := json.Marshal(item)
+	if err != nil {
+		return nil, err
+	}
+
+	c.batching.Lock()
+	key := method + " " + path
+	b, ok := c.batching.batchers[key]
+	if !ok {
+		b = &batcher{client: c, method: method, path: path}
+		c.batching.batchers[key] = b
+	}
+	c.batching.Unlock()
+
+	call := &batchCall{item: data, result: make(chan batchOutcome, 1)}
+	b.Lock()
+	b.pending = append(b.pending, call)
+	switch {
+	case len(b.pending) >= c.batching.maxSize:
+		calls := b.take()
+		b.Unlock()
+		go b.send(calls)
+	case len(b.pending) == 1:
+		b.timer = time.AfterFunc(c.batching.window, b.flush)
+		b.Unlock()
+	default:
+		b.Unlock()
+	}
+
+	outcome := <-call.result
+	if outcome.err != nil {
+		return nil, outcome.err
+	}
+	return ioutil.NopCloser(bytes.NewReader(outcome.body)), nil
+}
+
+// take removes the pending calls from the batcher and stops its timer. Expects the batcher to be locked
+func (b *batcher) take() []*batchCall {
+	calls := b.pending
+	b.pending = nil
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	return calls
+}
+
+// flush sends the pending calls once the batching window closes
+func (b *batcher) flush() {
+	b.Lock()
+	calls := b.take()
+	b.Unlock()
+	if len(calls) > 0 {
+		b.send(calls)
+	}
+}
+
+// send makes the bulk request for calls and delivers each of them its result. A failure of the whole request is
+// delivered to every call
+func (b *batcher) send(calls []*batchCall) {
+	results, err := b.request(calls)
+	for i, call := range calls {
+		switch {
+		case err != nil:
+			call.result <- batchOutcome{err: err}
+		case results[i].Status == http.StatusOK:
+			call.result <- batchOutcome{body: results[i].Body}
+		case len(results[i].Body) > 0:
+			call.result <- batchOutcome{err: statusError(results[i].Status, results[i].Body)}
+		default:
+			call.result <- batchOutcome{err: statusError(results[i].Status, []byte(results[i].Error))}
+		}
+	}
+}
+
+// request sends the items of calls to the bulk route in one request
+func (b *batcher) request(calls []*batchCall) ([]bulkResult, error) {
+	items := make([]json.RawMessage, 0, len(calls))
+	for _, call := range calls {
+		items = append(items, call.item)
+	}
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(items); err != nil {
+		return nil, err
+	}
+
+	var opts []requestOption
+	if b.method == "POST" {
+		opts = append(opts, withIdempotencyKey())
+	}
+	log.Printf("[DEBUG] sending %d items to %s %s", len(calls), b.method, b.path)
+	body, err := b.client.httpRequest(b.path, b.method, buf, opts...)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	results := []bulkResult{}
+	if err := json.NewDecoder(body).Decode(&results); err != nil {
+		return nil, err
+	}
+	if len(results) != len(calls) {
+		return nil, fmt.Errorf("expected %d results from %s %s, got %d", len(calls), b.method, b.path, len(results))
+	}
+	return results, nil
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/milamice62/terraplugin/api/server"
+)
+
+func TestClient_Batching(t *testing.T) {
+	handler := server.NewService("", nil).Handler()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	c := testClient(t, srv.URL, WithBatching(50*time.Millisecond, 100))
+
+	// comedy is created twice, so one of the two has to fail with a conflict
+	names := []string{"comedy", "drama", "horror", "comedy", "western"}
+	genres := make([]Genre, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func(i int, name string) {
+			defer wg.Done()
+			genres[i].Name = name
+			body, err := c.NewGenre(&genres[i])
+			if err == nil {
+				err = json.NewDecoder(body).Decode(&genres[i])
+			}
+			errs[i] = err
+		}(i, name)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Fatalf("expected the creates to be sent in one bulk request, got %d requests", n)
+	}
+	conflicts := 0
+	for i, err := range errs {
+		var conflict *ConflictError
+		switch {
+		case errors.As(err, &conflict):
+			conflicts++
+		case err != nil:
+			t.Errorf("%s: %s", names[i], err)
+		case genres[i].ID == "":
+			t.Errorf("%s: expected the genre to be given an ID", names[i])
+		}
+	}
+	if conflicts != 1 {
+		t.Errorf("expected one duplicate to fail with a conflict, got %d", conflicts)
+	}
+
+	genres[1].Name = "dramas"
+	if err := c.UpdateGenre(&genres[1]); err != nil {
+		t.Fatalf("error updating genre: %s", err)
+	}
+	if err := c.UpdateGenre(&genres[1]); !errors.Is(err, ErrModified) {
+		t.Errorf("expected an update from a stale version to fail with ErrModified, got %v", err)
+	}
+	if err := c.DeleteGenre("5ef199b9edf86a20de80b4a2"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected deleting a missing genre to fail with not found, got %v", err)
+	}
+}
+
+func TestClient_BatchingMaxSize(t *testing.T) {
+	handler := server.NewService("", nil).Handler()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	// The window is far longer than the test, so only full batches are sent
+	c := testClient(t, srv.URL, WithBatching(time.Hour, 5))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, err := c.NewCustomer(&Customer{Name: fmt.Sprintf("customer %d", i), Phone: fmt.Sprintf("5550%d", i)}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("expected two full bulk requests, got %d requests", n)
+	}
+}
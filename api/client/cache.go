@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+)
+
+// WithPrefetch makes the Client read each collection of genres, customers, movies and rentals once, with its list
+// endpoint, the first time one of them is read, and serve the single reads of the collection from that copy. Writes
+// through the Client evict what they change, which is then read from the server again
+func WithPrefetch(prefetch bool) Option {
+	return func(c *Client) {
+		if prefetch {
+			c.cache = &readCache{collections: map[string]*cachedCollection{}}
+		}
+	}
+}
+
+// readCache holds the prefetched collections of a Client
+type readCache struct {
+	collections map[string]*cachedCollection
+	sync.Mutex
+}
+
+// cachedCollection is the prefetched copy of a collection, by ID. A collection that has not been loaded yet, or has
+// been reset, is loaded on its next read
+type cachedCollection struct {
+	loaded   bool
+	entities map[string]json.RawMessage
+	sync.Mutex
+}
+
+// cascades lists, for each collection, the other collections whose entities change along with it: movies embed their
+// genres, and rentals take movies in and out of stock
+var cascades = map[string][]string{
+	"genres":  {"movies"},
+	"rentals": {"movies"},
+}
+
+// collection returns the cache of the named collection
+func (rc *readCache) collection(name string) *cachedCollection {
+	rc.Lock()
+	defer rc.Unlock()
+	collection, ok := rc.collections[name]
+	if !ok {
+		collection = &cachedCollection{}
+		rc.collections[name] = collection
+	}
+	return collection
+}
+
+// get decodes the entity of collection with ID id into v, loading the collection first when it is not loaded yet. It
+// reports false when the entity is not in the cache, so that it has to be read from the server
+func (rc *readCache) get(c *Client, collection, id string, v interface{}) (bool, error) {
+	cached := rc.collection(collection)
+	cached.Lock()
+	defer cached.Unlock()
+
+	if !cached.loaded {
+		body, err := c.httpRequest("api/"+collection, "GET", bytes.Buffer{})
+		if err != nil {
+			return false, err
+		}
+		defer body.Close()
+		var entities []json.RawMessage
+		if err := json.NewDecoder(body).Decode(&entities); err != nil {
+			return false, err
+		}
+		cached.entities = make(map[string]json.RawMessage, len(entities))
+		for _, entity := range entities {
+			var ref bulkRef
+			if json.Unmarshal(entity, &ref) == nil && ref.ID != "" {
+				cached.entities[ref.ID] = entity
+			}
+		}
+		cached.loaded = true
+		log.Printf("[DEBUG] prefetched %d %s", len(cached.entities), collection)
+	}
+
+	entity, ok := cached.entities[id]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(entity, v)
+}
+
+// invalidate evicts what a write to path may change: the entity it names, or the whole collection for a write that
+// does not name one, along with the collections that change with it
+func (rc *readCache) invalidate(path string) {
+	parts := strings.Split(strings.SplitN(path, "?", 2)[0], "/")
+	if len(parts) < 2 || parts[0] != "api" {
+		return
+	}
+	collection := parts[1]
+	if len(parts) > 2 && parts[2] != "bulk" && parts[2] != "batch" {
+		rc.evict(collection, parts[2])
+	} else {
+		rc.reset(collection)
+	}
+	for _, other := range cascades[collection] {
+		rc.reset(other)
+	}
+}
+
+// evict removes the entity of collection with ID id from the cache
+func (rc *readCache) evict(collection, id string) {
+	cached := rc.collection(collection)
+	cached.Lock()
+	defer cached.Unlock()
+	delete(cached.entities, id)
+}
+
+// reset drops the cache of collection, so that it is loaded again on its next read
+func (rc *readCache) reset(collection string) {
+	cached := rc.collection(collection)
+	cached.Lock()
+	defer cached.Unlock()
+	cached.loaded = false
+	cached.entities = nil
+}
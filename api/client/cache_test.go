@@ -0,0 +1,142 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/milamice62/terraplugin/api/server"
+)
+
+// testCountingServer starts the store seeded with dataset behind a handler counting the requests made to it
+func testCountingServer(t testing.TB, dataset *server.Dataset) (string, *int32) {
+	service := server.NewService("", nil)
+	if err := service.Seed(dataset); err != nil {
+		t.Fatal(err)
+	}
+	handler := service.Handler()
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL, &requests
+}
+
+// testCatalog returns a dataset of n movies of one genre
+func testCatalog(n int) *server.Dataset {
+	dataset := &server.Dataset{Genres: []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "horror"}}}
+	for i := 0; i < n; i++ {
+		dataset.Movies = append(dataset.Movies, server.Movie{
+			ID:            fmt.Sprintf("5ee6fe17de7e8d5eb0a%05d", i),
+			Title:         fmt.Sprintf("movie %d", i),
+			Genre:         server.Genre{ID: "5ee19f2a1363f7c0493761e9"},
+			NumberInStock: 1,
+		})
+	}
+	return dataset
+}
+
+func TestClient_Prefetch(t *testing.T) {
+	url, requests := testCountingServer(t, testCatalog(3))
+	c := testClient(t, url, WithPrefetch(true))
+
+	for i := 0; i < 3; i++ {
+		movie, err := c.GetMovie(fmt.Sprintf("5ee6fe17de7e8d5eb0a%05d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if movie.Title != fmt.Sprintf("movie %d", i) {
+			t.Errorf("expected movie %d, got %+v", i, movie)
+		}
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Fatalf("expected the movies to be read with one list request, got %d requests", n)
+	}
+
+	movie, _ := c.GetMovie("5ee6fe17de7e8d5eb0a00000")
+	movie.Title = "movie zero"
+	if err := c.UpdateMovie(movie); err != nil {
+		t.Fatal(err)
+	}
+	if movie, _ := c.GetMovie("5ee6fe17de7e8d5eb0a00000"); movie == nil || movie.Title != "movie zero" {
+		t.Errorf("expected the update to evict the cached movie, got %+v", movie)
+	}
+
+	// Renaming the genre changes the movies embedding it
+	if err := c.UpdateGenre(&Genre{ID: "5ee19f2a1363f7c0493761e9", Name: "slasher"}); err != nil {
+		t.Fatal(err)
+	}
+	if movie, _ := c.GetMovie("5ee6fe17de7e8d5eb0a00001"); movie == nil || movie.Genre.Name != "slasher" {
+		t.Errorf("expected the genre update to reset the cached movies, got %+v", movie)
+	}
+
+	if err := c.DeleteMovie("5ee6fe17de7e8d5eb0a00002"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.GetMovie("5ee6fe17de7e8d5eb0a00002"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected the deleted movie to be not found, got %v", err)
+	}
+}
+
+// BenchmarkRefresh reads every movie of a catalog twice, as Exists and Read do during a refresh, and reports the
+// requests made for each movie
+func BenchmarkRefresh(b *testing.B) {
+	const movies = 500
+	for _, prefetch := range []bool{false, true} {
+		b.Run(fmt.Sprintf("prefetch=%t", prefetch), func(b *testing.B) {
+			url, requests := testCountingServer(b, testCatalog(movies))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Every run of the provider configures a new client
+				c := testClient(b, url, WithPrefetch(prefetch))
+				for m := 0; m < movies; m++ {
+					for read := 0; read < 2; read++ {
+						if _, err := c.GetMovie(fmt.Sprintf("5ee6fe17de7e8d5eb0a%05d", m)); err != nil {
+							b.Fatal(err)
+						}
+					}
+				}
+			}
+			b.ReportMetric(float64(atomic.LoadInt32(requests))/float64(b.N*movies), "requests/movie")
+		})
+	}
+}
+
+func TestClient_ConditionalReads(t *testing.T) {
+	url, _ := testCountingServer(t, testCatalog(1))
+	c := testClient(t, url, WithConditionalReads(true))
+
+	for i := 0; i < 3; i++ {
+		movie, err := c.GetMovie("5ee6fe17de7e8d5eb0a00000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if movie.Title != "movie 0" {
+			t.Fatalf("expected the cached movie on read %d, got %+v", i, movie)
+		}
+	}
+	if hits, misses := c.ETagStats(); hits != 2 || misses != 1 {
+		t.Fatalf("expected 2 hits and 1 miss, got %d and %d", hits, misses)
+	}
+
+	movie, _ := c.GetMovie("5ee6fe17de7e8d5eb0a00000")
+	movie.Title = "movie zero"
+	if err := c.UpdateMovie(movie); err != nil {
+		t.Fatal(err)
+	}
+	movie, err := c.GetMovie("5ee6fe17de7e8d5eb0a00000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.Title != "movie zero" {
+		t.Errorf("expected a changed movie to be read again, got %+v", movie)
+	}
+	if hits, misses := c.ETagStats(); hits != 3 || misses != 2 {
+		t.Errorf("expected 3 hits and 2 misses, got %d and %d", hits, misses)
+	}
+}
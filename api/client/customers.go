@@ -8,28 +8,52 @@ import (
 )
 
 type Customer struct {
-	CustomerID string `json:"_id"`
-	Name       string `json:"name"`
-	IsGold     bool   `json:"isGold"`
-	Phone      string `json:"phone"`
+	CustomerID  string   `json:"_id"`
+	Name        string   `json:"name"`
+	IsGold      bool     `json:"isGold"`
+	Phone       string   `json:"phone"`
+	Email       string   `json:"email,omitempty"`
+	Address     *Address `json:"address,omitempty"`
+	MemberSince string   `json:"memberSince,omitempty"`
+	Notes       string   `json:"notes,omitempty"`
+	Version     int      `json:"__v"`
 }
 
-// GetAll Retrieves all of the Items from the server
-func (c *Client) GetAllCustomers() (*map[string]Customer, error) {
-	body, err := c.httpRequest("", "GET", bytes.Buffer{})
+// Address is the postal address of a customer
+type Address struct {
+	Street     string `json:"street"`
+	City       string `json:"city"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postalCode,omitempty"`
+	Country    string `json:"country"`
+}
+
+// GetAllCustomers retrieves all of the customers from the server
+func (c *Client) GetAllCustomers() ([]Customer, error) {
+	body, err := c.httpRequest("api/customers", "GET", bytes.Buffer{})
 	if err != nil {
 		return nil, err
 	}
-	customers := map[string]Customer{}
+	customers := []Customer{}
 	err = json.NewDecoder(body).Decode(&customers)
 	if err != nil {
 		return nil, err
 	}
-	return &customers, nil
+	return customers, nil
 }
 
 // GetItem gets an item with a specific name from the server
 func (c *Client) GetCustomer(customerID string) (*Customer, error) {
+	if c.cache != nil {
+		customer := &Customer{}
+		ok, err := c.cache.get(c, "customers", customerID, customer)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return customer, nil
+		}
+	}
 	body, err := c.httpRequest(fmt.Sprintf("api/customers/%s", customerID), "GET", bytes.Buffer{})
 	if err != nil {
 		return nil, err
@@ -44,26 +68,37 @@ func (c *Client) GetCustomer(customerID string) (*Customer, error) {
 
 // create new genre
 func (c *Client) NewCustomer(customer *Customer) (*io.ReadCloser, error) {
+	if c.batching != nil {
+		body, err := c.batched("POST", "api/customers/bulk", customer)
+		if err != nil {
+			return nil, err
+		}
+		return &body, nil
+	}
 	buf := bytes.Buffer{}
 	err := json.NewEncoder(&buf).Encode(customer)
 	if err != nil {
 		return nil, err
 	}
-	body, err := c.httpRequest("api/customers", "POST", buf)
+	body, err := c.httpRequest("api/customers", "POST", buf, withIdempotencyKey())
 	if err != nil {
 		return nil, err
 	}
 	return &body, nil
 }
 
-// UpdateItem updates the values of an item
+// UpdateCustomer updates the values of a customer, provided it is still at the Version it was read at
 func (c *Client) UpdateCustomer(customer *Customer) error {
+	if c.batching != nil {
+		_, err := c.batched("PUT", "api/customers/bulk", customer)
+		return err
+	}
 	buf := bytes.Buffer{}
 	err := json.NewEncoder(&buf).Encode(customer)
 	if err != nil {
 		return err
 	}
-	_, err = c.httpRequest(fmt.Sprintf("api/customers/%s", customer.CustomerID), "PUT", buf)
+	_, err = c.httpRequest(fmt.Sprintf("api/customers/%s", customer.CustomerID), "PUT", buf, ifMatch(customer.Version))
 	if err != nil {
 		return err
 	}
@@ -72,7 +107,11 @@ func (c *Client) UpdateCustomer(customer *Customer) error {
 
 // DeleteItem removes an item from the server
 func (c *Client) DeleteCustomer(customerID string) error {
-	_, err := c.httpRequest(fmt.Sprintf("api/customers/%s", customerID), "DELETE", bytes.Buffer{})
+	if c.batching != nil {
+		_, err := c.batched("DELETE", c.deletePath("api/customers/bulk"), bulkRef{ID: customerID})
+		return err
+	}
+	_, err := c.httpRequest(c.deletePath(fmt.Sprintf("api/customers/%s", customerID)), "DELETE", bytes.Buffer{})
 	if err != nil {
 		return err
 	}
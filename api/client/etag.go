@@ -0,0 +1,98 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+)
+
+// WithConditionalReads makes the Client remember the ETag and body of each GET response for as long as it lives, and
+// send the ETag back in an If-None-Match header the next time it reads the same path. An entity that has not changed
+// then costs a 304 Not Modified response, and the remembered body is used instead
+func WithConditionalReads(conditional bool) Option {
+	return func(c *Client) {
+		if conditional {
+			c.etags = &etagCache{entries: map[string]etagEntry{}}
+		}
+	}
+}
+
+// etagCache holds the last GET response of a Client for each path, with the counts of the reads it served and missed
+type etagCache struct {
+	entries map[string]etagEntry
+	hits    int
+	misses  int
+	sync.Mutex
+}
+
+// etagEntry is the body of a GET response and the ETag it was returned with
+type etagEntry struct {
+	tag  string
+	body []byte
+}
+
+// ETagStats returns how many GETs were answered with 304 Not Modified, and so served from the ETag cache, and how many
+// returned a body. Both are 0 unless the Client was created WithConditionalReads
+func (c *Client) ETagStats() (hits, misses int) {
+	if c.etags == nil {
+		return 0, 0
+	}
+	c.etags.Lock()
+	defer c.etags.Unlock()
+	return c.etags.hits, c.etags.misses
+}
+
+// prepare makes req conditional on the ETag last seen for path, if any
+func (ec *etagCache) prepare(req *http.Request, path string) {
+	ec.Lock()
+	defer ec.Unlock()
+	if entry, ok := ec.entries[path]; ok {
+		req.Header.Set("If-None-Match", entry.tag)
+	}
+}
+
+// response returns the body of resp, a response to a GET of path. The remembered body is returned for a 304, and the
+// body of a 200 with an ETag is remembered, unless it is not valid JSON, as when the response was cut short, so that
+// the next read gets the body again. Other responses are returned as they are
+func (ec *etagCache) response(path string, resp *http.Response) (*http.Response, error) {
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		resp.Body.Close()
+		ec.Lock()
+		entry, ok := ec.entries[path]
+		if ok {
+			ec.hits++
+		}
+		hits, misses := ec.hits, ec.misses
+		ec.Unlock()
+		if !ok {
+			return resp, nil
+		}
+		log.Printf("[DEBUG] GET %s not modified, ETag cache hit (%d hits, %d misses)", path, hits, misses)
+		resp.StatusCode = http.StatusOK
+		resp.Body = ioutil.NopCloser(bytes.NewReader(entry.body))
+		return resp, nil
+	case resp.StatusCode == http.StatusOK && resp.Header.Get("ETag") != "":
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		ec.Lock()
+		if json.Valid(body) {
+			ec.entries[path] = etagEntry{tag: resp.Header.Get("ETag"), body: body}
+		} else {
+			delete(ec.entries, path)
+		}
+		ec.misses++
+		hits, misses := ec.hits, ec.misses
+		ec.Unlock()
+		log.Printf("[DEBUG] GET %s returned a body, ETag cache miss (%d hits, %d misses)", path, hits, misses)
+		resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		return resp, nil
+	}
+	return resp, nil
+}
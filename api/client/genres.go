@@ -3,11 +3,16 @@ package client
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
-	"github.com/spaceapegames/terraform-provider-example/api/server"
+	"github.com/hashicorp/go-uuid"
 )
 
 // Client holds all of the information required to connect to a server
@@ -16,41 +21,136 @@ type Client struct {
 	port       int
 	authToken  string
 	httpClient *http.Client
+	limiter    rateLimiter
+	inFlight   chan struct{}
+	softDelete bool
+	batching   *batching
+	cache      *readCache
+	etags      *etagCache
 }
 
+// Genre is a movie genre. A genre with a ParentID is a subgenre of that genre. Path, the names of the genre and its
+// ancestors from the root down, is only ever returned by the server
 type Genre struct {
-	Name string `json:"name"`
-	ID   string `json:"_id"`
+	Name     string `json:"name"`
+	ID       string `json:"_id"`
+	ParentID string `json:"parentId,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Version  int    `json:"__v"`
+}
+
+// ErrModified is returned by the Update methods when the server rejects the update because the entity has changed
+// since the version that was last read
+var ErrModified = errors.New("modified since it was last read")
+
+// ConflictError is returned when the server rejects a create or update because another record already has the same
+// value for a uniquely indexed field
+type ConflictError struct {
+	Message string `json:"message"`
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	ID      string `json:"_id"`
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("got a 409 status code: %s (conflicts with %s)", e.Message, e.ID)
+}
+
+// FieldError describes why the server rejected the value of one field. Field is the JSON name of the field, with a
+// nested field named by its path, such as address.country
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError is returned when the server rejects a create or update because some of its fields are invalid
+type ValidationError struct {
+	Errors []FieldError `json:"errors"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldError := range e.Errors {
+		messages[i] = fieldError.Message
+	}
+	return fmt.Sprintf("got a 422 status code: %s", strings.Join(messages, "; "))
+}
+
+// requestOption customises a request before httpRequest sends it
+type requestOption func(req *http.Request)
+
+// createRetries is how many times a create carrying an idempotency key is retried after the connection fails
+const createRetries = 3
+
+// withIdempotencyKey tags a create with a key that is unique to it, so that the server replays the original response
+// rather than creating a duplicate when the request is retried. Requests with a key are retried by httpRequest when
+// the connection fails
+func withIdempotencyKey() requestOption {
+	key, err := uuid.GenerateUUID()
+	return func(req *http.Request) {
+		if err == nil {
+			req.Header.Set("Idempotency-Key", key)
+		}
+	}
+}
+
+// ifMatch makes a request conditional on the entity still being at version
+func ifMatch(version int) requestOption {
+	return func(req *http.Request) {
+		req.Header.Set("If-Match", fmt.Sprintf("\"%d\"", version))
+	}
 }
 
 // NewClient returns a new client configured to communicate on a server with the
 // given hostname and port and to send an Authorization Header with the value of
 // token
-func NewClient(hostname string, port int, token string) *Client {
-	return &Client{
+func NewClient(hostname string, port int, token string, opts ...Option) *Client {
+	c := &Client{
 		hostname:   hostname,
 		port:       port,
 		authToken:  token,
 		httpClient: &http.Client{},
 	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
 }
 
-// GetAll Retrieves all of the Items from the server
-func (c *Client) GetAllGenres() (*map[string]server.Item, error) {
-	body, err := c.httpRequest("item", "GET", bytes.Buffer{})
+// WithTimeout makes every request sent by the Client fail once it has not been answered within timeout, so that a
+// store that hangs does not hang Terraform with it. Requests wait for as long as it takes when timeout is 0
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.httpClient.Timeout = timeout
+	}
+}
+
+// GetAllGenres retrieves all of the genres from the server
+func (c *Client) GetAllGenres() ([]Genre, error) {
+	body, err := c.httpRequest("api/genres", "GET", bytes.Buffer{})
 	if err != nil {
 		return nil, err
 	}
-	items := map[string]server.Item{}
-	err = json.NewDecoder(body).Decode(&items)
+	genres := []Genre{}
+	err = json.NewDecoder(body).Decode(&genres)
 	if err != nil {
 		return nil, err
 	}
-	return &items, nil
+	return genres, nil
 }
 
 // GetItem gets an item with a specific name from the server
 func (c *Client) GetGenre(genreID string) (*Genre, error) {
+	if c.cache != nil {
+		genre := &Genre{}
+		ok, err := c.cache.get(c, "genres", genreID, genre)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return genre, nil
+		}
+	}
 	body, err := c.httpRequest(fmt.Sprintf("api/genres/%s", genreID), "GET", bytes.Buffer{})
 	if err != nil {
 		return nil, err
@@ -65,26 +165,33 @@ func (c *Client) GetGenre(genreID string) (*Genre, error) {
 
 // create new genre
 func (c *Client) NewGenre(genre *Genre) (io.ReadCloser, error) {
+	if c.batching != nil {
+		return c.batched("POST", "api/genres/bulk", genre)
+	}
 	buf := bytes.Buffer{}
 	err := json.NewEncoder(&buf).Encode(genre)
 	if err != nil {
 		return nil, err
 	}
-	resBody, err := c.httpRequest("api/genres", "POST", buf)
+	resBody, err := c.httpRequest("api/genres", "POST", buf, withIdempotencyKey())
 	if err != nil {
 		return nil, err
 	}
 	return resBody, nil
 }
 
-// UpdateItem updates the values of an item
+// UpdateGenre updates the values of a genre, provided it is still at the Version it was read at
 func (c *Client) UpdateGenre(genre *Genre) error {
+	if c.batching != nil {
+		_, err := c.batched("PUT", "api/genres/bulk", genre)
+		return err
+	}
 	buf := bytes.Buffer{}
 	err := json.NewEncoder(&buf).Encode(genre)
 	if err != nil {
 		return err
 	}
-	_, err = c.httpRequest(fmt.Sprintf("api/genres/%s", genre.Name), "PUT", buf)
+	_, err = c.httpRequest(fmt.Sprintf("api/genres/%s", genre.ID), "PUT", buf, ifMatch(genre.Version))
 	if err != nil {
 		return err
 	}
@@ -93,42 +200,122 @@ func (c *Client) UpdateGenre(genre *Genre) error {
 
 // DeleteItem removes an item from the server
 func (c *Client) DeleteGenre(genreID string) error {
-	_, err := c.httpRequest(fmt.Sprintf("api/genres/%s", genreID), "DELETE", bytes.Buffer{})
+	if c.batching != nil {
+		_, err := c.batched("DELETE", c.deletePath("api/genres/bulk"), bulkRef{ID: genreID})
+		return err
+	}
+	_, err := c.httpRequest(c.deletePath(fmt.Sprintf("api/genres/%s", genreID)), "DELETE", bytes.Buffer{})
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (c *Client) httpRequest(path, method string, body bytes.Buffer) (closer io.ReadCloser, err error) {
-	req, err := http.NewRequest(method, c.requestPath(path), &body)
+func (c *Client) httpRequest(path, method string, body bytes.Buffer, opts ...requestOption) (closer io.ReadCloser, err error) {
+	if c.cache != nil && method != "GET" {
+		defer c.cache.invalidate(path)
+	}
+	payload := body.Bytes()
+	// Every attempt of the request carries the same ID, so that the server logs of all of them can be found with it
+	requestID, err := uuid.GenerateUUID()
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("x-auth-token", c.authToken)
-	switch method {
-	case "GET":
-	case "DELETE":
-	default:
-		req.Header.Add("Content-Type", "application/json")
-	}
+	var resp *http.Response
+	for attempt, throttled := 0, 0; ; {
+		req, err := http.NewRequest(method, c.requestPath(path), bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Add("x-auth-token", c.authToken)
+		req.Header.Set("X-Request-ID", requestID)
+		switch method {
+		case "GET":
+		case "DELETE":
+		default:
+			req.Header.Add("Content-Type", "application/json")
+		}
+		for _, opt := range opts {
+			opt(req)
+		}
+		if c.etags != nil && method == "GET" {
+			c.etags.prepare(req, path)
+		}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
+		resp, err = c.do(req)
+		if err != nil {
+			if req.Header.Get("Idempotency-Key") == "" || attempt == createRetries {
+				return nil, err
+			}
+			log.Printf("[DEBUG] retrying %s %s with Idempotency-Key %s after error: %s", method, path, req.Header.Get("Idempotency-Key"), err)
+			attempt++
+			time.Sleep(time.Duration(attempt) * 100 * time.Millisecond)
+			continue
+		}
+		if resp.StatusCode != http.StatusTooManyRequests || throttled == rateLimitRetries {
+			break
+		}
+
+		// The server did not process a throttled request, so it is safe to send it again once the server allows it
+		wait := retryAfter(resp)
+		resp.Body.Close()
+		log.Printf("[DEBUG] %s %s was rate limited, retrying in %s", method, path, wait)
+		c.limiter.pause(wait)
+		throttled++
 	}
 
+	if c.etags != nil && method == "GET" {
+		if resp, err = c.etags.response(path, resp); err != nil {
+			return nil, err
+		}
+	}
 	if resp.StatusCode != http.StatusOK {
-		respBody := new(bytes.Buffer)
-		_, err := respBody.ReadFrom(resp.Body)
+		defer resp.Body.Close()
+		if id := resp.Header.Get("X-Request-ID"); id != "" {
+			requestID = id
+		}
+		respBody, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return nil, fmt.Errorf("got a non 200 status code: %v", resp.StatusCode)
+			return nil, fmt.Errorf("got a non 200 status code: %v (request ID %s)", resp.StatusCode, requestID)
 		}
-		return nil, fmt.Errorf("got a non 200 status code: %v - %s", resp.StatusCode, respBody.String())
+		return nil, fmt.Errorf("%w (request ID %s)", statusError(resp.StatusCode, respBody), requestID)
 	}
 	return resp.Body, nil
 }
 
+// statusError returns the error for a response with a status other than 200 and the given body
+func statusError(status int, body []byte) error {
+	switch status {
+	case http.StatusPreconditionFailed:
+		return ErrModified
+	case http.StatusConflict:
+		conflict := &ConflictError{}
+		err := json.Unmarshal(body, conflict)
+		if err != nil {
+			return fmt.Errorf("got a 409 status code: %s", err)
+		}
+		return conflict
+	case http.StatusUnprocessableEntity:
+		// A reused idempotency key is also unprocessable, but is answered in plain text
+		invalid := &ValidationError{}
+		if err := json.Unmarshal(body, invalid); err == nil && len(invalid.Errors) > 0 {
+			return invalid
+		}
+	}
+	return fmt.Errorf("got a non 200 status code: %v - %s", status, body)
+}
+
+// do sends req once the rate limit allows it and there is room for another request in flight. The rate limit is
+// waited for first, so that a request held back by it does not keep one that is ready from going out
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	c.limiter.wait()
+	if c.inFlight != nil {
+		c.inFlight <- struct{}{}
+		defer func() { <-c.inFlight }()
+	}
+	return c.httpClient.Do(req)
+}
+
 func (c *Client) requestPath(path string) string {
 	return fmt.Sprintf("%s:%v/%s", c.hostname, c.port, path)
 }
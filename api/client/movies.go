@@ -7,30 +7,63 @@ import (
 	"io"
 )
 
+// MovieRatings are the MPAA-style ratings a movie can have
+var MovieRatings = []string{"G", "PG", "PG-13", "R", "NC-17"}
+
+// Movie is a movie in the catalog. Genres are sent by ID and read back with their names. Genre is the first of
+// Genres, as servers that only know a single genre per movie return it
 type Movie struct {
-	MovieID string  `json:"_id"`
-	Title   string  `json:"title"`
-	Genre   *Genre  `json:"genre"`
-	Stock   int     `json:"numberInStock"`
-	Rate    float64 `json:"dailyRentalRate"`
+	MovieID     string  `json:"_id"`
+	Title       string  `json:"title"`
+	Genre       *Genre  `json:"genre"`
+	Genres      []Genre `json:"genres"`
+	Stock       int     `json:"numberInStock"`
+	Rate        float64 `json:"dailyRentalRate"`
+	ReleaseYear int     `json:"releaseYear,omitempty"`
+	Rating      string  `json:"rating,omitempty"`
+	Runtime     int     `json:"runtimeMinutes,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Version     int     `json:"__v"`
+}
+
+// GenreIDs returns the IDs of the genres of the movie, falling back to the single Genre when Genres is empty
+func (m *Movie) GenreIDs() []string {
+	ids := []string{}
+	for _, genre := range m.Genres {
+		ids = append(ids, genre.ID)
+	}
+	if len(ids) == 0 && m.Genre != nil {
+		ids = append(ids, m.Genre.ID)
+	}
+	return ids
 }
 
-// GetAll Retrieves all of the Items from the server
-func (c *Client) GetAllMovies() (*map[string]Movie, error) {
-	body, err := c.httpRequest("", "GET", bytes.Buffer{})
+// GetAllMovies retrieves all of the movies from the server
+func (c *Client) GetAllMovies() ([]Movie, error) {
+	body, err := c.httpRequest("api/movies", "GET", bytes.Buffer{})
 	if err != nil {
 		return nil, err
 	}
-	movies := map[string]Movie{}
+	movies := []Movie{}
 	err = json.NewDecoder(body).Decode(&movies)
 	if err != nil {
 		return nil, err
 	}
-	return &movies, nil
+	return movies, nil
 }
 
 // GetItem gets an item with a specific name from the server
 func (c *Client) GetMovie(movieID string) (*Movie, error) {
+	if c.cache != nil {
+		movie := &Movie{}
+		ok, err := c.cache.get(c, "movies", movieID, movie)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return movie, nil
+		}
+	}
 	body, err := c.httpRequest(fmt.Sprintf("api/movies/%s", movieID), "GET", bytes.Buffer{})
 	if err != nil {
 		return nil, err
@@ -45,26 +78,37 @@ func (c *Client) GetMovie(movieID string) (*Movie, error) {
 
 // create new genre
 func (c *Client) NewMovie(movie *Movie) (*io.ReadCloser, error) {
+	if c.batching != nil {
+		body, err := c.batched("POST", "api/movies/bulk", movie)
+		if err != nil {
+			return nil, err
+		}
+		return &body, nil
+	}
 	buf := bytes.Buffer{}
 	err := json.NewEncoder(&buf).Encode(movie)
 	if err != nil {
 		return nil, err
 	}
-	body, err := c.httpRequest("api/movies", "POST", buf)
+	body, err := c.httpRequest("api/movies", "POST", buf, withIdempotencyKey())
 	if err != nil {
 		return nil, err
 	}
 	return &body, nil
 }
 
-// UpdateItem updates the values of an item
+// UpdateMovie updates the values of a movie, provided it is still at the Version it was read at
 func (c *Client) UpdateMovie(movie *Movie) error {
+	if c.batching != nil {
+		_, err := c.batched("PUT", "api/movies/bulk", movie)
+		return err
+	}
 	buf := bytes.Buffer{}
 	err := json.NewEncoder(&buf).Encode(movie)
 	if err != nil {
 		return err
 	}
-	_, err = c.httpRequest(fmt.Sprintf("api/movies/%s", movie.Title), "PUT", buf)
+	_, err = c.httpRequest(fmt.Sprintf("api/movies/%s", movie.MovieID), "PUT", buf, ifMatch(movie.Version))
 	if err != nil {
 		return err
 	}
@@ -73,7 +117,11 @@ func (c *Client) UpdateMovie(movie *Movie) error {
 
 // DeleteItem removes an item from the server
 func (c *Client) DeleteMovie(movieID string) error {
-	_, err := c.httpRequest(fmt.Sprintf("api/movies/%s", movieID), "DELETE", bytes.Buffer{})
+	if c.batching != nil {
+		_, err := c.batched("DELETE", c.deletePath("api/movies/bulk"), bulkRef{ID: movieID})
+		return err
+	}
+	_, err := c.httpRequest(c.deletePath(fmt.Sprintf("api/movies/%s", movieID)), "DELETE", bytes.Buffer{})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// rateLimitRetries is how many times a request rejected with 429 Too Many Requests is retried
+const rateLimitRetries = 5
+
+// Option configures optional behaviour of a Client
+type Option func(*Client)
+
+// WithRateLimit limits the Client to requestsPerSecond requests on average, shared by every request it makes. A
+// requestsPerSecond of zero or less means no limit
+func WithRateLimit(requestsPerSecond float64) Option {
+	return func(c *Client) {
+		if requestsPerSecond <= 0 {
+			return
+		}
+		c.limiter.rate = requestsPerSecond
+		c.limiter.burst = math.Max(1, math.Ceil(requestsPerSecond))
+		c.limiter.tokens = c.limiter.burst
+	}
+}
+
+// WithMaxConcurrentRequests limits the Client to max requests in flight at once. A max of zero or less means no
+// limit
+func WithMaxConcurrentRequests(max int) Option {
+	return func(c *Client) {
+		if max > 0 {
+			c.inFlight = make(chan struct{}, max)
+		}
+	}
+}
+
+// rateLimiter is a token bucket that also holds back every request while the server has asked for a pause with
+// Retry-After. A zero rate only applies the pauses
+type rateLimiter struct {
+	rate        float64
+	burst       float64
+	tokens      float64
+	last        time.Time
+	pausedUntil time.Time
+	sync.Mutex
+}
+
+// wait blocks until the caller may send a request
+func (l *rateLimiter) wait() {
+	time.Sleep(l.reserve())
+}
+
+// reserve takes a token from the bucket and returns how long the caller has to wait before it may use it. Tokens
+// can be taken ahead of time, leaving the bucket in debt, so that waiting callers are served in order
+func (l *rateLimiter) reserve() time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	if l.rate > 0 {
+		if !l.last.IsZero() {
+			l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		}
+		l.last = now
+		l.tokens--
+		if l.tokens < 0 {
+			wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+		}
+	}
+	if pause := l.pausedUntil.Sub(now); pause > wait {
+		wait = pause
+	}
+	return wait
+}
+
+// pause holds back every request for d
+func (l *rateLimiter) pause(d time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	if until := time.Now().Add(d); until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+// retryAfter returns how long a 429 response asks the client to wait, from its Retry-After header in either seconds
+// or HTTP date form. It falls back to one second when the header is missing or invalid
+func retryAfter(resp *http.Response) time.Duration {
+	value := resp.Header.Get("Retry-After")
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return time.Second
+}
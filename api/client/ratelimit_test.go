@@ -0,0 +1,116 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/milamice62/terraplugin/api/server"
+)
+
+// testClient returns a Client for the server at url
+func testClient(t testing.TB, url string, opts ...Option) *Client {
+	host, port, err := net.SplitHostPort(url[len("http://"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClient("http://"+host, p, "token", opts...)
+}
+
+func TestClient_RetryAfter(t *testing.T) {
+	service := server.NewService("", nil, server.WithRateLimit(1, 1))
+	srv := httptest.NewServer(service.Handler())
+	defer srv.Close()
+	c := testClient(t, srv.URL)
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if _, err := c.GetAllGenres(); err != nil {
+			t.Fatalf("request %d: %s", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("expected the second request to wait out Retry-After, both finished in %s", elapsed)
+	}
+}
+
+func TestClient_RateLimit(t *testing.T) {
+	srv := httptest.NewServer(server.NewService("", nil).Handler())
+	defer srv.Close()
+	c := testClient(t, srv.URL, WithRateLimit(20))
+
+	start := time.Now()
+	for i := 0; i < 30; i++ {
+		if _, err := c.GetAllGenres(); err != nil {
+			t.Fatalf("request %d: %s", i, err)
+		}
+	}
+	// The first 20 requests are a burst, the other 10 are spaced 50ms apart
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond {
+		t.Errorf("expected 30 requests at 20 per second to take at least 450ms, took %s", elapsed)
+	}
+}
+
+func TestClient_MaxConcurrentRequests(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		w.Write([]byte("[]"))
+	}))
+	defer srv.Close()
+	c := testClient(t, srv.URL, WithMaxConcurrentRequests(2))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.GetAllGenres(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight > 2 {
+		t.Errorf("expected at most 2 requests in flight, saw %d", maxInFlight)
+	}
+}
+
+func TestClient_RateLimitWaitHoldsNoSlot(t *testing.T) {
+	srv := httptest.NewServer(server.NewService("", nil).Handler())
+	defer srv.Close()
+	c := testClient(t, srv.URL, WithMaxConcurrentRequests(1))
+
+	c.limiter.pause(200 * time.Millisecond)
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.GetAllGenres()
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if held := len(c.inFlight); held != 0 {
+		t.Errorf("expected a request waiting on the rate limit to hold no slot, %d held", held)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
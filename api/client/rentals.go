@@ -19,22 +19,32 @@ type RentalID struct {
 	CustomerID string `json:"customerId"`
 }
 
-// GetAll Retrieves all of the Items from the server
-func (c *Client) GetAllRentals() (*map[string]Rental, error) {
-	body, err := c.httpRequest("", "GET", bytes.Buffer{})
+// GetAllRentals retrieves all of the rentals from the server
+func (c *Client) GetAllRentals() ([]Rental, error) {
+	body, err := c.httpRequest("api/rentals", "GET", bytes.Buffer{})
 	if err != nil {
 		return nil, err
 	}
-	rental := map[string]Rental{}
-	err = json.NewDecoder(body).Decode(&rental)
+	rentals := []Rental{}
+	err = json.NewDecoder(body).Decode(&rentals)
 	if err != nil {
 		return nil, err
 	}
-	return &rental, nil
+	return rentals, nil
 }
 
 // GetItem gets an item with a specific name from the server
 func (c *Client) GetRental(rentalID string) (*Rental, error) {
+	if c.cache != nil {
+		rental := &Rental{}
+		ok, err := c.cache.get(c, "rentals", rentalID, rental)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return rental, nil
+		}
+	}
 	body, err := c.httpRequest(fmt.Sprintf("api/rentals/%s", rentalID), "GET", bytes.Buffer{})
 	if err != nil {
 		return nil, err
@@ -55,13 +65,40 @@ func (c *Client) NewRental(rentalID *RentalID) (*io.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
-	body, err := c.httpRequest("api/rentals", "POST", buf)
+	body, err := c.httpRequest("api/rentals", "POST", buf, withIdempotencyKey())
 	if err != nil {
 		return nil, err
 	}
 	return &body, nil
 }
 
+// RentalBatch is the customer and movies of a batch of rentals opened together
+type RentalBatch struct {
+	CustomerID string   `json:"customerId"`
+	MovieIDs   []string `json:"movieIds"`
+}
+
+// NewRentals opens a rental of each movie in movieIDs for a customer, returning the rentals in the same order. Either
+// every rental is opened or none is
+func (c *Client) NewRentals(customerID string, movieIDs []string) ([]Rental, error) {
+	buf := bytes.Buffer{}
+	err := json.NewEncoder(&buf).Encode(&RentalBatch{CustomerID: customerID, MovieIDs: movieIDs})
+	if err != nil {
+		return nil, err
+	}
+	body, err := c.httpRequest("api/rentals/batch", "POST", buf, withIdempotencyKey())
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	rentals := []Rental{}
+	err = json.NewDecoder(body).Decode(&rentals)
+	if err != nil {
+		return nil, err
+	}
+	return rentals, nil
+}
+
 // UpdateItem updates the values of an item
 func (c *Client) UpdateRental(rental *Rental) error {
 	buf := bytes.Buffer{}
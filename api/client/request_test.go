@@ -0,0 +1,45 @@
+package client
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/milamice62/terraplugin/api/server"
+)
+
+func TestClient_RequestID(t *testing.T) {
+	handler := server.NewService("", nil).Handler()
+	var sent []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = append(sent, r.Header.Get("X-Request-ID"))
+		handler.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+	c := testClient(t, srv.URL)
+
+	_, err := c.GetGenre("5ee19f2a1363f7c0493761e9")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected a not found error, got %v", err)
+	}
+	if len(sent) != 1 || sent[0] == "" || !strings.HasSuffix(err.Error(), "(request ID "+sent[0]+")") {
+		t.Errorf("expected the error to name the request ID %v the client sent, got %q", sent, err)
+	}
+}
+
+func TestClient_ValidationError(t *testing.T) {
+	srv := httptest.NewServer(server.NewService("", nil).Handler())
+	defer srv.Close()
+	c := testClient(t, srv.URL)
+
+	_, err := c.NewCustomer(&Customer{Name: "Jo", Phone: "12345", Email: "jane"})
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Errors) != 2 || invalid.Errors[0].Field != "name" || invalid.Errors[1].Field != "email" {
+		t.Fatalf("expected the invalid name and email to be reported, got %v", err)
+	}
+	if !strings.Contains(err.Error(), `"email" must be a valid email`) {
+		t.Errorf("expected the error to carry every message, got %q", err)
+	}
+}
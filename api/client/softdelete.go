@@ -0,0 +1,46 @@
+package client
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// WithSoftDelete makes DeleteGenre, DeleteCustomer and DeleteMovie only mark the record as deleted on the server, so
+// that it can be restored until the server purges it
+func WithSoftDelete(soft bool) Option {
+	return func(c *Client) {
+		c.softDelete = soft
+	}
+}
+
+// deletePath returns the path to DELETE a record at, asking for a soft delete when the Client is configured for it
+func (c *Client) deletePath(path string) string {
+	if c.softDelete {
+		return path + "?soft=true"
+	}
+	return path
+}
+
+// RestoreGenre undoes the soft delete of a genre
+func (c *Client) RestoreGenre(genreID string) error {
+	return c.restore(fmt.Sprintf("api/genres/%s/restore", genreID))
+}
+
+// RestoreCustomer undoes the soft delete of a customer
+func (c *Client) RestoreCustomer(customerID string) error {
+	return c.restore(fmt.Sprintf("api/customers/%s/restore", customerID))
+}
+
+// RestoreMovie undoes the soft delete of a movie
+func (c *Client) RestoreMovie(movieID string) error {
+	return c.restore(fmt.Sprintf("api/movies/%s/restore", movieID))
+}
+
+func (c *Client) restore(path string) error {
+	body, err := c.httpRequest(path, "POST", bytes.Buffer{})
+	if err != nil {
+		return err
+	}
+	body.Close()
+	return nil
+}
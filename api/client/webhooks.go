@@ -0,0 +1,113 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// WebhookEvents are the events a webhook can subscribe to
+var WebhookEvents = []string{
+	"genre.created", "genre.updated", "genre.deleted", "genre.restored",
+	"customer.created", "customer.updated", "customer.deleted", "customer.restored",
+	"movie.created", "movie.updated", "movie.deleted", "movie.restored", "movie.stock_changed",
+	"rental.opened", "rental.closed",
+}
+
+// Webhook is a subscription to change notifications from the server. Secret is only ever sent, the server does not
+// return it
+type Webhook struct {
+	ID      string   `json:"_id,omitempty"`
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Secret  string   `json:"secret,omitempty"`
+	Version int      `json:"__v"`
+}
+
+// DeadLetter is a webhook delivery that failed every attempt
+type DeadLetter struct {
+	WebhookID string          `json:"webhookId"`
+	URL       string          `json:"url"`
+	Payload   json.RawMessage `json:"payload"`
+	Attempts  int             `json:"attempts"`
+	LastError string          `json:"lastError"`
+	FailedAt  string          `json:"failedAt"`
+}
+
+// GetWebhook gets a webhook with a specific ID from the server
+func (c *Client) GetWebhook(webhookID string) (*Webhook, error) {
+	body, err := c.httpRequest(fmt.Sprintf("api/webhooks/%s", webhookID), "GET", bytes.Buffer{})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	webhook := &Webhook{}
+	err = json.NewDecoder(body).Decode(webhook)
+	if err != nil {
+		return nil, err
+	}
+	return webhook, nil
+}
+
+// NewWebhook creates a webhook on the server, setting the ID and Version of webhook to those it was given
+func (c *Client) NewWebhook(webhook *Webhook) error {
+	buf := bytes.Buffer{}
+	err := json.NewEncoder(&buf).Encode(webhook)
+	if err != nil {
+		return err
+	}
+	body, err := c.httpRequest("api/webhooks", "POST", buf, withIdempotencyKey())
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	created := &Webhook{}
+	err = json.NewDecoder(body).Decode(created)
+	if err != nil {
+		return err
+	}
+	webhook.ID = created.ID
+	webhook.Version = created.Version
+	return nil
+}
+
+// UpdateWebhook updates the values of a webhook, provided it is still at the Version it was read at. The secret is
+// left unchanged when Secret is empty
+func (c *Client) UpdateWebhook(webhook *Webhook) error {
+	buf := bytes.Buffer{}
+	err := json.NewEncoder(&buf).Encode(webhook)
+	if err != nil {
+		return err
+	}
+	body, err := c.httpRequest(fmt.Sprintf("api/webhooks/%s", webhook.ID), "PUT", buf, ifMatch(webhook.Version))
+	if err != nil {
+		return err
+	}
+	body.Close()
+	return nil
+}
+
+// DeleteWebhook removes a webhook from the server
+func (c *Client) DeleteWebhook(webhookID string) error {
+	body, err := c.httpRequest(fmt.Sprintf("api/webhooks/%s", webhookID), "DELETE", bytes.Buffer{})
+	if err != nil {
+		return err
+	}
+	body.Close()
+	return nil
+}
+
+// GetDeadLetters retrieves the deliveries to a webhook that failed every attempt, oldest first
+func (c *Client) GetDeadLetters(webhookID string) ([]DeadLetter, error) {
+	body, err := c.httpRequest(fmt.Sprintf("api/webhooks/%s/dead-letters", webhookID), "GET", bytes.Buffer{})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	deadLetters := []DeadLetter{}
+	err = json.NewDecoder(body).Decode(&deadLetters)
+	if err != nil {
+		return nil, err
+	}
+	return deadLetters, nil
+}
@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// AuditEvent records one request that changed, or tried to change, the store
+type AuditEvent struct {
+	ID         string          `json:"_id"`
+	Time       time.Time       `json:"time"`
+	Subject    string          `json:"subject,omitempty"`
+	Token      string          `json:"token"`
+	Method     string          `json:"method"`
+	Route      string          `json:"route"`
+	Collection string          `json:"collection"`
+	EntityID   string          `json:"entityId,omitempty"`
+	Status     int             `json:"status"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+}
+
+// auditLog holds the audit events recorded by a Service and, when path is set, appends each of them to that JSONL
+// file
+type auditLog struct {
+	path   string
+	events []AuditEvent
+	sync.Mutex
+}
+
+// WithAuditLog makes the Service append every audit event to the JSONL file at path, see LoadAuditLog for reading
+// them back
+func WithAuditLog(path string) Option {
+	return func(s *Service) {
+		s.audit.path = path
+	}
+}
+
+// LoadAuditLog reads the events already in the configured audit log file into memory, so that GetAuditEvents
+// returns them along with the events recorded from now on
+func (s *Service) LoadAuditLog() error {
+	if s.audit.path == "" {
+		return nil
+	}
+	f, err := os.Open(s.audit.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var events []AuditEvent
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var event AuditEvent
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			return err
+		}
+		events = append(events, event)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	s.audit.Lock()
+	defer s.audit.Unlock()
+	s.audit.events = append(events, s.audit.events...)
+	log.Printf("loaded %d audit events from %s", len(events), s.audit.path)
+	return nil
+}
+
+// record adds event to the audit log. A failure to write the audit log file is logged, rather than failing the
+// request that has already been handled
+func (a *auditLog) record(event AuditEvent) {
+	a.Lock()
+	defer a.Unlock()
+
+	a.events = append(a.events, event)
+	if a.path == "" {
+		return
+	}
+	line, err := json.Marshal(event)
+	if err == nil {
+		err = appendLine(a.path, line)
+	}
+	if err != nil {
+		log.Printf("error writing audit log %s - %s", a.path, err)
+	}
+}
+
+// appendLine appends line and a newline to the file at path, creating it when it does not exist
+func appendLine(path string, line []byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// audited records an audit event for every entity a request to handlerFunc, which changes entities of collection,
+// changed, and notifies the webhooks subscribed to the changes to collection. The handler captures the state of each
+// entity before and after its change with track. A request that changed nothing, such as one that was rejected, is
+// recorded as one event for the entity named by the id route variable. Expects to be wrapped in auth() so that the
+// token has been checked
+func (s *Service) audited(collection string, handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tracked := &changes{}
+		rec := &responseRecorder{ResponseWriter: w}
+		handlerFunc(rec, r.WithContext(context.WithValue(r.Context(), changesKey{}, tracked)))
+
+		list := tracked.list
+		if len(list) == 0 {
+			list = []change{{collection: collection, id: mux.Vars(r)["id"]}}
+		}
+		for _, c := range list {
+			event := AuditEvent{
+				ID:         newObjectID(),
+				Time:       time.Now().UTC(),
+				Token:      tokenFingerprint(r),
+				Method:     r.Method,
+				Route:      routeTemplate(r),
+				Collection: c.collection,
+				EntityID:   c.id,
+				Status:     rec.status,
+				Before:     c.before,
+				After:      c.after,
+			}
+			if claims := ClaimsFromContext(r.Context()); claims != nil {
+				event.Subject = claims.Subject
+			}
+			s.audit.record(event)
+
+			// The handler notifies changes to other collections itself, such as the stock of a rented movie
+			if c.collection != collection || rec.status != http.StatusOK {
+				continue
+			}
+			if name := changeEvent(collection, event.Before, event.After); name != "" {
+				data := event.After
+				if data == nil {
+					data = event.Before
+				}
+				s.notify(name, data)
+			}
+		}
+	}
+}
+
+// changesKey is the context key of the changes made by an audited request
+type changesKey struct{}
+
+// change is the JSON of an entity before and after a request changed it, nil where the entity did not exist
+type change struct {
+	collection string
+	id         string
+	before     json.RawMessage
+	after      json.RawMessage
+}
+
+// changes are the changes made by an audited request, in the order it made them
+type changes struct {
+	list []change
+}
+
+// track captures the JSON of the entity of collection with ID id before r changes it, and returns a func that captures
+// it after the change and adds the change to the audit event of r. Does nothing when r is not audited. Does not lock
+// access to the store, expects the calling method to hold the lock of collection from before the change until it has
+// called the returned func, so that the audit event shows exactly the change made by r
+func (s *Service) track(r *http.Request, collection, id string) func() {
+	tracked, ok := r.Context().Value(changesKey{}).(*changes)
+	if !ok {
+		return func() {}
+	}
+	c := change{collection: collection, id: id, before: s.entityJSON(collection, id)}
+	return func() {
+		c.after = s.entityJSON(collection, id)
+		tracked.list = append(tracked.list, c)
+	}
+}
+
+// changeEvent returns the name of the webhook event for a change to an entity of collection from before to after,
+// or an empty string when nothing changed or the collection has no events
+func changeEvent(collection string, before, after json.RawMessage) string {
+	var change string
+	switch {
+	case before == nil && after != nil:
+		change = "created"
+	case before != nil && after == nil:
+		change = "deleted"
+	case !softDeleted(before) && softDeleted(after):
+		change = "deleted"
+	case softDeleted(before) && !softDeleted(after):
+		change = "restored"
+	case before != nil && !bytes.Equal(before, after):
+		change = "updated"
+	default:
+		return ""
+	}
+	switch collection {
+	case "genres", "customers", "movies":
+		return singular(collection) + "." + change
+	case "rentals":
+		if change == "created" {
+			return "rental.opened"
+		}
+		if change == "deleted" {
+			return "rental.closed"
+		}
+	}
+	return ""
+}
+
+// softDeleted reports whether the JSON of an entity has it marked as soft deleted
+func softDeleted(entity json.RawMessage) bool {
+	var marker struct {
+		DeletedAt *time.Time `json:"deletedAt"`
+	}
+	return entity != nil && json.Unmarshal(entity, &marker) == nil && marker.DeletedAt != nil
+}
+
+// entityJSON returns the JSON of the entity of collection with ID id, or nil when there is none. Does not lock access
+// to the store, expects this to be done by the calling method
+func (s *Service) entityJSON(collection, id string) json.RawMessage {
+	var entity interface{}
+	var ok bool
+	switch collection {
+	case "genres":
+		entity, ok = s.genres[id]
+	case "customers":
+		entity, ok = s.customers[id]
+	case "movies":
+		entity, ok = s.movies[id]
+	case "rentals":
+		entity, ok = s.rentals[id]
+	case "webhooks":
+		var webhook Webhook
+		webhook, ok = s.webhooks[id]
+		entity = redactWebhook(webhook)
+	}
+	if !ok {
+		return nil
+	}
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return nil
+	}
+	return data
+}
+
+// tokenFingerprint identifies the auth token of r without recording the token itself
+func tokenFingerprint(r *http.Request) string {
+	token := r.Header.Get("x-auth-token")
+	if token == "" {
+		token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:8])
+}
+
+// routeTemplate returns the path template of the route r was matched to, or its path when there is none
+func routeTemplate(r *http.Request) string {
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			return template
+		}
+	}
+	return r.URL.Path
+}
+
+// GetAuditEvents returns the audit events in the order they were recorded. The entity, collection and subject
+// query parameters each narrow the events down to those with that entity ID, collection or token subject
+func (s *Service) GetAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	entity := query.Get("entity")
+	collection := query.Get("collection")
+	subject := query.Get("subject")
+
+	s.audit.Lock()
+	defer s.audit.Unlock()
+
+	events := []AuditEvent{}
+	for _, event := range s.audit.events {
+		if entity != "" && event.EntityID != entity {
+			continue
+		}
+		if collection != "" && event.Collection != collection {
+			continue
+		}
+		if subject != "" && event.Subject != subject {
+			continue
+		}
+		events = append(events, event)
+	}
+	writeJSON(w, events)
+}
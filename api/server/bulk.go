@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// MaxBulkItems is the most entities a single bulk request can create, update or delete
+const MaxBulkItems = 1000
+
+// BulkResult is the outcome of one item of a bulk request: the status and body the single-entity request for the item
+// would have been answered with. Body holds a JSON response and Error a plain text one
+type BulkResult struct {
+	Status int             `json:"status"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// bulk handles a JSON array of entities by passing each of them, in order, to the single-entity handlerFunc and
+// responding with a BulkResult for each. The items succeed or fail on their own, so a 200 response can hold failed
+// items. With byID each item names its entity by _id, which is passed to handlerFunc as the id route variable, and
+// an __v in the item is sent as If-Match
+func (s *Service) bulk(handlerFunc http.HandlerFunc, byID bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var items []json.RawMessage
+		if !decodeBody(w, r, &items) {
+			return
+		}
+		if len(items) == 0 || len(items) > MaxBulkItems {
+			http.Error(w, fmt.Sprintf("The request must contain between 1 and %d items.", MaxBulkItems), http.StatusBadRequest)
+			return
+		}
+
+		results := make([]BulkResult, 0, len(items))
+		for _, item := range items {
+			results = append(results, bulkItem(handlerFunc, r, item, byID))
+		}
+		writeJSON(w, results)
+	}
+}
+
+// bulkItem handles item of the bulk request r with handlerFunc, as though it was the body of a request of its own
+func bulkItem(handlerFunc http.HandlerFunc, r *http.Request, item json.RawMessage, byID bool) BulkResult {
+	req := r.WithContext(r.Context())
+	req.Body = ioutil.NopCloser(bytes.NewReader(item))
+	req.ContentLength = int64(len(item))
+	req.Header = r.Header.Clone()
+	req.Header.Del("If-Match")
+	req.Header.Del("Idempotency-Key")
+	if byID {
+		var ref struct {
+			ID      string `json:"_id"`
+			Version *int   `json:"__v"`
+		}
+		if err := json.Unmarshal(item, &ref); err != nil || ref.ID == "" {
+			return BulkResult{Status: http.StatusBadRequest, Error: "\"_id\" is required"}
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": ref.ID})
+		if ref.Version != nil {
+			req.Header.Set("If-Match", etag(*ref.Version))
+		}
+	}
+
+	rec := &bufferedResponse{header: http.Header{}}
+	handlerFunc(rec, req)
+	result := BulkResult{Status: rec.status}
+	if result.Status == 0 {
+		result.Status = http.StatusOK
+	}
+	if strings.HasPrefix(rec.header.Get("Content-Type"), "application/json") {
+		result.Body = json.RawMessage(bytes.TrimSpace(rec.body.Bytes()))
+	} else {
+		result.Error = strings.TrimSpace(rec.body.String())
+	}
+	return result
+}
+
+// bufferedResponse captures a response without sending it, for the items of bulk requests and conditional GETs
+type bufferedResponse struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *bufferedResponse) Header() http.Header {
+	return rec.header
+}
+
+func (rec *bufferedResponse) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+}
+
+func (rec *bufferedResponse) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// benchDataset returns a store of n genres, customers and movies, with IDs that benchRequest can address by index
+func benchDataset(n int) *Dataset {
+	dataset := &Dataset{}
+	for i := 0; i < n; i++ {
+		genre := Genre{ID: fmt.Sprintf("5ee19f2a1363f7c049%06d", i), Name: fmt.Sprintf("genre %d", i)}
+		dataset.Genres = append(dataset.Genres, genre)
+		dataset.Customers = append(dataset.Customers, Customer{
+			ID:    fmt.Sprintf("5ee6fe17de7e8d5eb0%06d", i),
+			Name:  fmt.Sprintf("customer %d", i),
+			Phone: fmt.Sprintf("555-%06d", i),
+		})
+		dataset.Movies = append(dataset.Movies, Movie{
+			ID:            fmt.Sprintf("5ef199b9edf86a20de%06d", i),
+			Title:         fmt.Sprintf("movie %d", i),
+			Genre:         genre,
+			NumberInStock: 10,
+		})
+	}
+	return dataset
+}
+
+// benchRequest sends a request straight to handler, without a network connection, and returns the response status
+func benchRequest(handler http.Handler, method, path, body string) int {
+	return serveRequest(handler, method, path, body, "").Code
+}
+
+// serveRequest sends a request straight to handler, with an If-Match header when ifMatch is set, and returns the
+// recorded response
+func serveRequest(handler http.Handler, method, path, body, ifMatch string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("x-auth-token", "token")
+	req.Header.Set("Content-Type", "application/json")
+	if ifMatch != "" {
+		req.Header.Set("If-Match", ifMatch)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// benchWorkload runs a mix of reads and writes in parallel against a store of 100 of each entity, one write in
+// every writeEvery requests
+func benchWorkload(b *testing.B, writeEvery int, opts ...Option) {
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	s := NewService("", nil, opts...)
+	if err := s.Seed(benchDataset(100)); err != nil {
+		b.Fatal(err)
+	}
+	handler := s.Handler()
+
+	var next int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			n := int(atomic.AddInt64(&next, 1))
+			i := n % 100
+			switch {
+			case n%writeEvery == 0:
+				benchRequest(handler, "PUT", fmt.Sprintf("/api/customers/5ee6fe17de7e8d5eb0%06d", i),
+					fmt.Sprintf(`{"name":"customer %d","phone":"555-%06d","isGold":%t}`, i, i, n%2 == 0))
+			case n%3 == 0:
+				benchRequest(handler, "GET", fmt.Sprintf("/api/movies/5ef199b9edf86a20de%06d", i), "")
+			case n%3 == 1:
+				benchRequest(handler, "GET", fmt.Sprintf("/api/genres/5ee19f2a1363f7c049%06d", i), "")
+			default:
+				benchRequest(handler, "GET", "/api/movies", "")
+			}
+		}
+	})
+}
+
+func BenchmarkService_Reads(b *testing.B) {
+	benchWorkload(b, 1<<30)
+}
+
+func BenchmarkService_Mixed(b *testing.B) {
+	benchWorkload(b, 10)
+}
+
+func BenchmarkService_MixedPersisted(b *testing.B) {
+	dir, err := ioutil.TempDir("", "store-bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	benchWorkload(b, 10, WithStoragePath(filepath.Join(dir, "store.json")))
+}
+
+// TestService_Stress has many clients create, update, rent and read entities at once, and then checks that no update
+// was lost and that the entities embedded in others and the storage file agree with the store. Run it with -race
+func TestService_Stress(t *testing.T) {
+	const workers, rounds = 32, 12
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+	dir, err := ioutil.TempDir("", "store-stress")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.json")
+
+	s := NewService("", nil, WithStoragePath(path))
+	shared := benchDataset(1)
+	// Each worker holds a copy of the shared movie, and a second one while it swaps them
+	shared.Movies[0].NumberInStock = 2 * workers
+	if err := s.Seed(shared); err != nil {
+		t.Fatal(err)
+	}
+	sharedMovie := shared.Movies[0].ID
+	handler := s.Handler()
+
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			errs <- stressWorker(handler, w, rounds, sharedMovie)
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if t.Failed() {
+		return
+	}
+
+	var genres []Genre
+	var movies []Movie
+	json.Unmarshal(serveRequest(handler, "GET", "/api/genres", "", "").Body.Bytes(), &genres)
+	json.Unmarshal(serveRequest(handler, "GET", "/api/movies", "", "").Body.Bytes(), &movies)
+	if len(genres) != workers+1 || len(movies) != workers+1 {
+		t.Fatalf("expected %d genres and movies, got %d and %d", workers+1, len(genres), len(movies))
+	}
+	byID := map[string]Genre{}
+	for _, genre := range genres {
+		byID[genre.ID] = genre
+		if genre.ID != shared.Genres[0].ID && genre.Version != rounds {
+			t.Errorf("expected every update of genre %s to be kept, got version %d", genre.ID, genre.Version)
+		}
+	}
+	for _, movie := range movies {
+		stored := byID[movie.Genre.ID]
+		if movie.Genre.Name != stored.Name || movie.Genre.Version != stored.Version {
+			t.Errorf("movie %s embeds genre %+v, which does not match the stored %+v", movie.ID, movie.Genre, stored)
+		}
+		if movie.ID == sharedMovie && movie.NumberInStock != workers {
+			t.Errorf("expected one copy of the shared movie to be rented by each worker, %d left", movie.NumberInStock)
+		}
+		if movie.ID != sharedMovie && movie.NumberInStock != 1 {
+			t.Errorf("expected every rental of movie %s to be returned, %d in stock", movie.ID, movie.NumberInStock)
+		}
+	}
+
+	dataset, err := LoadDataset(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dataset.Genres) != workers+1 || len(dataset.Customers) != workers+1 || len(dataset.Rentals) != workers {
+		t.Errorf("expected the storage file to hold the whole store, got %d genres, %d customers and %d rentals",
+			len(dataset.Genres), len(dataset.Customers), len(dataset.Rentals))
+	}
+}
+
+// stressWorker creates a genre, a customer and a movie of its own, and then for each round updates them, rents and
+// returns its movie, swaps its rental of the shared movie for a new one and reads the store
+func stressWorker(handler http.Handler, w, rounds int, sharedMovie string) error {
+	var genre Genre
+	var customer Customer
+	var movie Movie
+	var sharedRental Rental
+	creates := []struct {
+		path, body string
+		out        interface{}
+	}{
+		{"/api/genres", fmt.Sprintf(`{"name":"stress genre %03d"}`, w), &genre},
+		{"/api/customers", fmt.Sprintf(`{"name":"stress customer %03d","phone":"555-1%05d"}`, w, w), &customer},
+		{"/item", fmt.Sprintf(`{"name":"item%03d","tags":["a","b","c"]}`, w), nil},
+	}
+	for _, create := range creates {
+		rec := serveRequest(handler, "POST", create.path, create.body, "")
+		if rec.Code != http.StatusOK {
+			return fmt.Errorf("worker %d: POST %s: %d %s", w, create.path, rec.Code, rec.Body)
+		}
+		if create.out != nil {
+			json.Unmarshal(rec.Body.Bytes(), create.out)
+		}
+	}
+	rec := serveRequest(handler, "POST", "/api/movies", fmt.Sprintf(`{"title":"stress movie %03d","genreIds":["%s"],"numberInStock":1}`, w, genre.ID), "")
+	if rec.Code != http.StatusOK {
+		return fmt.Errorf("worker %d: POST /api/movies: %d %s", w, rec.Code, rec.Body)
+	}
+	json.Unmarshal(rec.Body.Bytes(), &movie)
+
+	for round := 0; round < rounds; round++ {
+		rec := serveRequest(handler, "PUT", "/api/genres/"+genre.ID, fmt.Sprintf(`{"name":"stress genre %03d round %d"}`, w, round), etag(genre.Version))
+		if rec.Code != http.StatusOK {
+			return fmt.Errorf("worker %d: PUT genre in round %d: %d %s", w, round, rec.Code, rec.Body)
+		}
+		json.Unmarshal(rec.Body.Bytes(), &genre)
+
+		rec = serveRequest(handler, "PUT", "/api/customers/"+customer.ID, fmt.Sprintf(`{"name":"stress customer %03d","phone":"555-1%05d","isGold":%t}`, w, w, round%2 == 0), etag(customer.Version))
+		if rec.Code != http.StatusOK {
+			return fmt.Errorf("worker %d: PUT customer in round %d: %d %s", w, round, rec.Code, rec.Body)
+		}
+		json.Unmarshal(rec.Body.Bytes(), &customer)
+
+		returned := []string{}
+		if sharedRental.ID != "" {
+			returned = append(returned, sharedRental.ID)
+		}
+		for _, movieID := range []string{movie.ID, sharedMovie} {
+			var rental Rental
+			rec = serveRequest(handler, "POST", "/api/rentals", fmt.Sprintf(`{"customerId":"%s","movieId":"%s"}`, customer.ID, movieID), "")
+			if rec.Code != http.StatusOK {
+				return fmt.Errorf("worker %d: renting %s in round %d: %d %s", w, movieID, round, rec.Code, rec.Body)
+			}
+			json.Unmarshal(rec.Body.Bytes(), &rental)
+			if movieID == movie.ID {
+				returned = append(returned, rental.ID)
+			} else {
+				sharedRental = rental
+			}
+		}
+		for _, rentalID := range returned {
+			if rec := serveRequest(handler, "DELETE", "/api/rentals/"+rentalID, "", ""); rec.Code != http.StatusOK {
+				return fmt.Errorf("worker %d: returning rental %s in round %d: %d %s", w, rentalID, round, rec.Code, rec.Body)
+			}
+		}
+
+		for _, path := range []string{"/api/movies", "/api/genres/" + genre.ID, "/api/movies/" + movie.ID, "/api/customers", "/item", fmt.Sprintf("/item/item%03d", w)} {
+			if rec := serveRequest(handler, "GET", path, "", ""); rec.Code != http.StatusOK {
+				return fmt.Errorf("worker %d: GET %s in round %d: %d %s", w, path, round, rec.Code, rec.Body)
+			}
+		}
+	}
+	return nil
+}
+
+// TestService_AuditConcurrentWrites has many clients update one customer and rent one movie at once, and then checks
+// that every audit event shows the change made by its own request. Run it with -race
+func TestService_AuditConcurrentWrites(t *testing.T) {
+	const workers = 16
+
+	log.SetOutput(ioutil.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	s := NewService("", nil)
+	dataset := benchDataset(1)
+	dataset.Movies[0].NumberInStock = workers
+	if err := s.Seed(dataset); err != nil {
+		t.Fatal(err)
+	}
+	customer, movie := dataset.Customers[0], dataset.Movies[0]
+	handler := s.Handler()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			serveRequest(handler, "PUT", "/api/customers/"+customer.ID, fmt.Sprintf(`{"name":"customer %03d","phone":"%s"}`, w, customer.Phone), "")
+			serveRequest(handler, "POST", "/api/rentals", fmt.Sprintf(`{"customerId":"%s","movieId":"%s"}`, customer.ID, movie.ID), "")
+		}(w)
+	}
+	wg.Wait()
+
+	for _, entity := range []string{customer.ID, movie.ID} {
+		var events []AuditEvent
+		json.Unmarshal(serveRequest(handler, "GET", "/api/audit?entity="+entity, "", "").Body.Bytes(), &events)
+		if len(events) != workers {
+			t.Fatalf("expected %d audit events for %s, got %d", workers, entity, len(events))
+		}
+		// Each change takes the entity from one version to the next, so that the events chain up by version
+		afters := map[int]json.RawMessage{}
+		for _, event := range events {
+			var before, after struct {
+				Version int `json:"__v"`
+			}
+			json.Unmarshal(event.Before, &before)
+			json.Unmarshal(event.After, &after)
+			if after.Version != before.Version+1 {
+				t.Errorf("expected event %s to go from one version to the next, got %s and %s", event.ID, event.Before, event.After)
+			}
+			afters[after.Version] = event.After
+		}
+		for _, event := range events {
+			var before struct {
+				Version int `json:"__v"`
+			}
+			json.Unmarshal(event.Before, &before)
+			if after, ok := afters[before.Version]; ok && !bytes.Equal(after, event.Before) {
+				t.Errorf("expected event %s to start from %s, got %s", event.ID, after, event.Before)
+			}
+		}
+	}
+}
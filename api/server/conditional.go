@@ -0,0 +1,54 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// conditional tags the response to a GET with a hash of its body, and answers 304 Not Modified instead when the
+// If-None-Match header of the request matches that tag. A version is not used as the tag, since the JSON of an entity
+// can change without its version, as when a snapshot is restored
+func conditional(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &bufferedResponse{header: http.Header{}}
+		handlerFunc(rec, r)
+
+		for key, values := range rec.header {
+			w.Header()[key] = values
+		}
+		status := rec.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status == http.StatusOK {
+			tag := contentTag(rec.body.Bytes())
+			w.Header().Set("ETag", tag)
+			if noneMatch(r.Header.Get("If-None-Match"), tag) {
+				w.Header().Del("Content-Type")
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.WriteHeader(status)
+		w.Write(rec.body.Bytes())
+	}
+}
+
+// contentTag returns a strong entity tag for a response body
+func contentTag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "\"" + hex.EncodeToString(sum[:16]) + "\""
+}
+
+// noneMatch reports whether the If-None-Match header value matches tag, comparing tags weakly as the header requires
+func noneMatch(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
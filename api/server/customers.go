@@ -0,0 +1,193 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// GetCustomers returns all of the Customers that exist in the store, ordered by name
+func (s *Service) GetCustomers(w http.ResponseWriter, r *http.Request) {
+	unlock := s.lock(reading("customers"))
+	customers := make([]Customer, 0, len(s.customers))
+	for _, customer := range s.customers {
+		if customer.DeletedAt != nil && !includeDeleted(r) {
+			continue
+		}
+		customers = append(customers, customer)
+	}
+	unlock()
+
+	sort.Slice(customers, func(i, j int) bool { return customers[i].Name < customers[j].Name })
+	writeJSON(w, customers)
+}
+
+// GetCustomer handles retrieving a Customer with a specific ID
+func (s *Service) GetCustomer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(reading("customers"))
+	customer, ok := s.customers[id]
+	unlock()
+
+	if !ok || (customer.DeletedAt != nil && !includeDeleted(r)) {
+		http.Error(w, "The customer with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, customer)
+}
+
+// PostCustomer handles adding a new Customer. A customer that would break a unique index is rejected with 409. The
+// customer is a member since today unless the request says otherwise
+func (s *Service) PostCustomer(w http.ResponseWriter, r *http.Request) {
+	var customer Customer
+	if !decodeBody(w, r, &customer) {
+		return
+	}
+	if !checkValid(w, validateCustomer(&customer)) {
+		return
+	}
+
+	unlock := s.lock(writing("customers"))
+	defer unlock()
+
+	if !s.checkUnique(w, "customers", "", customer) {
+		return
+	}
+
+	customer.ID = newObjectID()
+	customer.Version = 0
+	if customer.MemberSince == "" {
+		customer.MemberSince = time.Now().UTC().Format(dateLayout)
+	}
+	tracked := s.track(r, "customers", customer.ID)
+	s.customers[customer.ID] = customer
+	tracked()
+	s.saved("customers")
+	log.Printf("added customer: %s", customer.ID)
+	writeJSON(w, customer)
+}
+
+// PutCustomer handles updating a Customer with a specific ID. The membership date is kept when the request does not
+// set one. A request with an If-Match header is rejected when the customer has changed since that version
+func (s *Service) PutCustomer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var customer Customer
+	if !decodeBody(w, r, &customer) {
+		return
+	}
+	if !checkValid(w, validateCustomer(&customer)) {
+		return
+	}
+
+	unlock := s.lock(writing("customers"))
+	defer unlock()
+
+	current, ok := s.customers[id]
+	if !ok || current.DeletedAt != nil {
+		http.Error(w, "The customer with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	if !checkIfMatch(w, r, "customer", current.Version) {
+		return
+	}
+	if !s.checkUnique(w, "customers", id, customer) {
+		return
+	}
+
+	customer.ID = id
+	customer.Version = current.Version + 1
+	if customer.MemberSince == "" {
+		customer.MemberSince = current.MemberSince
+	}
+	tracked := s.track(r, "customers", id)
+	s.customers[id] = customer
+	tracked()
+	s.saved("customers")
+	log.Printf("updated customer: %s", id)
+	writeJSON(w, customer)
+}
+
+// DeleteCustomer handles removing a Customer with a specific ID, responding with the removed Customer. With ?soft=true the customer is
+// only marked as deleted, so that it can be restored until it is purged. A soft-deleted customer can still be removed for
+// good
+func (s *Service) DeleteCustomer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(writing("customers"))
+	defer unlock()
+
+	customer, ok := s.customers[id]
+	if !ok || (customer.DeletedAt != nil && softDelete(r)) {
+		http.Error(w, "The customer with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+
+	if softDelete(r) {
+		now := time.Now().UTC()
+		customer.DeletedAt = &now
+		customer.Version++
+		tracked := s.track(r, "customers", id)
+		s.customers[id] = customer
+		tracked()
+		s.saved("customers")
+		log.Printf("soft deleted customer: %s", id)
+		writeJSON(w, customer)
+		return
+	}
+	tracked := s.track(r, "customers", id)
+	delete(s.customers, id)
+	tracked()
+	s.saved("customers")
+	log.Printf("deleted customer: %s", id)
+	writeJSON(w, customer)
+}
+
+// validateCustomer returns the reasons customer cannot be stored, none when it is valid
+func validateCustomer(customer *Customer) fieldErrors {
+	var errs fieldErrors
+	errs.add("name", validateLength("name", customer.Name, 5, 50))
+	if strings.TrimSpace(customer.Name) != customer.Name {
+		errs.add("name", "\"name\" must not start or end with whitespace")
+	}
+	errs.add("phone", validateLength("phone", customer.Phone, 5, 50))
+	if customer.Email != "" {
+		if msg := validateLength("email", customer.Email, 3, 255); msg != "" {
+			errs.add("email", msg)
+		} else if address, err := mail.ParseAddress(customer.Email); err != nil || address.Address != customer.Email {
+			errs.add("email", "\"email\" must be a valid email")
+		}
+	}
+	if customer.Address != nil {
+		errs = append(errs, validateAddress(customer.Address)...)
+	}
+	if customer.MemberSince != "" {
+		since, err := time.Parse(dateLayout, customer.MemberSince)
+		if err != nil {
+			errs.add("memberSince", "\"memberSince\" must be a date in YYYY-MM-DD format")
+		} else if since.After(time.Now().UTC()) {
+			errs.add("memberSince", "\"memberSince\" must not be in the future")
+		}
+	}
+	errs.add("notes", validateLength("notes", customer.Notes, 0, 1024))
+	return errs
+}
+
+// validateAddress returns the reasons address cannot be stored, none when it is valid
+func validateAddress(address *Address) fieldErrors {
+	var errs fieldErrors
+	errs.add("address.street", validateLength("address.street", address.Street, 1, 255))
+	errs.add("address.city", validateLength("address.city", address.City, 1, 100))
+	errs.add("address.region", validateLength("address.region", address.Region, 0, 100))
+	errs.add("address.postalCode", validateLength("address.postalCode", address.PostalCode, 0, 20))
+	if len(address.Country) != 2 || strings.ToUpper(address.Country) != address.Country {
+		errs.add("address.country", "\"address.country\" must be a two letter ISO 3166 country code")
+	}
+	return errs
+}
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The actions a FaultRule can take on a request it matches
+const (
+	// FaultDelay holds the request for DelayMs before handling it
+	FaultDelay = "delay"
+	// FaultStatus answers the request with Status without handling it
+	FaultStatus = "status"
+	// FaultBadBody handles the request but sends only the first half of the response body
+	FaultBadBody = "bad_body"
+	// FaultReset resets the connection without handling the request
+	FaultReset = "reset"
+)
+
+// FaultActions lists the actions a FaultRule can take
+var FaultActions = []string{FaultDelay, FaultStatus, FaultBadBody, FaultReset}
+
+// FaultRule makes the Service misbehave on the requests it matches, to test how clients cope with a failing store.
+// Route is a path.Match pattern for the request path, such as /api/movies/*, and Method the request method, any
+// method when it is empty. A rule fires on every EveryNth request it matches when EveryNth is set, otherwise with
+// Probability when that is set, and otherwise on every request it matches. It stops firing once it has fired Times
+// times, unless Times is 0
+type FaultRule struct {
+	Route       string  `json:"route" yaml:"route"`
+	Method      string  `json:"method,omitempty" yaml:"method"`
+	Probability float64 `json:"probability,omitempty" yaml:"probability"`
+	EveryNth    int     `json:"everyNth,omitempty" yaml:"every_nth"`
+	Times       int     `json:"times,omitempty" yaml:"times"`
+	Action      string  `json:"action" yaml:"action"`
+	Status      int     `json:"status,omitempty" yaml:"status"`
+	DelayMs     int     `json:"delayMs,omitempty" yaml:"delay_ms"`
+}
+
+// FaultRules is the body of the requests and responses of /admin/faults
+type FaultRules struct {
+	Rules []FaultRule `json:"rules"`
+}
+
+// faultInjector holds the fault rules of a Service with how many requests each has matched and fired on
+type faultInjector struct {
+	rules []faultState
+	sync.Mutex
+}
+
+// faultState is a FaultRule with the requests it has matched and fired on since it was set
+type faultState struct {
+	FaultRule
+	matched int
+	fired   int
+}
+
+// WithFaultRules makes the Service misbehave on the requests matched by rules, see FaultRule. The rules can be
+// replaced at runtime through /admin/faults. Use ValidateFaultRules to check them first
+func WithFaultRules(rules ...FaultRule) Option {
+	return func(s *Service) {
+		s.faults.set(rules)
+	}
+}
+
+// ValidateFaultRules returns an error describing every invalid field of rules, or nil when they are all valid
+func ValidateFaultRules(rules []FaultRule) error {
+	errs := validateFaultRules(rules)
+	if len(errs) == 0 {
+		return nil
+	}
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Message
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+// validateFaultRules returns the reasons rules cannot be set, none when they are valid. Fields are named by their
+// path from a FaultRules body
+func validateFaultRules(rules []FaultRule) fieldErrors {
+	var errs fieldErrors
+	for i, rule := range rules {
+		field := func(name string) string { return fmt.Sprintf("rules.%d.%s", i, name) }
+		if _, err := path.Match(rule.Route, "/"); err != nil || !strings.HasPrefix(rule.Route, "/") {
+			errs.add(field("route"), fmt.Sprintf("%q must be a path pattern starting with /", field("route")))
+		}
+		if rule.Probability < 0 || rule.Probability > 1 {
+			errs.add(field("probability"), fmt.Sprintf("%q must be between 0 and 1", field("probability")))
+		}
+		if rule.EveryNth < 0 {
+			errs.add(field("everyNth"), fmt.Sprintf("%q must not be negative", field("everyNth")))
+		}
+		if rule.Times < 0 {
+			errs.add(field("times"), fmt.Sprintf("%q must not be negative", field("times")))
+		}
+		switch rule.Action {
+		case FaultDelay:
+			if rule.DelayMs <= 0 {
+				errs.add(field("delayMs"), fmt.Sprintf("%q must be positive", field("delayMs")))
+			}
+		case FaultStatus:
+			if rule.Status < 100 || rule.Status > 599 {
+				errs.add(field("status"), fmt.Sprintf("%q must be an HTTP status code", field("status")))
+			}
+		case FaultBadBody, FaultReset:
+		default:
+			errs.add(field("action"), fmt.Sprintf("%q must be one of %s", field("action"), strings.Join(FaultActions, ", ")))
+		}
+	}
+	return errs
+}
+
+// set replaces the rules, starting their counts over
+func (f *faultInjector) set(rules []FaultRule) {
+	f.Lock()
+	defer f.Unlock()
+	f.rules = make([]faultState, len(rules))
+	for i, rule := range rules {
+		f.rules[i] = faultState{FaultRule: rule}
+	}
+}
+
+// list returns the rules
+func (f *faultInjector) list() []FaultRule {
+	f.Lock()
+	defer f.Unlock()
+	rules := make([]FaultRule, len(f.rules))
+	for i, state := range f.rules {
+		rules[i] = state.FaultRule
+	}
+	return rules
+}
+
+// fire returns the first rule that fires on r, if any. Every rule matching r counts it, whether it fires or not
+func (f *faultInjector) fire(r *http.Request) (FaultRule, bool) {
+	f.Lock()
+	defer f.Unlock()
+	var fired *faultState
+	for i := range f.rules {
+		state := &f.rules[i]
+		if state.Method != "" && !strings.EqualFold(state.Method, r.Method) {
+			continue
+		}
+		if ok, _ := path.Match(state.Route, r.URL.Path); !ok {
+			continue
+		}
+		state.matched++
+		if fired != nil || (state.Times > 0 && state.fired >= state.Times) {
+			continue
+		}
+		switch {
+		case state.EveryNth > 0:
+			if state.matched%state.EveryNth != 0 {
+				continue
+			}
+		case state.Probability > 0:
+			if rand.Float64() >= state.Probability {
+				continue
+			}
+		}
+		state.fired++
+		fired = state
+	}
+	if fired == nil {
+		return FaultRule{}, false
+	}
+	return fired.FaultRule, true
+}
+
+// injectFaults applies the first fault rule that fires on each request. The admin endpoints are exempt, so that the
+// rules can always be changed
+func (s *Service) injectFaults(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/admin/") {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rule, ok := s.faults.fire(r)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		log.Printf("injecting %s fault into %s %s, request ID %s", rule.Action, r.Method, r.URL.Path, RequestIDFromContext(r.Context()))
+
+		switch rule.Action {
+		case FaultDelay:
+			select {
+			case <-time.After(time.Duration(rule.DelayMs) * time.Millisecond):
+			case <-r.Context().Done():
+				return
+			}
+			h.ServeHTTP(w, r)
+		case FaultStatus:
+			http.Error(w, "Injected fault.", rule.Status)
+		case FaultBadBody:
+			rec := &bufferedResponse{header: http.Header{}}
+			h.ServeHTTP(rec, r)
+			for key, values := range rec.header {
+				w.Header()[key] = values
+			}
+			if rec.status != 0 {
+				w.WriteHeader(rec.status)
+			}
+			body := rec.body.Bytes()
+			w.Write(body[:len(body)/2])
+		case FaultReset:
+			resetConnection(w)
+		}
+	})
+}
+
+// resetConnection closes the connection of w without a response, resetting it rather than shutting it down cleanly
+// where the connection allows it
+func resetConnection(w http.ResponseWriter) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		log.Println("cannot reset a connection that cannot be hijacked")
+		return
+	}
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// GetFaults writes the fault rules of the Service
+func (s *Service) GetFaults(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, FaultRules{Rules: s.faults.list()})
+}
+
+// PutFaults replaces the fault rules of the Service with those in the request body
+func (s *Service) PutFaults(w http.ResponseWriter, r *http.Request) {
+	var rules FaultRules
+	if !decodeBody(w, r, &rules) {
+		return
+	}
+	if !checkValid(w, validateFaultRules(rules.Rules)) {
+		return
+	}
+	s.faults.set(rules.Rules)
+	log.Printf("set %d fault rules", len(rules.Rules))
+	writeJSON(w, FaultRules{Rules: s.faults.list()})
+}
+
+// DeleteFaults removes every fault rule of the Service
+func (s *Service) DeleteFaults(w http.ResponseWriter, r *http.Request) {
+	s.faults.set(nil)
+	log.Println("removed every fault rule")
+	writeJSON(w, FaultRules{Rules: s.faults.list()})
+}
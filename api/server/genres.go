@@ -0,0 +1,249 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// GenrePathSeparator separates the names of the genres in the path of a subgenre
+const GenrePathSeparator = " > "
+
+// GetGenres returns all of the Genres that exist in the store, ordered by name
+func (s *Service) GetGenres(w http.ResponseWriter, r *http.Request) {
+	unlock := s.lock(reading("genres"))
+	genres := make([]Genre, 0, len(s.genres))
+	for _, genre := range s.genres {
+		if genre.DeletedAt != nil && !includeDeleted(r) {
+			continue
+		}
+		genres = append(genres, s.withPath(genre))
+	}
+	unlock()
+
+	sort.Slice(genres, func(i, j int) bool { return genres[i].Name < genres[j].Name })
+	writeJSON(w, genres)
+}
+
+// GetGenre handles retrieving a Genre with a specific ID
+func (s *Service) GetGenre(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(reading("genres"))
+	genre, ok := s.genres[id]
+	genre = s.withPath(genre)
+	unlock()
+
+	if !ok || (genre.DeletedAt != nil && !includeDeleted(r)) {
+		http.Error(w, "The genre with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, genre)
+}
+
+// PostGenre handles adding a new Genre. A genre that would break a unique index is rejected with 409, and one whose
+// parent is not in the store with 400
+func (s *Service) PostGenre(w http.ResponseWriter, r *http.Request) {
+	var genre Genre
+	if !decodeBody(w, r, &genre) {
+		return
+	}
+	if !checkValid(w, validateGenre(&genre)) {
+		return
+	}
+
+	unlock := s.lock(writing("genres"))
+	defer unlock()
+
+	genre.ID = newObjectID()
+	if !s.checkParent(w, genre) || !s.checkUnique(w, "genres", "", genre) {
+		return
+	}
+
+	genre.Path = ""
+	genre.Version = 0
+	tracked := s.track(r, "genres", genre.ID)
+	s.genres[genre.ID] = genre
+	tracked()
+	s.saved("genres")
+	log.Printf("added genre: %s", genre.ID)
+	writeJSON(w, s.withPath(genre))
+}
+
+// PutGenre handles updating a Genre with a specific ID. Movies embedding the genre are updated to match. A request
+// with an If-Match header is rejected when the genre has changed since that version, and one that would make the genre
+// its own ancestor with 400
+func (s *Service) PutGenre(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var genre Genre
+	if !decodeBody(w, r, &genre) {
+		return
+	}
+	if !checkValid(w, validateGenre(&genre)) {
+		return
+	}
+
+	unlock := s.lock(writing("genres"), writing("movies"))
+	defer unlock()
+
+	current, ok := s.genres[id]
+	if !ok || current.DeletedAt != nil {
+		http.Error(w, "The genre with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	if !checkIfMatch(w, r, "genre", current.Version) {
+		return
+	}
+	genre.ID = id
+	if !s.checkParent(w, genre) || !s.checkUnique(w, "genres", id, genre) {
+		return
+	}
+
+	genre.Path = ""
+	genre.Version = current.Version + 1
+	tracked := s.track(r, "genres", id)
+	s.genres[id] = genre
+	tracked()
+	if genre.Name != current.Name || genre.ParentID != current.ParentID {
+		s.touchSubgenres(r, id)
+	}
+	for movieID, movie := range s.movies {
+		embedded := false
+		genres := make([]Genre, len(movie.Genres))
+		for i := range movie.Genres {
+			genres[i] = movie.Genres[i]
+			if genres[i].ID == id {
+				genres[i] = genre
+				embedded = true
+			}
+		}
+		if embedded {
+			movie.Genres = genres
+			movie.Genre = genres[0]
+			movie.Version++
+			tracked := s.track(r, "movies", movieID)
+			s.movies[movieID] = movie
+			tracked()
+		}
+	}
+	s.saved("genres", "movies")
+	log.Printf("updated genre: %s", id)
+	writeJSON(w, s.withPath(genre))
+}
+
+// DeleteGenre handles removing a Genre with a specific ID, responding with the removed Genre. With ?soft=true the genre is
+// only marked as deleted, so that it can be restored until it is purged. A soft-deleted genre can still be removed for
+// good. A genre that still has subgenres is rejected with 400
+func (s *Service) DeleteGenre(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(writing("genres"))
+	defer unlock()
+
+	genre, ok := s.genres[id]
+	if !ok || (genre.DeletedAt != nil && softDelete(r)) {
+		http.Error(w, "The genre with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	// A soft-deleted subgenre would be left without its parent once it was restored
+	if s.hasChildren(id, !softDelete(r)) {
+		http.Error(w, "The genre has subgenres, delete them first.", http.StatusBadRequest)
+		return
+	}
+
+	if softDelete(r) {
+		now := time.Now().UTC()
+		genre.DeletedAt = &now
+		genre.Version++
+		tracked := s.track(r, "genres", id)
+		s.genres[id] = genre
+		tracked()
+		s.saved("genres")
+		log.Printf("soft deleted genre: %s", id)
+		writeJSON(w, genre)
+		return
+	}
+	tracked := s.track(r, "genres", id)
+	delete(s.genres, id)
+	tracked()
+	s.saved("genres")
+	log.Printf("deleted genre: %s", id)
+	writeJSON(w, genre)
+}
+
+// validateGenre returns the reasons genre cannot be stored, none when it is valid
+func validateGenre(genre *Genre) fieldErrors {
+	var errs fieldErrors
+	errs.add("name", validateLength("name", genre.Name, 5, 50))
+	return errs
+}
+
+// withPath returns genre with its Path filled in. Does not lock access to the store, expects this to be done by the
+// calling method
+func (s *Service) withPath(genre Genre) Genre {
+	names := []string{genre.Name}
+	// A parent missing from the store ends the path, and a path is never longer than the number of genres
+	for parentID := genre.ParentID; parentID != "" && len(names) <= len(s.genres); {
+		parent, ok := s.genres[parentID]
+		if !ok {
+			break
+		}
+		names = append([]string{parent.Name}, names...)
+		parentID = parent.ParentID
+	}
+	genre.Path = strings.Join(names, GenrePathSeparator)
+	return genre
+}
+
+// checkParent writes a 400 response and returns false when the parent of genre is not in the store, or when genre is
+// among the ancestors of its parent. Does not lock access to the store, expects this to be done by the calling method
+func (s *Service) checkParent(w http.ResponseWriter, genre Genre) bool {
+	if genre.ParentID == "" {
+		return true
+	}
+	parent, ok := s.genres[genre.ParentID]
+	if !ok || parent.DeletedAt != nil {
+		http.Error(w, "Invalid parent genre.", http.StatusBadRequest)
+		return false
+	}
+	seen := map[string]bool{}
+	for id := genre.ParentID; id != "" && !seen[id]; id = s.genres[id].ParentID {
+		if id == genre.ID {
+			http.Error(w, "The parent genre would make the genre its own ancestor.", http.StatusBadRequest)
+			return false
+		}
+		seen[id] = true
+	}
+	return true
+}
+
+// hasChildren reports whether any genre has the genre with the given ID as its parent. Soft-deleted genres are only
+// counted when includeDeleted is set. Does not lock access to the store, expects this to be done by the calling method
+func (s *Service) hasChildren(id string, includeDeleted bool) bool {
+	for _, genre := range s.genres {
+		if genre.ParentID == id && (includeDeleted || genre.DeletedAt == nil) {
+			return true
+		}
+	}
+	return false
+}
+
+// touchSubgenres bumps the version of every subgenre of the genre with the given ID, at any depth, since their paths
+// include its name, so that an If-Match for a stale path is rejected. Each change is tracked as part of r. Does not
+// lock access to the store, expects this to be done by the calling method
+func (s *Service) touchSubgenres(r *http.Request, id string) {
+	for childID, genre := range s.genres {
+		if genre.ParentID == id {
+			genre.Version++
+			tracked := s.track(r, "genres", childID)
+			s.genres[childID] = genre
+			tracked()
+			s.touchSubgenres(r, childID)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// Healthz answers 200 for as long as the server is able to handle requests at all
+func (s *Service) Healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// Readyz answers 200 when the server is ready to serve the store: it is not shutting down and, when the store is
+// persisted, the storage file has been loaded and the last write to it succeeded. It answers 503 with the reason
+// otherwise
+func (s *Service) Readyz(w http.ResponseWriter, r *http.Request) {
+	s.lifecycle.Lock()
+	shuttingDown := s.lifecycle.shuttingDown
+	s.lifecycle.Unlock()
+	s.storage.Lock()
+	defer s.storage.Unlock()
+
+	switch {
+	case shuttingDown:
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+	case s.storage.path != "" && !s.storage.loaded:
+		http.Error(w, fmt.Sprintf("storage file %s has not been loaded", s.storage.path), http.StatusServiceUnavailable)
+	case s.storage.err != nil:
+		http.Error(w, fmt.Sprintf("error writing storage file %s - %s", s.storage.path, s.storage.err), http.StatusServiceUnavailable)
+	default:
+		fmt.Fprintln(w, "ok")
+	}
+}
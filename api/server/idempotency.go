@@ -0,0 +1,163 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultIdempotencyWindow is how long the response to a request with an Idempotency-Key is replayed for when no
+// other window is configured
+const DefaultIdempotencyWindow = 24 * time.Hour
+
+// idempotentResponse is the recorded response to a request carrying an Idempotency-Key. done is closed once the
+// original request has finished and the response has been recorded
+type idempotentResponse struct {
+	requestHash [sha256.Size]byte
+	expires     time.Time
+	done        chan struct{}
+	stored      bool
+	status      int
+	header      http.Header
+	body        []byte
+}
+
+// idempotencyKeys holds the recorded responses by caller, route and key
+type idempotencyKeys struct {
+	window    time.Duration
+	responses map[string]*idempotentResponse
+	sync.Mutex
+}
+
+// WithIdempotencyWindow sets how long the response to a request with an Idempotency-Key is replayed for
+func WithIdempotencyWindow(window time.Duration) Option {
+	return func(s *Service) {
+		s.idempotency.window = window
+	}
+}
+
+// forget discards the recorded responses, so that requests retried afterwards are handled afresh. Requests still being
+// handled keep theirs
+func (k *idempotencyKeys) forget() {
+	k.Lock()
+	defer k.Unlock()
+	for id, response := range k.responses {
+		if response.stored {
+			delete(k.responses, id)
+		}
+	}
+}
+
+// responseRecorder passes a response through to the client while keeping a copy of it
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent replays the original response to a request that is retried with the same Idempotency-Key header by the
+// same caller on the same route, instead of handling it again. Other callers and routes can use the same key for
+// requests of their own. A retry that arrives while the original request is still being
+// handled waits for it. Reusing a key for a different request body is rejected with 422. Responses with a 5xx status
+// are not recorded, so that the request can be retried
+func (s *Service) idempotent(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			handlerFunc(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(body)
+		id := idempotencyCaller(r) + " " + r.Method + " " + r.URL.Path + " " + key
+
+		for {
+			s.idempotency.Lock()
+			now := time.Now()
+			for k, response := range s.idempotency.responses {
+				if response.stored && now.After(response.expires) {
+					delete(s.idempotency.responses, k)
+				}
+			}
+			response, ok := s.idempotency.responses[id]
+			if !ok {
+				response = &idempotentResponse{requestHash: hash, done: make(chan struct{})}
+				s.idempotency.responses[id] = response
+				s.idempotency.Unlock()
+				s.record(w, r, handlerFunc, id, response)
+				return
+			}
+			s.idempotency.Unlock()
+
+			<-response.done
+			if !response.stored {
+				// The original request failed without a response worth replaying and has been forgotten, so
+				// this one is handled afresh
+				continue
+			}
+			if response.requestHash != hash {
+				http.Error(w, "Idempotency-Key has already been used for a different request.", http.StatusUnprocessableEntity)
+				return
+			}
+			log.Printf("replaying response for Idempotency-Key %s", key)
+			for name, values := range response.header {
+				w.Header()[name] = values
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(response.status)
+			w.Write(response.body)
+			return
+		}
+	}
+}
+
+// idempotencyCaller identifies who made r: the subject of its token, or the token itself when it carries no claims
+func idempotencyCaller(r *http.Request) string {
+	if claims := ClaimsFromContext(r.Context()); claims != nil && claims.Subject != "" {
+		return "subject:" + claims.Subject
+	}
+	return "token:" + tokenFingerprint(r)
+}
+
+// record handles the first request with an Idempotency-Key and stores its response for replay
+func (s *Service) record(w http.ResponseWriter, r *http.Request, handlerFunc http.HandlerFunc, id string, response *idempotentResponse) {
+	rec := &responseRecorder{ResponseWriter: w}
+	defer func() {
+		s.idempotency.Lock()
+		if rec.status == 0 || rec.status >= http.StatusInternalServerError {
+			delete(s.idempotency.responses, id)
+		} else {
+			response.stored = true
+			response.status = rec.status
+			response.header = w.Header().Clone()
+			response.body = rec.body.Bytes()
+			response.expires = time.Now().Add(s.idempotency.window)
+		}
+		s.idempotency.Unlock()
+		close(response.done)
+	}()
+	handlerFunc(rec, r)
+}
@@ -20,10 +20,14 @@ type Item struct {
 
 // GetItems returns all of the Items that exist in the server
 func (s *Service) GetItems(w http.ResponseWriter, r *http.Request) {
-	s.RLock()
-	defer s.RUnlock()
-	s.shuffleItemTags()
-	err := json.NewEncoder(w).Encode(s.items)
+	unlock := s.lock(reading("items"))
+	items := make(map[string]Item, len(s.items))
+	for name, item := range s.items {
+		items[name] = shuffledTags(item)
+	}
+	unlock()
+
+	err := json.NewEncoder(w).Encode(items)
 	if err != nil {
 		log.Println(err)
 	}
@@ -43,13 +47,16 @@ func (s *Service) PostItem(w http.ResponseWriter, r *http.Request) {
 	}
 
 	whiteSpace := regexp.MustCompile(`\s+`)
+	var errs fieldErrors
 	if whiteSpace.Match([]byte(item.Name)) {
-		http.Error(w, "item names cannot contain whitespace", 400)
+		errs.add("name", "item names cannot contain whitespace")
+	}
+	if !checkValid(w, errs) {
 		return
 	}
 
-	s.Lock()
-	defer s.Unlock()
+	unlock := s.lock(writing("items"))
+	defer unlock()
 
 	if s.itemExists(item.Name) {
 		http.Error(w, fmt.Sprintf("item %s already exists", item.Name), http.StatusBadRequest)
@@ -84,8 +91,8 @@ func (s *Service) PutItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.Lock()
-	defer s.Unlock()
+	unlock := s.lock(writing("items"))
+	defer unlock()
 
 	if !s.itemExists(itemName) {
 		log.Printf("item %s does not exist", itemName)
@@ -109,8 +116,8 @@ func (s *Service) DeleteItem(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
-	s.Lock()
-	defer s.Unlock()
+	unlock := s.lock(writing("items"))
+	defer unlock()
 
 	if !s.itemExists(itemName) {
 		http.Error(w, fmt.Sprintf("item %s does not exists", itemName), http.StatusNotFound)
@@ -134,15 +141,16 @@ func (s *Service) GetItem(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	s.RLock()
-	defer s.RUnlock()
-	s.shuffleItemTags()
-	if !s.itemExists(itemName) {
+	unlock := s.lock(reading("items"))
+	item, ok := s.items[itemName]
+	unlock()
+
+	if !ok {
 		http.Error(w, "not found", http.StatusNotFound)
 		return
 	}
 
-	err := json.NewEncoder(w).Encode(s.items[itemName])
+	err := json.NewEncoder(w).Encode(shuffledTags(item))
 	if err != nil {
 		log.Println(err)
 		return
@@ -158,13 +166,17 @@ func (s *Service) itemExists(itemName string) bool {
 	return false
 }
 
-// suffleItemTags shuffles the order of the tags within each item in the itemService.Does not lock access
-// to the itemService, expects this to be done by the calling method
-func (s *Service) shuffleItemTags() {
-	for _, item := range s.items {
-		for i := range item.Tags {
-			j := rand.Intn(i + 1)
-			item.Tags[i], item.Tags[j] = item.Tags[j], item.Tags[i]
-		}
+// shuffledTags returns a copy of item with its tags in a random order, leaving the stored item as it is
+func shuffledTags(item Item) Item {
+	if item.Tags == nil {
+		return item
+	}
+	tags := make([]string, len(item.Tags))
+	copy(tags, item.Tags)
+	for i := range tags {
+		j := rand.Intn(i + 1)
+		tags[i], tags[j] = tags[j], tags[i]
 	}
+	item.Tags = tags
+	return item
 }
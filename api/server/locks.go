@@ -0,0 +1,88 @@
+package server
+
+import (
+	"sync"
+)
+
+// lockOrder is the order the locks of the store collections are taken in. A request that needs more than one
+// collection always locks them in this order, so that two requests can never each hold a lock the other waits for
+var lockOrder = [...]string{"items", "genres", "customers", "movies", "rentals", "webhooks"}
+
+// collectionLocks guards each store collection with its own lock, so that requests to different collections do not
+// wait on each other. Entities in the store are never changed in place, a change stores a new value, so a handler can
+// copy what it reads out of a collection and release the lock before it encodes the response
+type collectionLocks map[string]*sync.RWMutex
+
+// newCollectionLocks returns a lock for each collection in lockOrder
+func newCollectionLocks() collectionLocks {
+	locks := collectionLocks{}
+	for _, collection := range lockOrder {
+		locks[collection] = &sync.RWMutex{}
+	}
+	return locks
+}
+
+// access is the lock a request needs on a collection, to read it or to change it
+type access struct {
+	collection string
+	write      bool
+}
+
+// reading returns the access of a request that reads collection
+func reading(collection string) access {
+	return access{collection: collection}
+}
+
+// writing returns the access of a request that changes collection
+func writing(collection string) access {
+	return access{collection: collection, write: true}
+}
+
+// writingAll returns the accesses of a request that changes every collection but items
+func writingAll() []access {
+	return []access{writing("genres"), writing("customers"), writing("movies"), writing("rentals"), writing("webhooks")}
+}
+
+// lock takes the locks the accesses need, in lockOrder, and returns the function that releases them. A collection that
+// is both read and changed is locked for writing
+func (s *Service) lock(accesses ...access) (unlock func()) {
+	// modes holds, by position in lockOrder, 0 for a collection that is not locked, 1 for reading and 2 for writing
+	var modes [len(lockOrder)]int
+	for _, a := range accesses {
+		i := lockIndex(a.collection)
+		if a.write {
+			modes[i] = 2
+		} else if modes[i] == 0 {
+			modes[i] = 1
+		}
+	}
+
+	for i, collection := range lockOrder {
+		switch modes[i] {
+		case 1:
+			s.locks[collection].RLock()
+		case 2:
+			s.locks[collection].Lock()
+		}
+	}
+	return func() {
+		for i := len(lockOrder) - 1; i >= 0; i-- {
+			switch modes[i] {
+			case 1:
+				s.locks[lockOrder[i]].RUnlock()
+			case 2:
+				s.locks[lockOrder[i]].Unlock()
+			}
+		}
+	}
+}
+
+// lockIndex returns the position of collection in lockOrder
+func lockIndex(collection string) int {
+	for i, c := range lockOrder {
+		if c == collection {
+			return i
+		}
+	}
+	panic("no lock for collection " + collection)
+}
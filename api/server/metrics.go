@@ -0,0 +1,191 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// latencyBuckets are the upper bounds, in seconds, of the request latency histogram
+var latencyBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// routeKey identifies the requests to one route of the server
+type routeKey struct {
+	method string
+	route  string
+}
+
+// routeMetrics are the request counts, by status code, and the latency histogram of one route
+type routeMetrics struct {
+	codes   map[int]uint64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// metrics are the request metrics of a Service, exposed in the Prometheus text format by Metrics
+type metrics struct {
+	inFlight int64
+	routes   map[routeKey]*routeMetrics
+	sync.Mutex
+}
+
+// observe records a request to route that was answered with status after d
+func (m *metrics) observe(key routeKey, status int, d time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.routes == nil {
+		m.routes = map[routeKey]*routeMetrics{}
+	}
+	rm, ok := m.routes[key]
+	if !ok {
+		rm = &routeMetrics{codes: map[int]uint64{}, buckets: make([]uint64, len(latencyBuckets))}
+		m.routes[key] = rm
+	}
+	rm.codes[status]++
+	rm.count++
+	rm.sum += d.Seconds()
+	for i, le := range latencyBuckets {
+		if d.Seconds() <= le {
+			rm.buckets[i]++
+		}
+	}
+}
+
+// statusWriter remembers the status code and the size of the body of the response written through it
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Hijack hands the connection over to the caller, for the fault rules that reset it
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the connection cannot be hijacked")
+	}
+	return hijacker.Hijack()
+}
+
+// instrument records the count, status and latency of every request handled by h, labelled with the path template
+// of the first of routers with a matching route. Requests that match no route are labelled "unmatched"
+func (s *Service) instrument(h http.Handler, routers ...*mux.Router) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := routeKey{method: r.Method, route: "unmatched"}
+		for _, router := range routers {
+			match := mux.RouteMatch{}
+			if router.Match(r, &match) && match.Route != nil {
+				if template, err := match.Route.GetPathTemplate(); err == nil {
+					key.route = template
+					break
+				}
+			}
+		}
+
+		atomic.AddInt64(&s.metrics.inFlight, 1)
+		defer atomic.AddInt64(&s.metrics.inFlight, -1)
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		s.metrics.observe(key, sw.status, time.Since(start))
+	})
+}
+
+// Metrics writes the request metrics of the server and the number of entities in each store collection in the
+// Prometheus text format
+func (s *Service) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	unlock := s.lock(reading("genres"), reading("customers"), reading("movies"), reading("rentals"))
+	entities := map[string]int{
+		"genres":    len(s.genres),
+		"customers": len(s.customers),
+		"movies":    len(s.movies),
+		"rentals":   len(s.rentals),
+	}
+	unlock()
+
+	s.metrics.Lock()
+	defer s.metrics.Unlock()
+
+	keys := make([]routeKey, 0, len(s.metrics.routes))
+	for key := range s.metrics.routes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].route != keys[j].route {
+			return keys[i].route < keys[j].route
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	fmt.Fprintln(w, "# HELP store_http_requests_total Requests handled, by method, route and status code.")
+	fmt.Fprintln(w, "# TYPE store_http_requests_total counter")
+	for _, key := range keys {
+		rm := s.metrics.routes[key]
+		codes := make([]int, 0, len(rm.codes))
+		for code := range rm.codes {
+			codes = append(codes, code)
+		}
+		sort.Ints(codes)
+		for _, code := range codes {
+			fmt.Fprintf(w, "store_http_requests_total{method=%q,route=%q,code=\"%d\"} %d\n", key.method, key.route, code, rm.codes[code])
+		}
+	}
+
+	fmt.Fprintln(w, "# HELP store_http_request_duration_seconds Request latency, by method and route.")
+	fmt.Fprintln(w, "# TYPE store_http_request_duration_seconds histogram")
+	for _, key := range keys {
+		writeHistogram(w, key, s.metrics.routes[key])
+	}
+
+	fmt.Fprintln(w, "# HELP store_http_requests_in_flight Requests currently being handled.")
+	fmt.Fprintln(w, "# TYPE store_http_requests_in_flight gauge")
+	fmt.Fprintf(w, "store_http_requests_in_flight %d\n", atomic.LoadInt64(&s.metrics.inFlight))
+
+	fmt.Fprintln(w, "# HELP store_entities Entities in the store, by collection.")
+	fmt.Fprintln(w, "# TYPE store_entities gauge")
+	for _, collection := range []string{"customers", "genres", "movies", "rentals"} {
+		fmt.Fprintf(w, "store_entities{collection=%q} %d\n", collection, entities[collection])
+	}
+}
+
+// writeHistogram writes the latency histogram of one route, with cumulative buckets
+func writeHistogram(w io.Writer, key routeKey, rm *routeMetrics) {
+	for i, le := range latencyBuckets {
+		fmt.Fprintf(w, "store_http_request_duration_seconds_bucket{method=%q,route=%q,le=%q} %d\n",
+			key.method, key.route, strconv.FormatFloat(le, 'g', -1, 64), rm.buckets[i])
+	}
+	fmt.Fprintf(w, "store_http_request_duration_seconds_bucket{method=%q,route=%q,le=\"+Inf\"} %d\n", key.method, key.route, rm.count)
+	fmt.Fprintf(w, "store_http_request_duration_seconds_sum{method=%q,route=%q} %s\n", key.method, key.route, strconv.FormatFloat(rm.sum, 'g', -1, 64))
+	fmt.Fprintf(w, "store_http_request_duration_seconds_count{method=%q,route=%q} %d\n", key.method, key.route, rm.count)
+}
@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// middleware wraps a handler with behaviour common to every request
+type middleware func(http.Handler) http.Handler
+
+// chain wraps h in the middlewares, the first of them outermost
+func chain(h http.Handler, middlewares ...middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// requestInfo is what the middleware learns about a request as it is handled. The request ID is known from the start,
+// and the token subject once auth() has checked the token
+type requestInfo struct {
+	id      string
+	subject string
+}
+
+type requestInfoKey struct{}
+
+// RequestIDFromContext returns the ID of the request the context belongs to, or an empty string when there is none
+func RequestIDFromContext(ctx context.Context) string {
+	if info, ok := ctx.Value(requestInfoKey{}).(*requestInfo); ok {
+		return info.id
+	}
+	return ""
+}
+
+// infoFromRequest returns the requestInfo of r, which is empty when r did not go through requestID
+func infoFromRequest(r *http.Request) *requestInfo {
+	if info, ok := r.Context().Value(requestInfoKey{}).(*requestInfo); ok {
+		return info
+	}
+	return &requestInfo{}
+}
+
+// maxRequestIDLength is the longest X-Request-ID header that is propagated, a longer one is replaced
+const maxRequestIDLength = 128
+
+// requestID gives every request an ID, the one in its X-Request-ID header when it has a usable one, and returns it in
+// the X-Request-ID header of the response
+func requestID(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validRequestID(id) {
+			id = newObjectID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, &requestInfo{id: id})))
+	})
+}
+
+// validRequestID reports whether id can be used as a request ID: it is not empty or too long, and it is printable
+// ASCII so that it can be logged safely
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// AccessLogEntry is the JSON line written to the access log for every request
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"requestId"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	DurationMS float64   `json:"durationMs"`
+	Subject    string    `json:"subject,omitempty"`
+	RemoteAddr string    `json:"remoteAddr"`
+}
+
+// WithAccessLog makes the Service write the access log to w rather than to the output of the standard logger
+func WithAccessLog(w io.Writer) Option {
+	return func(s *Service) {
+		s.accessLog = w
+	}
+}
+
+// logAccess writes an AccessLogEntry for every request handled by h once it has been answered
+func (s *Service) logAccess(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		start := time.Now()
+		h.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+
+		info := infoFromRequest(r)
+		line, err := json.Marshal(AccessLogEntry{
+			Time:       start.UTC(),
+			RequestID:  info.id,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     sw.status,
+			Bytes:      sw.bytes,
+			DurationMS: float64(time.Since(start).Microseconds()) / 1000,
+			Subject:    info.subject,
+			RemoteAddr: r.RemoteAddr,
+		})
+		if err != nil {
+			log.Printf("error encoding access log entry - %s", err)
+			return
+		}
+		out := s.accessLog
+		if out == nil {
+			out = log.Writer()
+		}
+		out.Write(append(line, '\n'))
+	})
+}
+
+// recoverPanics answers a request whose handler panics with 500 Internal Server Error, naming the request ID so that
+// the panic can be found in the log, rather than dropping the connection
+func recoverPanics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				id := infoFromRequest(r).id
+				log.Printf("panic handling %s %s, request ID %s - %v\n%s", r.Method, r.URL.Path, id, v, debug.Stack())
+				if sw.status == 0 {
+					http.Error(sw, fmt.Sprintf("Internal server error, request ID %s.", id), http.StatusInternalServerError)
+				}
+			}
+		}()
+		h.ServeHTTP(sw, r)
+	})
+}
+
+// corsHeaders are the request headers the store API accepts from browsers, and corsExposedHeaders the response
+// headers they can read
+const (
+	corsHeaders        = "Authorization, Content-Type, Idempotency-Key, If-Match, If-None-Match, X-Auth-Token, X-Request-ID"
+	corsExposedHeaders = "ETag, Retry-After, X-Request-ID"
+)
+
+// WithCORS allows browser pages served from origins to call the Service. An origin of "*" allows every origin. No
+// cross-origin request is allowed when no origin is given
+func WithCORS(origins ...string) Option {
+	return func(s *Service) {
+		s.corsOrigins = origins
+	}
+}
+
+// cors adds the CORS headers to the responses to requests from allowed origins, and answers their preflight requests
+// itself, before they reach auth()
+func (s *Service) cors(h http.Handler) http.Handler {
+	if len(s.corsOrigins) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !s.corsAllowed(origin) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		if r.Method == "OPTIONS" && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", corsHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// corsAllowed reports whether origin is one of the allowed origins of the Service
+func (s *Service) corsAllowed(origin string) bool {
+	for _, allowed := range s.corsOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// MovieRatings are the MPAA-style ratings a movie can have
+var MovieRatings = []string{"G", "PG", "PG-13", "R", "NC-17"}
+
+// movieRequest is the body accepted when creating or updating a Movie. The genres can be referenced by genreIds, or
+// by embedded genre objects as the provider client sends them. A single genre can still be referenced by genreId, as
+// the real store expects, or by an embedded genre object
+type movieRequest struct {
+	Title           string   `json:"title"`
+	GenreID         string   `json:"genreId"`
+	Genre           *Genre   `json:"genre"`
+	GenreIDs        []string `json:"genreIds"`
+	Genres          []Genre  `json:"genres"`
+	NumberInStock   int      `json:"numberInStock"`
+	DailyRentalRate float64  `json:"dailyRentalRate"`
+	ReleaseYear     int      `json:"releaseYear"`
+	Rating          string   `json:"rating"`
+	RuntimeMinutes  int      `json:"runtimeMinutes"`
+	Description     string   `json:"description"`
+}
+
+// genreIDs returns the IDs of the genres the request references, without duplicates and in the order they were given.
+// The first is the primary genre of the movie
+func (m *movieRequest) genreIDs() []string {
+	ids := m.GenreIDs
+	if len(ids) == 0 {
+		for _, genre := range m.Genres {
+			ids = append(ids, genre.ID)
+		}
+	}
+	if len(ids) == 0 {
+		switch {
+		case m.GenreID != "":
+			ids = []string{m.GenreID}
+		case m.Genre != nil:
+			ids = []string{m.Genre.ID}
+		}
+	}
+
+	unique := make([]string, 0, len(ids))
+	seen := map[string]bool{}
+	for _, id := range ids {
+		if id != "" && !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// movie returns the Movie described by the request, with the given ID, genres and version
+func (m *movieRequest) movie(id string, genres []Genre, version int) Movie {
+	return Movie{
+		ID:              id,
+		Title:           m.Title,
+		Genre:           genres[0],
+		Genres:          genres,
+		NumberInStock:   m.NumberInStock,
+		DailyRentalRate: m.DailyRentalRate,
+		ReleaseYear:     m.ReleaseYear,
+		Rating:          m.Rating,
+		RuntimeMinutes:  m.RuntimeMinutes,
+		Description:     m.Description,
+		Version:         version,
+	}
+}
+
+// movieGenres returns the stored genres with the given IDs, or false when any of them is missing or soft deleted. Does
+// not lock access to the store, expects this to be done by the calling method
+func (s *Service) movieGenres(ids []string) ([]Genre, bool) {
+	genres := make([]Genre, 0, len(ids))
+	for _, id := range ids {
+		genre, ok := s.genres[id]
+		if !ok || genre.DeletedAt != nil {
+			return nil, false
+		}
+		genres = append(genres, genre)
+	}
+	return genres, true
+}
+
+// GetMovies returns all of the Movies that exist in the store, ordered by title
+func (s *Service) GetMovies(w http.ResponseWriter, r *http.Request) {
+	unlock := s.lock(reading("movies"))
+	movies := make([]Movie, 0, len(s.movies))
+	for _, movie := range s.movies {
+		if movie.DeletedAt != nil && !includeDeleted(r) {
+			continue
+		}
+		movies = append(movies, movie)
+	}
+	unlock()
+
+	sort.Slice(movies, func(i, j int) bool { return movies[i].Title < movies[j].Title })
+	writeJSON(w, movies)
+}
+
+// GetMovie handles retrieving a Movie with a specific ID
+func (s *Service) GetMovie(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(reading("movies"))
+	movie, ok := s.movies[id]
+	unlock()
+
+	if !ok || (movie.DeletedAt != nil && !includeDeleted(r)) {
+		http.Error(w, "The movie with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, movie)
+}
+
+// PostMovie handles adding a new Movie. A movie that would break a unique index is rejected with 409
+func (s *Service) PostMovie(w http.ResponseWriter, r *http.Request) {
+	var req movieRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if !checkValid(w, validateMovie(&req)) {
+		return
+	}
+
+	unlock := s.lock(reading("genres"), writing("movies"))
+	defer unlock()
+
+	genres, ok := s.movieGenres(req.genreIDs())
+	if !ok {
+		http.Error(w, "Invalid genre.", http.StatusBadRequest)
+		return
+	}
+
+	movie := req.movie(newObjectID(), genres, 0)
+	if !s.checkUnique(w, "movies", "", movie) {
+		return
+	}
+	tracked := s.track(r, "movies", movie.ID)
+	s.movies[movie.ID] = movie
+	tracked()
+	s.saved("movies")
+	log.Printf("added movie: %s", movie.ID)
+	writeJSON(w, movie)
+}
+
+// PutMovie handles updating a Movie with a specific ID. A request with an If-Match header is rejected when the movie
+// has changed since that version
+func (s *Service) PutMovie(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var req movieRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	if !checkValid(w, validateMovie(&req)) {
+		return
+	}
+
+	unlock := s.lock(reading("genres"), writing("movies"))
+	defer unlock()
+
+	genres, ok := s.movieGenres(req.genreIDs())
+	if !ok {
+		http.Error(w, "Invalid genre.", http.StatusBadRequest)
+		return
+	}
+	current, ok := s.movies[id]
+	if !ok || current.DeletedAt != nil {
+		http.Error(w, "The movie with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	if !checkIfMatch(w, r, "movie", current.Version) {
+		return
+	}
+
+	movie := req.movie(id, genres, current.Version+1)
+	if !s.checkUnique(w, "movies", id, movie) {
+		return
+	}
+	tracked := s.track(r, "movies", id)
+	s.movies[id] = movie
+	tracked()
+	s.saved("movies")
+	if movie.NumberInStock != current.NumberInStock {
+		s.notify("movie.stock_changed", movie)
+	}
+	log.Printf("updated movie: %s", id)
+	writeJSON(w, movie)
+}
+
+// DeleteMovie handles removing a Movie with a specific ID, responding with the removed Movie. With ?soft=true the movie is
+// only marked as deleted, so that it can be restored until it is purged. A soft-deleted movie can still be removed for
+// good
+func (s *Service) DeleteMovie(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(writing("movies"))
+	defer unlock()
+
+	movie, ok := s.movies[id]
+	if !ok || (movie.DeletedAt != nil && softDelete(r)) {
+		http.Error(w, "The movie with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+
+	if softDelete(r) {
+		now := time.Now().UTC()
+		movie.DeletedAt = &now
+		movie.Version++
+		tracked := s.track(r, "movies", id)
+		s.movies[id] = movie
+		tracked()
+		s.saved("movies")
+		log.Printf("soft deleted movie: %s", id)
+		writeJSON(w, movie)
+		return
+	}
+	tracked := s.track(r, "movies", id)
+	delete(s.movies, id)
+	tracked()
+	s.saved("movies")
+	log.Printf("deleted movie: %s", id)
+	writeJSON(w, movie)
+}
+
+// validateMovie returns the reasons the movie in req cannot be stored, none when it is valid. A zero releaseYear or
+// runtimeMinutes and an empty rating mean the value is not known
+func validateMovie(req *movieRequest) fieldErrors {
+	var errs fieldErrors
+	errs.add("title", validateLength("title", req.Title, 5, 255))
+	if len(req.genreIDs()) == 0 {
+		errs.add("genreIds", "\"genreIds\" is required")
+	}
+	if req.NumberInStock < 0 || req.NumberInStock > 255 {
+		errs.add("numberInStock", "\"numberInStock\" must be between 0 and 255")
+	}
+	if req.DailyRentalRate < 0 || req.DailyRentalRate > 255 {
+		errs.add("dailyRentalRate", "\"dailyRentalRate\" must be between 0 and 255")
+	}
+	if maxYear := time.Now().Year() + 5; req.ReleaseYear != 0 && (req.ReleaseYear < 1888 || req.ReleaseYear > maxYear) {
+		errs.add("releaseYear", fmt.Sprintf("\"releaseYear\" must be between 1888 and %d", maxYear))
+	}
+	if req.Rating != "" {
+		known := false
+		for _, rating := range MovieRatings {
+			known = known || rating == req.Rating
+		}
+		if !known {
+			errs.add("rating", fmt.Sprintf("\"rating\" must be one of %s", strings.Join(MovieRatings, ", ")))
+		}
+	}
+	if req.RuntimeMinutes < 0 || req.RuntimeMinutes > 1000 {
+		errs.add("runtimeMinutes", "\"runtimeMinutes\" must be between 1 and 1000")
+	}
+	errs.add("description", validateLength("description", req.Description, 0, 2000))
+	return errs
+}
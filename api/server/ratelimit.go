@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// rateLimit is a token bucket shared by every request to the Service
+type rateLimit struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+// WithRateLimit makes the Service answer 429 Too Many Requests, with a Retry-After header, once requests arrive
+// faster than requestsPerSecond on average with bursts of up to burst requests. A requestsPerSecond of zero or less
+// means no limit
+func WithRateLimit(requestsPerSecond float64, burst int) Option {
+	return func(s *Service) {
+		if requestsPerSecond <= 0 {
+			s.rateLimit = nil
+			return
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		s.rateLimit = &rateLimit{
+			rate:   requestsPerSecond,
+			burst:  float64(burst),
+			tokens: float64(burst),
+		}
+	}
+}
+
+// take removes a token from the bucket. When the bucket is empty it returns false and how long until the next token
+// is available
+func (l *rateLimit) take() (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	now := time.Now()
+	if !l.last.IsZero() {
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	}
+	l.last = now
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+// limit rejects requests over the rate limit of the Service with 429 Too Many Requests
+func (s *Service) limit(h http.Handler) http.Handler {
+	if s.rateLimit == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, wait := s.rateLimit.take()
+		if !ok {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too many requests.", http.StatusTooManyRequests)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
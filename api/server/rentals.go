@@ -0,0 +1,207 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// rentalRequest is the body accepted when opening a Rental
+type rentalRequest struct {
+	CustomerID string `json:"customerId"`
+	MovieID    string `json:"movieId"`
+}
+
+// rentalBatchRequest is the body accepted when opening several Rentals for one customer at once
+type rentalBatchRequest struct {
+	CustomerID string   `json:"customerId"`
+	MovieIDs   []string `json:"movieIds"`
+}
+
+// MaxRentalBatch is the most rentals a single batch can open
+const MaxRentalBatch = 100
+
+// GetRentals returns all of the Rentals that exist in the store, most recent first
+func (s *Service) GetRentals(w http.ResponseWriter, r *http.Request) {
+	unlock := s.lock(reading("rentals"))
+	rentals := make([]Rental, 0, len(s.rentals))
+	for _, rental := range s.rentals {
+		rentals = append(rentals, rental)
+	}
+	unlock()
+
+	sort.Slice(rentals, func(i, j int) bool { return rentals[i].DateOut.After(rentals[j].DateOut) })
+	writeJSON(w, rentals)
+}
+
+// GetRental handles retrieving a Rental with a specific ID
+func (s *Service) GetRental(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(reading("rentals"))
+	rental, ok := s.rentals[id]
+	unlock()
+
+	if !ok {
+		http.Error(w, "The rental with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, rental)
+}
+
+// PostRental handles opening a new Rental, taking one copy of the movie out of stock
+func (s *Service) PostRental(w http.ResponseWriter, r *http.Request) {
+	var req rentalRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	var errs fieldErrors
+	if req.CustomerID == "" {
+		errs.add("customerId", "\"customerId\" is required")
+	}
+	if req.MovieID == "" {
+		errs.add("movieId", "\"movieId\" is required")
+	}
+	if !checkValid(w, errs) {
+		return
+	}
+
+	unlock := s.lock(reading("customers"), writing("movies"), writing("rentals"))
+	defer unlock()
+
+	customer, ok := s.customers[req.CustomerID]
+	if !ok || customer.DeletedAt != nil {
+		http.Error(w, "Invalid customer.", http.StatusBadRequest)
+		return
+	}
+	movie, ok := s.movies[req.MovieID]
+	if !ok || movie.DeletedAt != nil {
+		http.Error(w, "Invalid movie.", http.StatusBadRequest)
+		return
+	}
+	if movie.NumberInStock == 0 {
+		http.Error(w, "Movie not in stock.", http.StatusBadRequest)
+		return
+	}
+
+	rental := s.openRental(r, customer, movie, time.Now().UTC())
+	s.saved("movies", "rentals")
+	log.Printf("added rental: %s", rental.ID)
+	writeJSON(w, rental)
+}
+
+// PostRentals handles opening a Rental of each of a list of movies for one customer, responding with the Rentals in the
+// order of the movies. Either every rental is opened or, when the customer or any movie is invalid or there are not
+// enough copies of a movie in stock, none is
+func (s *Service) PostRentals(w http.ResponseWriter, r *http.Request) {
+	var req rentalBatchRequest
+	if !decodeBody(w, r, &req) {
+		return
+	}
+	var errs fieldErrors
+	if req.CustomerID == "" {
+		errs.add("customerId", "\"customerId\" is required")
+	}
+	if len(req.MovieIDs) == 0 || len(req.MovieIDs) > MaxRentalBatch {
+		errs.add("movieIds", fmt.Sprintf("\"movieIds\" must contain between 1 and %d movies", MaxRentalBatch))
+	}
+	if !checkValid(w, errs) {
+		return
+	}
+
+	unlock := s.lock(reading("customers"), writing("movies"), writing("rentals"))
+	defer unlock()
+
+	customer, ok := s.customers[req.CustomerID]
+	if !ok || customer.DeletedAt != nil {
+		http.Error(w, "Invalid customer.", http.StatusBadRequest)
+		return
+	}
+	wanted := map[string]int{}
+	for _, movieID := range req.MovieIDs {
+		movie, ok := s.movies[movieID]
+		if !ok || movie.DeletedAt != nil {
+			http.Error(w, fmt.Sprintf("Invalid movie %s.", movieID), http.StatusBadRequest)
+			return
+		}
+		wanted[movieID]++
+		if movie.NumberInStock < wanted[movieID] {
+			http.Error(w, fmt.Sprintf("Movie %s not in stock.", movieID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	rentals := make([]Rental, 0, len(req.MovieIDs))
+	for _, movieID := range req.MovieIDs {
+		rentals = append(rentals, s.openRental(r, customer, s.movies[movieID], now))
+	}
+	s.saved("movies", "rentals")
+	log.Printf("added %d rentals for customer: %s", len(rentals), customer.ID)
+	writeJSON(w, rentals)
+}
+
+// openRental adds a rental of movie to customer for r, taking one copy of the movie out of stock, and returns it. The
+// caller checks that the movie is in stock and saves the store. Does not lock access to the store, expects this to be
+// done by the calling method
+func (s *Service) openRental(r *http.Request, customer Customer, movie Movie, dateOut time.Time) Rental {
+	rental := Rental{
+		ID: newObjectID(),
+		Customer: RentalCustomer{
+			ID:     customer.ID,
+			Name:   customer.Name,
+			IsGold: customer.IsGold,
+			Phone:  customer.Phone,
+		},
+		Movie: RentalMovie{
+			ID:              movie.ID,
+			Title:           movie.Title,
+			DailyRentalRate: movie.DailyRentalRate,
+		},
+		DateOut: dateOut,
+	}
+	movie.NumberInStock--
+	movie.Version++
+	trackedMovie := s.track(r, "movies", movie.ID)
+	s.movies[movie.ID] = movie
+	trackedMovie()
+	tracked := s.track(r, "rentals", rental.ID)
+	s.rentals[rental.ID] = rental
+	tracked()
+	s.notify("movie.stock_changed", movie)
+	return rental
+}
+
+// DeleteRental handles removing a Rental with a specific ID, responding with the removed Rental. A rental that was
+// never returned puts its copy of the movie back in stock
+func (s *Service) DeleteRental(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(writing("movies"), writing("rentals"))
+	defer unlock()
+
+	rental, ok := s.rentals[id]
+	if !ok {
+		http.Error(w, "The rental with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+
+	if movie, ok := s.movies[rental.Movie.ID]; ok && rental.DateReturned == nil {
+		movie.NumberInStock++
+		movie.Version++
+		tracked := s.track(r, "movies", movie.ID)
+		s.movies[movie.ID] = movie
+		tracked()
+		s.notify("movie.stock_changed", movie)
+	}
+	tracked := s.track(r, "rentals", id)
+	delete(s.rentals, id)
+	tracked()
+	s.saved("movies", "rentals")
+	log.Printf("deleted rental: %s", id)
+	writeJSON(w, rental)
+}
@@ -1,68 +1,292 @@
 package server
 
 import (
+	"context"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-// Service holds the map of items and provides methods CRUD operations on the map
+// Service holds the map of items and the store collections and provides methods CRUD operations on them
 type Service struct {
 	connectionString string
+	authSecret       string
 	items            map[string]Item
-	sync.RWMutex
+	genres           map[string]Genre
+	customers        map[string]Customer
+	movies           map[string]Movie
+	rentals          map[string]Rental
+	webhooks         map[string]Webhook
+	uniqueIndexes    map[string][]string
+	idempotency      idempotencyKeys
+	audit            auditLog
+	deliveries       webhookDeliveries
+	rateLimit        *rateLimit
+	purgeAfter       time.Duration
+	metrics          metrics
+	accessLog        io.Writer
+	corsOrigins      []string
+	faults           faultInjector
+	snapshots        snapshots
+	storage          storage
+	locks            collectionLocks
+	lifecycle        lifecycle
+}
+
+// lifecycle holds the HTTP server of a Service and whether it is shutting down
+type lifecycle struct {
+	httpServer   *http.Server
+	shuttingDown bool
+	sync.Mutex
+}
+
+// Option configures optional behaviour of a Service
+type Option func(*Service)
+
+// WithAuthSecret makes the Service verify the HS256 signature of every auth token against secret
+func WithAuthSecret(secret string) Option {
+	return func(s *Service) {
+		s.authSecret = secret
+	}
+}
+
+// WithStoragePath makes the Service write the store collections to the file at path after every change, see
+// LoadStorage for reading them back
+func WithStoragePath(path string) Option {
+	return func(s *Service) {
+		s.storage.path = path
+	}
 }
 
 // NewService returns a Service with a connectionString configured and can be a map of items setup. The items map can be empty,
 // or can contain items
-func NewService(connectionString string, items map[string]Item) *Service {
-	return &Service{
+func NewService(connectionString string, items map[string]Item, opts ...Option) *Service {
+	if items == nil {
+		items = map[string]Item{}
+	}
+	s := &Service{
 		connectionString: connectionString,
 		items:            items,
+		genres:           map[string]Genre{},
+		customers:        map[string]Customer{},
+		movies:           map[string]Movie{},
+		rentals:          map[string]Rental{},
+		webhooks:         map[string]Webhook{},
+		snapshots:        snapshots{byName: map[string]*storedSnapshot{}},
+		locks:            newCollectionLocks(),
+		uniqueIndexes:    DefaultUniqueIndexes,
+		idempotency: idempotencyKeys{
+			window:    DefaultIdempotencyWindow,
+			responses: map[string]*idempotentResponse{},
+		},
+		deliveries: webhookDeliveries{
+			attempts:   DefaultWebhookAttempts,
+			backoff:    DefaultWebhookBackoff,
+			httpClient: &http.Client{Timeout: 10 * time.Second},
+			stop:       make(chan struct{}),
+		},
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	return s
 }
 
-// ListenAndServe registers the routes to the server and starts the server on the host:port configured in Service
-func (s *Service) ListenAndServe() error {
+// Handler returns the routes of the server
+func (s *Service) Handler() http.Handler {
 	r := mux.NewRouter()
 
-	// Each handler is wrapped in logs() and auth() to log out the method and path and to
-	// ensure that a non-empty Authorization header is present
-	r.HandleFunc("/item", logs(auth(s.PostItem))).Methods("POST")
-	r.HandleFunc("/item", logs(auth(s.GetItems))).Methods("GET")
-	r.HandleFunc("/item/{name}", logs(auth(s.GetItem))).Methods("GET")
-	r.HandleFunc("/item/{name}", logs(auth(s.PutItem))).Methods("PUT")
-	r.HandleFunc("/item/{name}", logs(auth(s.DeleteItem))).Methods("DELETE")
+	// Each handler is wrapped in auth() to ensure that a valid auth token is present, or in admin()
+	// when it also needs an admin token. Creates are
+	// also wrapped in idempotent() so that a retried POST replays the original response instead of
+	// creating a duplicate, and every change to the store is wrapped in audited() to record who
+	// made it. The bulk routes hand each item to the audited single-entity handler, so that every
+	// item is recorded. Reads are wrapped in conditional() to answer 304 when the client already
+	// has the response
+	r.HandleFunc("/item", s.auth(s.PostItem)).Methods("POST")
+	r.HandleFunc("/item", s.auth(conditional(s.GetItems))).Methods("GET")
+	r.HandleFunc("/item/{name}", s.auth(conditional(s.GetItem))).Methods("GET")
+	r.HandleFunc("/item/{name}", s.auth(s.PutItem)).Methods("PUT")
+	r.HandleFunc("/item/{name}", s.auth(s.DeleteItem)).Methods("DELETE")
 
-	log.Printf("Starting server on %s", s.connectionString)
-	err := http.ListenAndServe(s.connectionString, r)
+	r.HandleFunc("/api/genres", s.auth(s.idempotent(s.audited("genres", s.PostGenre)))).Methods("POST")
+	r.HandleFunc("/api/genres/bulk", s.auth(s.idempotent(s.bulk(s.audited("genres", s.PostGenre), false)))).Methods("POST")
+	r.HandleFunc("/api/genres/bulk", s.auth(s.bulk(s.audited("genres", s.PutGenre), true))).Methods("PUT")
+	r.HandleFunc("/api/genres/bulk", s.auth(s.bulk(s.audited("genres", s.DeleteGenre), true))).Methods("DELETE")
+	r.HandleFunc("/api/genres", s.auth(conditional(s.GetGenres))).Methods("GET")
+	r.HandleFunc("/api/genres/{id}", s.auth(conditional(s.GetGenre))).Methods("GET")
+	r.HandleFunc("/api/genres/{id}", s.auth(s.audited("genres", s.PutGenre))).Methods("PUT")
+	r.HandleFunc("/api/genres/{id}", s.auth(s.audited("genres", s.DeleteGenre))).Methods("DELETE")
+	r.HandleFunc("/api/genres/{id}/restore", s.auth(s.audited("genres", s.RestoreGenre))).Methods("POST")
+
+	r.HandleFunc("/api/customers", s.auth(s.idempotent(s.audited("customers", s.PostCustomer)))).Methods("POST")
+	r.HandleFunc("/api/customers/bulk", s.auth(s.idempotent(s.bulk(s.audited("customers", s.PostCustomer), false)))).Methods("POST")
+	r.HandleFunc("/api/customers/bulk", s.auth(s.bulk(s.audited("customers", s.PutCustomer), true))).Methods("PUT")
+	r.HandleFunc("/api/customers/bulk", s.auth(s.bulk(s.audited("customers", s.DeleteCustomer), true))).Methods("DELETE")
+	r.HandleFunc("/api/customers", s.auth(conditional(s.GetCustomers))).Methods("GET")
+	r.HandleFunc("/api/customers/{id}", s.auth(conditional(s.GetCustomer))).Methods("GET")
+	r.HandleFunc("/api/customers/{id}", s.auth(s.audited("customers", s.PutCustomer))).Methods("PUT")
+	r.HandleFunc("/api/customers/{id}", s.auth(s.audited("customers", s.DeleteCustomer))).Methods("DELETE")
+	r.HandleFunc("/api/customers/{id}/restore", s.auth(s.audited("customers", s.RestoreCustomer))).Methods("POST")
+
+	r.HandleFunc("/api/movies", s.auth(s.idempotent(s.audited("movies", s.PostMovie)))).Methods("POST")
+	r.HandleFunc("/api/movies/bulk", s.auth(s.idempotent(s.bulk(s.audited("movies", s.PostMovie), false)))).Methods("POST")
+	r.HandleFunc("/api/movies/bulk", s.auth(s.bulk(s.audited("movies", s.PutMovie), true))).Methods("PUT")
+	r.HandleFunc("/api/movies/bulk", s.auth(s.bulk(s.audited("movies", s.DeleteMovie), true))).Methods("DELETE")
+	r.HandleFunc("/api/movies", s.auth(conditional(s.GetMovies))).Methods("GET")
+	r.HandleFunc("/api/movies/{id}", s.auth(conditional(s.GetMovie))).Methods("GET")
+	r.HandleFunc("/api/movies/{id}", s.auth(s.audited("movies", s.PutMovie))).Methods("PUT")
+	r.HandleFunc("/api/movies/{id}", s.auth(s.audited("movies", s.DeleteMovie))).Methods("DELETE")
+	r.HandleFunc("/api/movies/{id}/restore", s.auth(s.audited("movies", s.RestoreMovie))).Methods("POST")
+
+	r.HandleFunc("/api/rentals", s.auth(s.idempotent(s.audited("rentals", s.PostRental)))).Methods("POST")
+	r.HandleFunc("/api/rentals/batch", s.auth(s.idempotent(s.audited("rentals", s.PostRentals)))).Methods("POST")
+	r.HandleFunc("/api/rentals", s.auth(conditional(s.GetRentals))).Methods("GET")
+	r.HandleFunc("/api/rentals/{id}", s.auth(conditional(s.GetRental))).Methods("GET")
+	r.HandleFunc("/api/rentals/{id}", s.auth(s.audited("rentals", s.DeleteRental))).Methods("DELETE")
+
+	r.HandleFunc("/api/webhooks", s.auth(s.idempotent(s.audited("webhooks", s.PostWebhook)))).Methods("POST")
+	r.HandleFunc("/api/webhooks", s.auth(conditional(s.GetWebhooks))).Methods("GET")
+	r.HandleFunc("/api/webhooks/{id}", s.auth(conditional(s.GetWebhook))).Methods("GET")
+	r.HandleFunc("/api/webhooks/{id}", s.auth(s.audited("webhooks", s.PutWebhook))).Methods("PUT")
+	r.HandleFunc("/api/webhooks/{id}", s.auth(s.audited("webhooks", s.DeleteWebhook))).Methods("DELETE")
+	r.HandleFunc("/api/webhooks/{id}/dead-letters", s.auth(conditional(s.GetDeadLetters))).Methods("GET")
+
+	r.HandleFunc("/api/audit", s.auth(conditional(s.GetAuditEvents))).Methods("GET")
+
+	r.HandleFunc("/admin/faults", s.admin(s.GetFaults)).Methods("GET")
+	r.HandleFunc("/admin/faults", s.admin(s.PutFaults)).Methods("PUT")
+	r.HandleFunc("/admin/faults", s.admin(s.DeleteFaults)).Methods("DELETE")
+	r.HandleFunc("/admin/snapshots", s.admin(s.PostSnapshot)).Methods("POST")
+	r.HandleFunc("/admin/snapshots", s.admin(s.GetSnapshots)).Methods("GET")
+	r.HandleFunc("/admin/snapshots/{name}/restore", s.admin(s.RestoreSnapshot)).Methods("POST")
+	r.HandleFunc("/admin/snapshots/{name}", s.admin(s.DeleteSnapshot)).Methods("DELETE")
+	r.HandleFunc("/admin/reset", s.admin(s.PostReset)).Methods("POST")
+
+	// The probes and metrics are served without auth or rate limiting, so that they keep answering while the
+	// store API is throttled
+	probes := mux.NewRouter()
+	probes.HandleFunc("/healthz", s.Healthz).Methods("GET")
+	probes.HandleFunc("/readyz", s.Readyz).Methods("GET")
+	probes.HandleFunc("/metrics", s.Metrics).Methods("GET")
+	probes.NotFoundHandler = s.limit(s.injectFaults(r))
+
+	// Every request, probes included, gets a request ID first so that the access log, the metrics
+	// and a recovered panic can all name it
+	instrumented := func(h http.Handler) http.Handler { return s.instrument(h, r, probes) }
+	return chain(probes, requestID, s.logAccess, instrumented, recoverPanics, s.cors)
+}
+
+// ListenAndServe starts the server on the host:port configured in Service
+func (s *Service) ListenAndServe() error {
+	l, err := net.Listen("tcp", s.connectionString)
 	if err != nil {
 		return err
 	}
+	return s.Serve(l)
+}
+
+// Serve serves the routes of the server on l until Shutdown is called. It returns nil once the server has been
+// shut down gracefully
+func (s *Service) Serve(l net.Listener) error {
+	srv := &http.Server{Handler: s.Handler()}
+	s.lifecycle.Lock()
+	s.lifecycle.httpServer = srv
+	s.lifecycle.Unlock()
+
+	if s.purgeAfter > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go s.purgeExpired(purgeInterval(s.purgeAfter), stop)
+	}
+
+	log.Printf("Starting server on %s", l.Addr())
+	err := srv.Serve(l)
+	if err != nil && err != http.ErrServerClosed {
+		return err
+	}
 	return nil
 }
 
-// logs prints the Method and Path to stdout
-func logs(handlerFunc http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		method := r.Method
-		path := r.URL.Path
-		log.Printf("%s %s", method, path)
-		handlerFunc(w, r)
-		return
+// Shutdown stops accepting new connections, waits for in-flight requests and webhook deliveries to finish or ctx to
+// expire, and then writes the store to the storage file. The store is written even when ctx expires first, in which
+// case the deliveries still waiting to be retried are dead-lettered and the error of ctx is returned
+func (s *Service) Shutdown(ctx context.Context) error {
+	s.lifecycle.Lock()
+	srv := s.lifecycle.httpServer
+	s.lifecycle.shuttingDown = true
+	s.lifecycle.Unlock()
+
+	var err error
+	if srv != nil {
+		err = srv.Shutdown(ctx)
+	}
+
+	if err == nil {
+		delivered := make(chan struct{})
+		go func() {
+			s.deliveries.inProgress.Wait()
+			close(delivered)
+		}()
+		select {
+		case <-delivered:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	s.deliveries.stopping.Do(func() { close(s.deliveries.stop) })
+
+	if persistErr := s.persist(); err == nil {
+		err = persistErr
 	}
+	return err
 }
 
-// auth checks that a non-empty authorization header has been sent with the request
-func auth(handlerFunc http.HandlerFunc) http.HandlerFunc {
+// auth checks that an auth token has been sent with the request, either in the x-auth-token header the store
+// clients use or in the Authorization header. When the Service has an auth secret the token must be a JWT signed
+// with it. The claims of the token are made available through ClaimsFromContext
+func (s *Service) auth(handlerFunc http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		if r.Header.Get("Authorization") == "" {
+		token := r.Header.Get("x-auth-token")
+		if token == "" {
+			token = strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+		if token == "" {
 			http.Error(w, "Please supply and Authorization token", http.StatusUnauthorized)
 			return
 		}
+
+		claims, err := parseToken(token, s.authSecret)
+		if err != nil && s.authSecret != "" {
+			http.Error(w, "Invalid token.", http.StatusUnauthorized)
+			return
+		}
+		if claims != nil {
+			infoFromRequest(r).subject = claims.Subject
+			r = r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims))
+		}
 		handlerFunc(w, r)
 		return
 	}
 }
+
+// admin checks the auth token of a request to the admin endpoints, which can replace the whole store or make it fail
+// for every client. When the Service has an auth secret the token must also carry the isAdmin claim
+func (s *Service) admin(handlerFunc http.HandlerFunc) http.HandlerFunc {
+	return s.auth(func(w http.ResponseWriter, r *http.Request) {
+		if s.authSecret != "" {
+			claims := ClaimsFromContext(r.Context())
+			if claims == nil || !claims.IsAdmin {
+				http.Error(w, "Access denied.", http.StatusForbidden)
+				return
+			}
+		}
+		handlerFunc(w, r)
+	})
+}
@@ -0,0 +1,1230 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// startService serves s on a free local port and returns its base URL. The service is shut down when the test
+// finishes
+func startService(t *testing.T, s *Service) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening: %s", err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- s.Serve(l)
+	}()
+	t.Cleanup(func() {
+		// The transport can dial a connection it ends up not using, which Shutdown would wait for until it times out
+		http.DefaultClient.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.Shutdown(ctx); err != nil {
+			t.Errorf("error shutting down: %s", err)
+		}
+		if err := <-served; err != nil {
+			t.Errorf("Serve returned an error after shutdown: %s", err)
+		}
+	})
+	return fmt.Sprintf("http://%s", l.Addr())
+}
+
+func doRequest(t *testing.T, method, url, token string, body interface{}, out interface{}) int {
+	buf := bytes.Buffer{}
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, url, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("x-auth-token", token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %s", method, url, err)
+	}
+	defer resp.Body.Close()
+	if out != nil && (resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusUnprocessableEntity) {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("error decoding response of %s %s: %s", method, url, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+func TestService_StoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	storage := filepath.Join(dir, "store.json")
+
+	url := startService(t, NewService("", nil, WithStoragePath(storage)))
+
+	genre := Genre{}
+	if code := doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "comedy"}, &genre); code != http.StatusOK {
+		t.Fatalf("expected 200 creating genre, got %d", code)
+	}
+	movie := Movie{}
+	code := doRequest(t, "POST", url+"/api/movies", "token", map[string]interface{}{
+		"title": "example", "genreId": genre.ID, "numberInStock": 1, "dailyRentalRate": 2.5,
+	}, &movie)
+	if code != http.StatusOK {
+		t.Fatalf("expected 200 creating movie, got %d", code)
+	}
+	if movie.Genre.Name != "comedy" {
+		t.Fatalf("expected movie to embed genre comedy, got %+v", movie.Genre)
+	}
+	customer := Customer{}
+	if code := doRequest(t, "POST", url+"/api/customers", "token", Customer{Name: "foobar", Phone: "123456789"}, &customer); code != http.StatusOK {
+		t.Fatalf("expected 200 creating customer, got %d", code)
+	}
+
+	rental := map[string]string{"customerId": customer.ID, "movieId": movie.ID}
+	if code := doRequest(t, "POST", url+"/api/rentals", "token", rental, nil); code != http.StatusOK {
+		t.Fatalf("expected 200 opening rental, got %d", code)
+	}
+	if code := doRequest(t, "POST", url+"/api/rentals", "token", rental, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected 400 renting a movie out of stock, got %d", code)
+	}
+	if code := doRequest(t, "GET", url+"/api/genres/"+genre.ID, "", nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", code)
+	}
+	if code := doRequest(t, "GET", url+"/api/genres/000000000000000000000000", "token", nil, nil); code != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unknown genre, got %d", code)
+	}
+
+	dataset, err := LoadDataset(storage)
+	if err != nil {
+		t.Fatalf("error reading storage file: %s", err)
+	}
+	if len(dataset.Genres) != 1 || len(dataset.Movies) != 1 || len(dataset.Rentals) != 1 {
+		t.Fatalf("expected storage file to hold the created entities, got %+v", dataset)
+	}
+	if dataset.Movies[0].NumberInStock != 0 {
+		t.Fatalf("expected the rental to take the movie out of stock, got %d", dataset.Movies[0].NumberInStock)
+	}
+
+	reloaded := NewService("", nil, WithStoragePath(storage))
+	if loaded, err := reloaded.LoadStorage(); !loaded || err != nil {
+		t.Fatalf("expected storage to be loaded, got %v, %v", loaded, err)
+	}
+	if _, ok := reloaded.movies[movie.ID]; !ok {
+		t.Fatalf("expected movie %s to be loaded from storage", movie.ID)
+	}
+}
+
+func TestService_AuthSecret(t *testing.T) {
+	url := startService(t, NewService("", nil, WithAuthSecret("secret")))
+
+	valid, err := SignToken(Claims{Subject: "5ee05f73d2efa2ae8580f6cd", IsAdmin: true}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := SignToken(Claims{Subject: "5ee05f73d2efa2ae8580f6cd", IsAdmin: true}, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if code := doRequest(t, "GET", url+"/api/genres", valid, nil, nil); code != http.StatusOK {
+		t.Fatalf("expected 200 with a valid token, got %d", code)
+	}
+	if code := doRequest(t, "GET", url+"/api/genres", forged, nil, nil); code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 with a forged token, got %d", code)
+	}
+
+	user, err := SignToken(Claims{Subject: "5ee05f73d2efa2ae8580f6ce"}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := doRequest(t, "GET", url+"/api/genres", user, nil, nil); code != http.StatusOK {
+		t.Fatalf("expected 200 reading with a user token, got %d", code)
+	}
+	for _, route := range []struct{ method, path string }{
+		{"DELETE", "/admin/faults"},
+		{"GET", "/admin/snapshots"},
+		{"POST", "/admin/snapshots"},
+		{"POST", "/admin/reset"},
+	} {
+		if code := doRequest(t, route.method, url+route.path, user, nil, nil); code != http.StatusForbidden {
+			t.Errorf("expected 403 on %s %s with a user token, got %d", route.method, route.path, code)
+		}
+		if code := doRequest(t, route.method, url+route.path, valid, nil, nil); code != http.StatusOK {
+			t.Errorf("expected 200 on %s %s with an admin token, got %d", route.method, route.path, code)
+		}
+	}
+}
+
+func TestService_IfMatch(t *testing.T) {
+	url := startService(t, NewService("", nil))
+
+	genre := Genre{}
+	if code := doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "comedy"}, &genre); code != http.StatusOK {
+		t.Fatalf("expected 200 creating genre, got %d", code)
+	}
+
+	put := func(ifMatch string) int {
+		req, err := http.NewRequest("PUT", url+"/api/genres/"+genre.ID, bytes.NewBufferString(`{"name":"drama"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("x-auth-token", "token")
+		req.Header.Set("If-Match", ifMatch)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := put(`"0"`); code != http.StatusOK {
+		t.Fatalf("expected 200 updating the current version, got %d", code)
+	}
+	if code := put(`"0"`); code != http.StatusPreconditionFailed {
+		t.Fatalf("expected 412 updating a stale version, got %d", code)
+	}
+	if code := put(`"1"`); code != http.StatusOK {
+		t.Fatalf("expected 200 updating the current version, got %d", code)
+	}
+}
+
+func TestService_IdempotencyKey(t *testing.T) {
+	url := startService(t, NewService("", nil))
+
+	post := func(token, key, name string) (int, Genre) {
+		req, err := http.NewRequest("POST", url+"/api/genres", bytes.NewBufferString(`{"name":"`+name+`"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("x-auth-token", token)
+		req.Header.Set("Idempotency-Key", key)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		genre := Genre{}
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&genre); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp.StatusCode, genre
+	}
+
+	_, first := post("token", "key-1", "comedy")
+	code, replayed := post("token", "key-1", "comedy")
+	if code != http.StatusOK || replayed.ID != first.ID {
+		t.Fatalf("expected the retry to replay genre %s, got %d %+v", first.ID, code, replayed)
+	}
+	if code, _ := post("token", "key-1", "drama"); code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected 422 reusing a key for another request, got %d", code)
+	}
+	if code, other := post("token", "key-2", "action"); code != http.StatusOK || other.ID == first.ID {
+		t.Fatalf("expected a new key to create a new genre")
+	}
+	// Keys are scoped to the caller, so another token's request with the same key is handled rather than replayed,
+	// and runs into the unique genre name
+	if code, _ := post("other-token", "key-1", "comedy"); code != http.StatusConflict {
+		t.Fatalf("expected 409 for another caller reusing the key, got %d", code)
+	}
+
+	var genres []Genre
+	doRequest(t, "GET", url+"/api/genres", "token", nil, &genres)
+	if len(genres) != 2 {
+		t.Fatalf("expected 2 genres, got %d", len(genres))
+	}
+}
+
+func TestService_UniqueIndexes(t *testing.T) {
+	url := startService(t, NewService("", nil))
+
+	customer := Customer{}
+	doRequest(t, "POST", url+"/api/customers", "token", Customer{Name: "foobar", Phone: "123456789"}, &customer)
+	other := Customer{}
+	doRequest(t, "POST", url+"/api/customers", "token", Customer{Name: "foobar", Phone: "987654321"}, &other)
+
+	req, err := http.NewRequest("POST", url+"/api/customers", bytes.NewBufferString(`{"name":"barfoo","phone":"123456789"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("x-auth-token", "token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Fatalf("expected 409 for a duplicate phone, got %d", resp.StatusCode)
+	}
+	conflict := Conflict{}
+	if err := json.NewDecoder(resp.Body).Decode(&conflict); err != nil {
+		t.Fatal(err)
+	}
+	if conflict.ID != customer.ID || conflict.Field != "phone" {
+		t.Fatalf("expected a conflict on phone with %s, got %+v", customer.ID, conflict)
+	}
+
+	if code := doRequest(t, "PUT", url+"/api/customers/"+other.ID, "token", Customer{Name: "foobar", Phone: "123456789"}, nil); code != http.StatusConflict {
+		t.Fatalf("expected 409 updating to a duplicate phone, got %d", code)
+	}
+	if code := doRequest(t, "PUT", url+"/api/customers/"+customer.ID, "token", Customer{Name: "renamed", Phone: "123456789"}, nil); code != http.StatusOK {
+		t.Fatalf("expected a customer to keep its own phone, got %d", code)
+	}
+}
+
+func TestService_RateLimit(t *testing.T) {
+	url := startService(t, NewService("", nil, WithRateLimit(1, 2)))
+
+	for i := 0; i < 2; i++ {
+		if code := doRequest(t, "GET", url+"/api/genres", "token", nil, nil); code != http.StatusOK {
+			t.Fatalf("expected request %d within the burst to get 200, got %d", i, code)
+		}
+	}
+
+	resp, err := http.Get(url + "/api/genres")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429 over the rate limit, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Retry-After"); got != "1" {
+		t.Errorf("expected Retry-After 1, got %q", got)
+	}
+}
+
+func TestService_Probes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	storage := filepath.Join(dir, "store.json")
+
+	s := NewService("", nil, WithStoragePath(storage), WithRateLimit(1, 1))
+	url := startService(t, s)
+
+	if code := doRequest(t, "GET", url+"/healthz", "", nil, nil); code != http.StatusOK {
+		t.Errorf("expected /healthz to be 200, got %d", code)
+	}
+	if code := doRequest(t, "GET", url+"/readyz", "", nil, nil); code != http.StatusServiceUnavailable {
+		t.Errorf("expected /readyz to be 503 before the storage file is loaded, got %d", code)
+	}
+	if _, err := s.LoadStorage(); err != nil {
+		t.Fatal(err)
+	}
+	if code := doRequest(t, "GET", url+"/readyz", "", nil, nil); code != http.StatusOK {
+		t.Errorf("expected /readyz to be 200 once the storage file is loaded, got %d", code)
+	}
+
+	doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "comedy"}, nil)
+	if code := doRequest(t, "GET", url+"/api/genres", "token", nil, nil); code != http.StatusTooManyRequests {
+		t.Fatalf("expected the second request to be rate limited, got %d", code)
+	}
+
+	// Probes are not rate limited
+	resp, err := http.Get(url + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`store_http_requests_total{method="POST",route="/api/genres",code="200"} 1`,
+		`store_http_requests_total{method="GET",route="/api/genres",code="429"} 1`,
+		`store_http_request_duration_seconds_count{method="POST",route="/api/genres"} 1`,
+		`store_http_requests_in_flight 1`,
+		`store_entities{collection="genres"} 1`,
+	} {
+		if !bytes.Contains(body, []byte(line+"\n")) {
+			t.Errorf("expected /metrics to contain %s, got:\n%s", line, body)
+		}
+	}
+
+}
+
+func TestService_ReadyzStorageError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	missing := filepath.Join(dir, "missing")
+
+	s := NewService("", nil, WithStoragePath(filepath.Join(missing, "store.json")))
+	if _, err := s.LoadStorage(); err != nil {
+		t.Fatal(err)
+	}
+	url := startService(t, s)
+
+	doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "comedy"}, nil)
+	if code := doRequest(t, "GET", url+"/readyz", "", nil, nil); code != http.StatusServiceUnavailable {
+		t.Errorf("expected /readyz to be 503 after a failed write, got %d", code)
+	}
+
+	if err := os.Mkdir(missing, 0755); err != nil {
+		t.Fatal(err)
+	}
+	doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "drama"}, nil)
+	if code := doRequest(t, "GET", url+"/readyz", "", nil, nil); code != http.StatusOK {
+		t.Errorf("expected /readyz to be 200 once writes succeed again, got %d", code)
+	}
+}
+
+func TestService_AuditLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	auditPath := filepath.Join(dir, "audit.jsonl")
+
+	secret := "s3cret"
+	token, err := SignToken(Claims{Subject: "ci"}, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := startService(t, NewService("", nil, WithAuthSecret(secret), WithAuditLog(auditPath)))
+
+	genre := Genre{}
+	doRequest(t, "POST", url+"/api/genres", token, Genre{Name: "comedy"}, &genre)
+	doRequest(t, "PUT", url+"/api/genres/"+genre.ID, token, Genre{Name: "drama"}, nil)
+	doRequest(t, "DELETE", url+"/api/genres/"+genre.ID, token, nil, nil)
+	doRequest(t, "POST", url+"/api/customers", token, Customer{Name: "foobar", Phone: "12345"}, nil)
+
+	var events []AuditEvent
+	if code := doRequest(t, "GET", url+"/api/audit?entity="+genre.ID, token, nil, &events); code != http.StatusOK {
+		t.Fatalf("expected 200 listing audit events, got %d", code)
+	}
+	if len(events) != 3 {
+		t.Fatalf("expected 3 audit events for the genre, got %d", len(events))
+	}
+	for i, want := range []struct {
+		method, route string
+		before, after bool
+	}{
+		{"POST", "/api/genres", false, true},
+		{"PUT", "/api/genres/{id}", true, true},
+		{"DELETE", "/api/genres/{id}", true, false},
+	} {
+		event := events[i]
+		if event.Method != want.method || event.Route != want.route || event.Collection != "genres" || event.Subject != "ci" || event.Status != http.StatusOK {
+			t.Errorf("unexpected audit event %d: %+v", i, event)
+		}
+		if (event.Before != nil) != want.before || (event.After != nil) != want.after {
+			t.Errorf("expected event %d to have before %v and after %v, got %s and %s", i, want.before, want.after, event.Before, event.After)
+		}
+	}
+	if !bytes.Contains(events[1].Before, []byte(`"comedy"`)) || !bytes.Contains(events[1].After, []byte(`"drama"`)) {
+		t.Errorf("expected the update to record the old and new name, got %s and %s", events[1].Before, events[1].After)
+	}
+
+	reloaded := NewService("", nil, WithAuditLog(auditPath))
+	if err := reloaded.LoadAuditLog(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reloaded.audit.events) != 4 {
+		t.Errorf("expected 4 audit events read back from the audit log, got %d", len(reloaded.audit.events))
+	}
+}
+
+func TestService_Webhooks(t *testing.T) {
+	secret := "0123456789abcdef"
+	received := make(chan WebhookPayload, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if got, want := r.Header.Get("X-Store-Signature"), SignWebhookPayload(body, secret); got != want {
+			t.Errorf("expected signature %s, got %s", want, got)
+		}
+		var payload WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+			return
+		}
+		if got := r.Header.Get("X-Store-Event"); got != payload.Event {
+			t.Errorf("expected X-Store-Event %s, got %s", payload.Event, got)
+		}
+		received <- payload
+	}))
+	defer receiver.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	s := NewService("", nil, WithWebhookRetries(3, time.Millisecond))
+	if err := s.Seed(&Dataset{
+		Genres:    []Genre{{ID: "5ee05b02340e2cae12c1bea5", Name: "sci-fic"}},
+		Customers: []Customer{{ID: "5ee998a7073cfb0d8696fec1", Name: "foobar", Phone: "12345"}},
+		Movies:    []Movie{{ID: "5ee6fe17de7e8d5eb0ae60ea", Title: "sawIII", Genre: Genre{ID: "5ee05b02340e2cae12c1bea5"}, NumberInStock: 2}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	url := startService(t, s)
+
+	var webhook, dead Webhook
+	if code := doRequest(t, "POST", url+"/api/webhooks", "token", Webhook{URL: receiver.URL, Events: []string{"movie.stock_changed", "rental.opened"}, Secret: secret}, &webhook); code != http.StatusOK {
+		t.Fatalf("expected 200 creating webhook, got %d", code)
+	}
+	if webhook.Secret != "" {
+		t.Error("expected the secret not to be returned")
+	}
+	if code := doRequest(t, "POST", url+"/api/webhooks", "token", Webhook{URL: failing.URL, Events: []string{"rental.opened"}, Secret: secret}, &dead); code != http.StatusOK {
+		t.Fatalf("expected 200 creating webhook, got %d", code)
+	}
+
+	rental := Rental{}
+	doRequest(t, "POST", url+"/api/rentals", "token", rentalRequest{CustomerID: "5ee998a7073cfb0d8696fec1", MovieID: "5ee6fe17de7e8d5eb0ae60ea"}, &rental)
+
+	events := map[string]WebhookPayload{}
+	for len(events) < 2 {
+		select {
+		case payload := <-received:
+			events[payload.Event] = payload
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for notifications, got %v", events)
+		}
+	}
+	if !bytes.Contains(events["movie.stock_changed"].Data, []byte(`"numberInStock":1`)) {
+		t.Errorf("expected the stock change to carry the new stock, got %s", events["movie.stock_changed"].Data)
+	}
+	if !bytes.Contains(events["rental.opened"].Data, []byte(rental.ID)) {
+		t.Errorf("expected the rental to be notified, got %s", events["rental.opened"].Data)
+	}
+
+	var deadLetters []DeadLetter
+	for start := time.Now(); len(deadLetters) == 0 && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		doRequest(t, "GET", url+"/api/webhooks/"+dead.ID+"/dead-letters", "token", nil, &deadLetters)
+	}
+	if len(deadLetters) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d", len(deadLetters))
+	}
+	if deadLetters[0].Attempts != 3 || deadLetters[0].Payload.Event != "rental.opened" {
+		t.Errorf("unexpected dead letter: %+v", deadLetters[0])
+	}
+}
+
+func TestService_ShutdownStopsWebhookRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	storage := filepath.Join(dir, "store.json")
+
+	attempted := make(chan struct{}, 10)
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempted <- struct{}{}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	s := NewService("", nil, WithStoragePath(storage), WithWebhookRetries(5, time.Hour))
+	handler := s.Handler()
+	if rec := serveRequest(handler, "POST", "/api/webhooks", `{"url":"`+failing.URL+`","secret":"0123456789abcdef"}`, ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 creating webhook, got %d", rec.Code)
+	}
+	if rec := serveRequest(handler, "POST", "/api/genres", `{"name":"comedy"}`, ""); rec.Code != http.StatusOK {
+		t.Fatalf("expected 200 creating genre, got %d", rec.Code)
+	}
+	select {
+	case <-attempted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for a delivery attempt")
+	}
+	if err := os.Remove(storage); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected the deadline to be exceeded, got %v", err)
+	}
+	if _, err := os.Stat(storage); err != nil {
+		t.Errorf("expected the store to be written on shutdown: %s", err)
+	}
+
+	var deadLetters []DeadLetter
+	for start := time.Now(); len(deadLetters) == 0 && time.Since(start) < 5*time.Second; time.Sleep(10 * time.Millisecond) {
+		s.deliveries.Lock()
+		deadLetters = append(deadLetters, s.deliveries.deadLetters...)
+		s.deliveries.Unlock()
+	}
+	if len(deadLetters) != 1 || deadLetters[0].Attempts != 1 {
+		t.Errorf("expected the delivery waiting to be retried to be dead-lettered after 1 attempt, got %+v", deadLetters)
+	}
+}
+
+func TestService_SoftDelete(t *testing.T) {
+	s := NewService("", nil)
+	url := startService(t, s)
+
+	genre := Genre{}
+	doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "comedy"}, &genre)
+	if code := doRequest(t, "DELETE", url+"/api/genres/"+genre.ID+"?soft=true", "token", nil, nil); code != http.StatusOK {
+		t.Fatalf("expected 200 soft deleting, got %d", code)
+	}
+	if code := doRequest(t, "GET", url+"/api/genres/"+genre.ID, "token", nil, nil); code != http.StatusNotFound {
+		t.Errorf("expected a soft-deleted genre to be 404, got %d", code)
+	}
+	var genres []Genre
+	doRequest(t, "GET", url+"/api/genres", "token", nil, &genres)
+	if len(genres) != 0 {
+		t.Errorf("expected a soft-deleted genre not to be listed, got %v", genres)
+	}
+	deleted := Genre{}
+	if code := doRequest(t, "GET", url+"/api/genres/"+genre.ID+"?deleted=true", "token", nil, &deleted); code != http.StatusOK || deleted.DeletedAt == nil {
+		t.Errorf("expected a soft-deleted genre to be readable with ?deleted=true, got %d %+v", code, deleted)
+	}
+	if code := doRequest(t, "POST", url+"/api/movies", "token", map[string]interface{}{"title": "sawIII", "genreId": genre.ID}, nil); code != http.StatusBadRequest {
+		t.Errorf("expected a movie in a soft-deleted genre to be rejected, got %d", code)
+	}
+
+	// The name of a soft-deleted genre is free to reuse, which blocks the restore
+	other := Genre{}
+	if code := doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "comedy"}, &other); code != http.StatusOK {
+		t.Fatalf("expected the name of a soft-deleted genre to be reusable, got %d", code)
+	}
+	if code := doRequest(t, "POST", url+"/api/genres/"+genre.ID+"/restore", "token", nil, nil); code != http.StatusConflict {
+		t.Errorf("expected restoring over a taken name to be 409, got %d", code)
+	}
+	doRequest(t, "DELETE", url+"/api/genres/"+other.ID, "token", nil, nil)
+	restored := Genre{}
+	if code := doRequest(t, "POST", url+"/api/genres/"+genre.ID+"/restore", "token", nil, &restored); code != http.StatusOK {
+		t.Fatalf("expected 200 restoring, got %d", code)
+	}
+	if restored.DeletedAt != nil || restored.Version != 2 {
+		t.Errorf("expected the restored genre to be live at version 2, got %+v", restored)
+	}
+
+	doRequest(t, "DELETE", url+"/api/genres/"+genre.ID+"?soft=true", "token", nil, nil)
+	unlock := s.lock(writing("genres"), writing("customers"), writing("movies"))
+	purged := s.purge(time.Now().Add(time.Hour))
+	unlock()
+	if purged != 1 {
+		t.Errorf("expected 1 entity to be purged, got %d", purged)
+	}
+	if code := doRequest(t, "GET", url+"/api/genres/"+genre.ID+"?deleted=true", "token", nil, nil); code != http.StatusNotFound {
+		t.Errorf("expected a purged genre to be gone, got %d", code)
+	}
+}
+
+func TestService_PurgeSubgenres(t *testing.T) {
+	s := NewService("", nil)
+	handler := s.Handler()
+
+	var horror, slasher Genre
+	json.Unmarshal(serveRequest(handler, "POST", "/api/genres", `{"name":"horror"}`, "").Body.Bytes(), &horror)
+	json.Unmarshal(serveRequest(handler, "POST", "/api/genres", `{"name":"slasher","parentId":"`+horror.ID+`"}`, "").Body.Bytes(), &slasher)
+	for _, id := range []string{slasher.ID, horror.ID} {
+		if rec := serveRequest(handler, "DELETE", "/api/genres/"+id+"?soft=true", "", ""); rec.Code != http.StatusOK {
+			t.Fatalf("expected 200 soft deleting, got %d", rec.Code)
+		}
+	}
+
+	// A parent whose soft-deleted subgenre is not due yet is kept
+	now := time.Now()
+	later := now.Add(2 * time.Hour)
+	unlock := s.lock(writing("genres"), writing("customers"), writing("movies"))
+	child := s.genres[slasher.ID]
+	child.DeletedAt = &later
+	s.genres[slasher.ID] = child
+	purged := s.purge(now.Add(time.Hour))
+	unlock()
+	if purged != 0 {
+		t.Errorf("expected a genre with a subgenre not to be purged, got %d purged", purged)
+	}
+
+	unlock = s.lock(writing("genres"), writing("customers"), writing("movies"))
+	purged = s.purge(now.Add(3 * time.Hour))
+	unlock()
+	if purged != 2 {
+		t.Errorf("expected the subgenre and then its parent to be purged, got %d purged", purged)
+	}
+
+	s.audit.Lock()
+	defer s.audit.Unlock()
+	audited := map[string]bool{}
+	for _, event := range s.audit.events {
+		if event.Route == "purge" && event.Before != nil && event.After == nil {
+			audited[event.EntityID] = true
+		}
+	}
+	if !audited[horror.ID] || !audited[slasher.ID] {
+		t.Errorf("expected an audit event for each purged genre, got %v", audited)
+	}
+}
+
+func TestService_CustomerFields(t *testing.T) {
+	url := startService(t, NewService("", nil))
+
+	for name, c := range map[string]struct {
+		customer Customer
+		code     int
+	}{
+		"full":            {Customer{Name: "Jane Doe", Phone: "12345", Email: "jane@example.com", Address: &Address{Street: "742 Evergreen Terrace", City: "Springfield", Country: "US"}, MemberSince: "2019-05-01", Notes: "VIP"}, http.StatusOK},
+		"padded name":     {Customer{Name: " Jane Doe", Phone: "12346"}, http.StatusUnprocessableEntity},
+		"bad email":       {Customer{Name: "Jane Doe", Phone: "12347", Email: "jane"}, http.StatusUnprocessableEntity},
+		"bad country":     {Customer{Name: "Jane Doe", Phone: "12348", Address: &Address{Street: "742 Evergreen Terrace", City: "Springfield", Country: "usa"}}, http.StatusUnprocessableEntity},
+		"future member":   {Customer{Name: "Jane Doe", Phone: "12349", MemberSince: time.Now().AddDate(1, 0, 0).Format(dateLayout)}, http.StatusUnprocessableEntity},
+		"bad member date": {Customer{Name: "Jane Doe", Phone: "12350", MemberSince: "May 2019"}, http.StatusUnprocessableEntity},
+	} {
+		if code := doRequest(t, "POST", url+"/api/customers", "token", c.customer, nil); code != c.code {
+			t.Errorf("%s: expected %d, got %d", name, c.code, code)
+		}
+	}
+
+	customer := Customer{}
+	doRequest(t, "POST", url+"/api/customers", "token", Customer{Name: "John Doe", Phone: "54321"}, &customer)
+	if customer.MemberSince != time.Now().UTC().Format(dateLayout) {
+		t.Errorf("expected a new customer to be a member since today, got %q", customer.MemberSince)
+	}
+	updated := Customer{}
+	doRequest(t, "PUT", url+"/api/customers/"+customer.ID, "token", Customer{Name: "John Q. Doe", Phone: "54321", Notes: "moved"}, &updated)
+	if updated.MemberSince != customer.MemberSince || updated.Name != "John Q. Doe" {
+		t.Errorf("expected the update to keep the membership date, got %+v", updated)
+	}
+}
+
+func TestService_MovieFields(t *testing.T) {
+	s := NewService("", nil)
+	if err := s.Seed(&Dataset{Genres: []Genre{{ID: "5ee05b02340e2cae12c1bea5", Name: "sci-fic"}, {ID: "5ee19f2a1363f7c0493761e9", Name: "horror"}}}); err != nil {
+		t.Fatal(err)
+	}
+	url := startService(t, s)
+
+	genres := []string{"5ee19f2a1363f7c0493761e9", "5ee05b02340e2cae12c1bea5"}
+	for name, c := range map[string]struct {
+		movie map[string]interface{}
+		code  int
+	}{
+		"full":          {map[string]interface{}{"title": "sawIII", "genreIds": genres, "releaseYear": 2006, "rating": "R", "runtimeMinutes": 108, "description": "Jigsaw returns"}, http.StatusOK},
+		"no genres":     {map[string]interface{}{"title": "sawIV", "genreIds": []string{}}, http.StatusUnprocessableEntity},
+		"missing genre": {map[string]interface{}{"title": "saw V", "genreIds": []string{"5ee19f2a1363f7c0493761e9", "5ef199b9edf86a20de80b4a2"}}, http.StatusBadRequest},
+		"early year":    {map[string]interface{}{"title": "sawVI", "genreIds": genres, "releaseYear": 1800}, http.StatusUnprocessableEntity},
+		"bad rating":    {map[string]interface{}{"title": "sawVII", "genreIds": genres, "rating": "X"}, http.StatusUnprocessableEntity},
+		"long runtime":  {map[string]interface{}{"title": "sawVIII", "genreIds": genres, "runtimeMinutes": 1001}, http.StatusUnprocessableEntity},
+	} {
+		if code := doRequest(t, "POST", url+"/api/movies", "token", c.movie, nil); code != c.code {
+			t.Errorf("%s: expected %d, got %d", name, c.code, code)
+		}
+	}
+
+	movie := Movie{}
+	doRequest(t, "POST", url+"/api/movies", "token", map[string]interface{}{"title": "Alien", "genreIds": genres, "rating": "R"}, &movie)
+	if len(movie.Genres) != 2 || movie.Genres[0].Name != "horror" || movie.Genre.ID != genres[0] {
+		t.Fatalf("expected the movie to embed both genres with the first as primary, got %+v", movie)
+	}
+
+	genre := Genre{}
+	doRequest(t, "PUT", url+"/api/genres/5ee05b02340e2cae12c1bea5", "token", Genre{Name: "science fiction"}, &genre)
+	doRequest(t, "GET", url+"/api/movies/"+movie.ID, "token", nil, &movie)
+	if movie.Genres[1].Name != "science fiction" || movie.Version != 1 {
+		t.Errorf("expected the renamed genre to be embedded in the movie, got %+v", movie)
+	}
+
+	// A single genreId is still accepted
+	updated := Movie{}
+	doRequest(t, "PUT", url+"/api/movies/"+movie.ID, "token", map[string]interface{}{"title": "Alien", "genreId": "5ee05b02340e2cae12c1bea5", "releaseYear": 1979}, &updated)
+	if len(updated.Genres) != 1 || updated.Genre.Name != "science fiction" || updated.ReleaseYear != 1979 || updated.Rating != "" {
+		t.Errorf("expected the movie to be updated to a single genre, got %+v", updated)
+	}
+}
+
+func TestService_ValidationErrors(t *testing.T) {
+	url := startService(t, NewService("", nil))
+
+	var invalid ValidationErrors
+	customer := Customer{Name: "Jo", Phone: "12345", Email: "jo", Address: &Address{Street: "742 Evergreen Terrace", City: "Springfield", Country: "usa"}}
+	if code := doRequest(t, "POST", url+"/api/customers", "token", customer, &invalid); code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected an invalid customer to be unprocessable, got %d", code)
+	}
+	fields := []string{}
+	for _, e := range invalid.Errors {
+		fields = append(fields, e.Field)
+	}
+	if fmt.Sprint(fields) != "[name email address.country]" || !strings.HasPrefix(invalid.Errors[0].Message, `"name"`) {
+		t.Errorf("expected an error for every invalid field, got %+v", invalid.Errors)
+	}
+
+	invalid = ValidationErrors{}
+	if code := doRequest(t, "POST", url+"/api/movies", "token", map[string]interface{}{"title": "sawIII", "genreIds": []string{"5ee19f2a1363f7c0493761e9"}, "numberInStock": 256}, &invalid); code != http.StatusUnprocessableEntity {
+		t.Fatalf("expected an invalid movie to be unprocessable, got %d", code)
+	}
+	if len(invalid.Errors) != 1 || invalid.Errors[0].Field != "numberInStock" {
+		t.Errorf("expected the stock to be rejected, got %+v", invalid.Errors)
+	}
+
+	// A reference to a missing record is still a bad request
+	if code := doRequest(t, "POST", url+"/api/movies", "token", map[string]interface{}{"title": "sawIII", "genreIds": []string{"5ee19f2a1363f7c0493761e9"}}, nil); code != http.StatusBadRequest {
+		t.Errorf("expected a missing genre to be a bad request, got %d", code)
+	}
+}
+
+func TestService_GenreHierarchy(t *testing.T) {
+	url := startService(t, NewService("", nil))
+
+	horror, slasher, giallo := Genre{}, Genre{}, Genre{}
+	doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "Horror"}, &horror)
+	doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "Slasher", ParentID: horror.ID}, &slasher)
+	doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "Giallo", ParentID: slasher.ID}, &giallo)
+	if giallo.Path != "Horror > Slasher > Giallo" {
+		t.Fatalf("expected the path from the root, got %q", giallo.Path)
+	}
+	if code := doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "Splatter", ParentID: "5ef199b9edf86a20de80b4a2"}, nil); code != http.StatusBadRequest {
+		t.Errorf("expected a missing parent to be rejected with 400, got %d", code)
+	}
+
+	// Horror under Giallo would be its own ancestor, as would a genre that is its own parent
+	for _, parentID := range []string{giallo.ID, horror.ID} {
+		if code := doRequest(t, "PUT", url+"/api/genres/"+horror.ID, "token", Genre{Name: "Horror", ParentID: parentID}, nil); code != http.StatusBadRequest {
+			t.Errorf("expected a cycle through %s to be rejected with 400, got %d", parentID, code)
+		}
+	}
+
+	doRequest(t, "PUT", url+"/api/genres/"+horror.ID, "token", Genre{Name: "Horror films"}, nil)
+	doRequest(t, "GET", url+"/api/genres/"+giallo.ID, "token", nil, &giallo)
+	if giallo.Path != "Horror films > Slasher > Giallo" {
+		t.Errorf("expected the path to follow the renamed root, got %q", giallo.Path)
+	}
+	var events []AuditEvent
+	doRequest(t, "GET", url+"/api/audit?entity="+giallo.ID, "token", nil, &events)
+	if n := len(events); n != 2 || events[n-1].Method != "PUT" || !bytes.Contains(events[n-1].After, []byte(`"__v":1`)) {
+		t.Errorf("expected the rename of the root to be audited against each subgenre, got %+v", events)
+	}
+
+	for _, path := range []string{"/api/genres/" + slasher.ID, "/api/genres/" + slasher.ID + "?soft=true"} {
+		if code := doRequest(t, "DELETE", url+path, "token", nil, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: expected deleting a genre with subgenres to be rejected with 400, got %d", path, code)
+		}
+	}
+
+	// A soft-deleted subgenre still blocks deleting its parent for good, but not soft deleting it
+	doRequest(t, "DELETE", url+"/api/genres/"+giallo.ID+"?soft=true", "token", nil, nil)
+	if code := doRequest(t, "DELETE", url+"/api/genres/"+slasher.ID, "token", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("expected deleting a genre with a soft-deleted subgenre to be rejected with 400, got %d", code)
+	}
+	if code := doRequest(t, "DELETE", url+"/api/genres/"+slasher.ID+"?soft=true", "token", nil, nil); code != http.StatusOK {
+		t.Errorf("expected soft deleting a genre whose subgenres are soft deleted to succeed, got %d", code)
+	}
+	if code := doRequest(t, "POST", url+"/api/genres/"+giallo.ID+"/restore", "token", nil, nil); code != http.StatusBadRequest {
+		t.Errorf("expected restoring a genre whose parent is deleted to be rejected with 400, got %d", code)
+	}
+}
+
+func TestService_RentalBatch(t *testing.T) {
+	s := NewService("", nil)
+	if err := s.Seed(&Dataset{
+		Genres:    []Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "horror"}},
+		Customers: []Customer{{ID: "5ee998a7073cfb0d8696fec1", Name: "foobar", Phone: "123456789"}},
+		Movies: []Movie{
+			{ID: "5ee6fe17de7e8d5eb0ae60ea", Title: "sawIII", Genre: Genre{ID: "5ee19f2a1363f7c0493761e9"}, NumberInStock: 2},
+			{ID: "5ef199b9edf86a20de80b4a2", Title: "sawIV", Genre: Genre{ID: "5ee19f2a1363f7c0493761e9"}, NumberInStock: 1},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	url := startService(t, s)
+
+	// sawIV runs out of stock halfway, so nothing is rented
+	batch := rentalBatchRequest{CustomerID: "5ee998a7073cfb0d8696fec1", MovieIDs: []string{"5ee6fe17de7e8d5eb0ae60ea", "5ef199b9edf86a20de80b4a2", "5ef199b9edf86a20de80b4a2"}}
+	if code := doRequest(t, "POST", url+"/api/rentals/batch", "token", batch, nil); code != http.StatusBadRequest {
+		t.Fatalf("expected a batch without enough stock to be rejected with 400, got %d", code)
+	}
+	movie := Movie{}
+	doRequest(t, "GET", url+"/api/movies/5ee6fe17de7e8d5eb0ae60ea", "token", nil, &movie)
+	if movie.NumberInStock != 2 {
+		t.Fatalf("expected a rejected batch to leave the stock alone, got %d", movie.NumberInStock)
+	}
+
+	batch.MovieIDs = []string{"5ee6fe17de7e8d5eb0ae60ea", "5ef199b9edf86a20de80b4a2", "5ee6fe17de7e8d5eb0ae60ea"}
+	var rentals []Rental
+	if code := doRequest(t, "POST", url+"/api/rentals/batch", "token", batch, &rentals); code != http.StatusOK {
+		t.Fatalf("expected the batch to be opened, got %d", code)
+	}
+	if len(rentals) != 3 || rentals[1].Movie.ID != "5ef199b9edf86a20de80b4a2" || !rentals[0].DateOut.Equal(rentals[2].DateOut) {
+		t.Fatalf("expected three rentals in the order of the movies, got %+v", rentals)
+	}
+	doRequest(t, "GET", url+"/api/movies/5ee6fe17de7e8d5eb0ae60ea", "token", nil, &movie)
+	if movie.NumberInStock != 0 {
+		t.Errorf("expected both copies of sawIII to be rented out, got %d", movie.NumberInStock)
+	}
+
+	var events []AuditEvent
+	doRequest(t, "GET", url+"/api/audit?collection=rentals", "token", nil, &events)
+	if len(events) != 4 || events[3].EntityID != rentals[2].ID {
+		t.Errorf("expected the rejected batch and an event for each rental to be audited, got %+v", events)
+	}
+}
+
+func TestService_Bulk(t *testing.T) {
+	url := startService(t, NewService("", nil))
+
+	var created []BulkResult
+	genres := []Genre{{Name: "comedy"}, {Name: "drama"}, {Name: "comedy"}, {Name: "sf"}}
+	if code := doRequest(t, "POST", url+"/api/genres/bulk", "token", genres, &created); code != http.StatusOK {
+		t.Fatalf("expected the bulk create to be handled, got %d", code)
+	}
+	statuses := []int{}
+	for _, result := range created {
+		statuses = append(statuses, result.Status)
+	}
+	if fmt.Sprint(statuses) != "[200 200 409 422]" {
+		t.Fatalf("expected each item to get its own status, got %v", statuses)
+	}
+	var comedy Genre
+	if err := json.Unmarshal(created[0].Body, &comedy); err != nil || comedy.ID == "" {
+		t.Fatalf("expected the created genre in the body, got %s", created[0].Body)
+	}
+	var conflict Conflict
+	if err := json.Unmarshal(created[2].Body, &conflict); err != nil || conflict.ID != comedy.ID {
+		t.Errorf("expected the duplicate to conflict with %s, got %s", comedy.ID, created[2].Body)
+	}
+	var invalid ValidationErrors
+	if err := json.Unmarshal(created[3].Body, &invalid); err != nil || len(invalid.Errors) != 1 || invalid.Errors[0].Field != "name" {
+		t.Errorf("expected a validation error for the short name, got %s", created[3].Body)
+	}
+
+	var updated []BulkResult
+	doRequest(t, "PUT", url+"/api/genres/bulk", "token", []map[string]interface{}{
+		{"_id": comedy.ID, "name": "comedies", "__v": 0},
+		{"_id": comedy.ID, "name": "stale", "__v": 0},
+		{"name": "nameless"},
+	}, &updated)
+	if len(updated) != 3 || updated[0].Status != http.StatusOK || updated[1].Status != http.StatusPreconditionFailed || updated[2].Status != http.StatusBadRequest {
+		t.Fatalf("expected the update, a stale update and a missing _id, got %+v", updated)
+	}
+
+	var deleted []BulkResult
+	doRequest(t, "DELETE", url+"/api/genres/bulk?soft=true", "token", []map[string]string{{"_id": comedy.ID}, {"_id": "5ef199b9edf86a20de80b4a2"}}, &deleted)
+	if len(deleted) != 2 || deleted[0].Status != http.StatusOK || deleted[1].Status != http.StatusNotFound {
+		t.Fatalf("expected the delete and a missing genre, got %+v", deleted)
+	}
+	if code := doRequest(t, "GET", url+"/api/genres/"+comedy.ID+"?deleted=true", "token", nil, nil); code != http.StatusOK {
+		t.Errorf("expected ?soft=true to apply to every item, got %d", code)
+	}
+
+	var events []AuditEvent
+	doRequest(t, "GET", url+"/api/audit?entity="+comedy.ID, "token", nil, &events)
+	if len(events) != 4 || events[0].Route != "/api/genres/bulk" {
+		t.Errorf("expected every item touching the genre to be audited, got %+v", events)
+	}
+}
+
+func TestService_IfNoneMatch(t *testing.T) {
+	url := startService(t, NewService("", nil))
+
+	horror, slasher := Genre{}, Genre{}
+	doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "Horror"}, &horror)
+	doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "Slasher", ParentID: horror.ID}, &slasher)
+
+	get := func(path, ifNoneMatch string) (int, string) {
+		req, err := http.NewRequest("GET", url+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("x-auth-token", "token")
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode == http.StatusNotModified && len(body) != 0 {
+			t.Errorf("%s: expected a 304 without a body, got %q", path, body)
+		}
+		return resp.StatusCode, resp.Header.Get("ETag")
+	}
+
+	for _, path := range []string{"/api/genres/" + slasher.ID, "/api/genres"} {
+		code, tag := get(path, "")
+		if code != http.StatusOK || tag == "" || strings.HasPrefix(tag, "W/") {
+			t.Fatalf("%s: expected 200 with a strong ETag, got %d %q", path, code, tag)
+		}
+		if code, _ := get(path, tag); code != http.StatusNotModified {
+			t.Errorf("%s: expected 304 for the current ETag, got %d", path, code)
+		}
+		if code, _ := get(path, `"stale", W/`+tag); code != http.StatusNotModified {
+			t.Errorf("%s: expected 304 when any listed ETag matches, got %d", path, code)
+		}
+	}
+
+	// Renaming the parent changes the path of the subgenre, and so its ETag
+	_, tag := get("/api/genres/"+slasher.ID, "")
+	doRequest(t, "PUT", url+"/api/genres/"+horror.ID, "token", Genre{Name: "Horror films"}, nil)
+	if code, _ := get("/api/genres/"+slasher.ID, tag); code != http.StatusOK {
+		t.Errorf("expected 200 for a subgenre whose path changed, got %d", code)
+	}
+
+	// Restoring a snapshot rolls the version back, so the same version comes to stand for another name
+	doRequest(t, "POST", url+"/admin/snapshots", "token", map[string]string{"name": "before"}, nil)
+	doRequest(t, "PUT", url+"/api/genres/"+horror.ID, "token", Genre{Name: "Horror movies"}, nil)
+	_, tag = get("/api/genres/"+horror.ID, "")
+	doRequest(t, "POST", url+"/admin/snapshots/before/restore", "token", nil, nil)
+	doRequest(t, "PUT", url+"/api/genres/"+horror.ID, "token", Genre{Name: "Scary movies"}, nil)
+	if code, _ := get("/api/genres/"+horror.ID, tag); code != http.StatusOK {
+		t.Errorf("expected 200 for a genre renamed after a restore, got %d", code)
+	}
+	if code, _ := get("/api/genres/5ef199b9edf86a20de80b4a2", "*"); code != http.StatusNotFound {
+		t.Errorf("expected a missing genre to stay 404, got %d", code)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer that the server and the test can write and read at once
+type lockedBuffer struct {
+	buf bytes.Buffer
+	sync.Mutex
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestService_Middleware(t *testing.T) {
+	accessLog := &lockedBuffer{}
+	s := NewService("", nil, WithAuthSecret("secret"), WithAccessLog(accessLog), WithCORS("https://store.example"))
+	url := startService(t, s)
+	token, err := SignToken(Claims{Subject: "5ee05f73d2efa2ae8580f6cd"}, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	send := func(method, path, requestID, origin string) *http.Response {
+		req, err := http.NewRequest(method, url+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("x-auth-token", token)
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+			req.Header.Set("Access-Control-Request-Method", "PUT")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp
+	}
+
+	if id := send("GET", "/api/genres", "", "").Header.Get("X-Request-ID"); id == "" {
+		t.Error("expected a request ID to be generated")
+	}
+	if id := send("GET", "/api/genres", "tf-5f1c", "").Header.Get("X-Request-ID"); id != "tf-5f1c" {
+		t.Errorf("expected the request ID to be propagated, got %q", id)
+	}
+	if id := send("GET", "/api/genres", "not a valid id", "").Header.Get("X-Request-ID"); id == "not a valid id" {
+		t.Error("expected a request ID with spaces to be replaced")
+	}
+
+	var entry AccessLogEntry
+	for _, line := range strings.Split(strings.TrimSpace(accessLog.String()), "\n") {
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("expected a JSON access log line, got %q", line)
+		}
+		if entry.RequestID == "tf-5f1c" {
+			break
+		}
+	}
+	if entry.RequestID != "tf-5f1c" || entry.Status != http.StatusOK || entry.Bytes == 0 || entry.Subject != "5ee05f73d2efa2ae8580f6cd" || entry.Path != "/api/genres" {
+		t.Errorf("expected the access log to record the request, got %+v", entry)
+	}
+
+	preflight := send("OPTIONS", "/api/genres/5ee05f73d2efa2ae8580f6cd", "", "https://store.example")
+	if preflight.StatusCode != http.StatusNoContent || preflight.Header.Get("Access-Control-Allow-Origin") != "https://store.example" ||
+		!strings.Contains(preflight.Header.Get("Access-Control-Allow-Headers"), "If-Match") {
+		t.Errorf("expected the preflight of an allowed origin to be answered, got %d %v", preflight.StatusCode, preflight.Header)
+	}
+	if origin := send("GET", "/api/genres", "", "https://other.example").Header.Get("Access-Control-Allow-Origin"); origin != "" {
+		t.Errorf("expected no CORS headers for an origin that is not allowed, got %q", origin)
+	}
+
+	h := chain(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { panic("boom") }), requestID, recoverPanics)
+	req := httptest.NewRequest("GET", "/api/genres", nil)
+	req.Header.Set("X-Request-ID", "tf-panic")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "tf-panic") {
+		t.Errorf("expected a panic to be answered with 500 naming the request ID, got %d %q", rec.Code, rec.Body)
+	}
+}
+
+func TestService_Faults(t *testing.T) {
+	s := NewService("", nil, WithFaultRules(FaultRule{Route: "/api/genres", Method: "GET", EveryNth: 2, Action: FaultStatus, Status: http.StatusServiceUnavailable}))
+	url := startService(t, s)
+
+	var invalid ValidationErrors
+	rules := FaultRules{Rules: []FaultRule{{Route: "api/genres", Action: "explode"}}}
+	if code := doRequest(t, "PUT", url+"/admin/faults", "token", rules, &invalid); code != http.StatusUnprocessableEntity || len(invalid.Errors) != 2 {
+		t.Fatalf("expected the route and action to be rejected, got %d %+v", code, invalid.Errors)
+	}
+
+	codes := []int{}
+	for i := 0; i < 4; i++ {
+		codes = append(codes, doRequest(t, "GET", url+"/api/genres", "token", nil, nil))
+	}
+	if fmt.Sprint(codes) != "[200 503 200 503]" {
+		t.Errorf("expected every second request to fail, got %v", codes)
+	}
+
+	genre := Genre{}
+	doRequest(t, "POST", url+"/api/genres", "token", Genre{Name: "comedy"}, &genre)
+	rules = FaultRules{Rules: []FaultRule{
+		{Route: "/api/genres/*", Method: "GET", Action: FaultBadBody},
+		{Route: "/api/customers", Method: "POST", Times: 1, Action: FaultReset},
+		{Route: "/api/movies", Action: FaultDelay, DelayMs: 100},
+	}}
+	if code := doRequest(t, "PUT", url+"/admin/faults", "token", rules, nil); code != http.StatusOK {
+		t.Fatalf("expected the rules to be replaced, got %d", code)
+	}
+
+	req, _ := http.NewRequest("GET", url+"/api/genres/"+genre.ID, nil)
+	req.Header.Set("x-auth-token", "token")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || json.Valid(body) || !strings.HasPrefix(string(body), `{"_id"`) {
+		t.Errorf("expected a truncated body, got %d %q", resp.StatusCode, body)
+	}
+
+	req, _ = http.NewRequest("POST", url+"/api/customers", strings.NewReader(`{"name":"Jane Doe","phone":"12345"}`))
+	req.Header.Set("x-auth-token", "token")
+	if resp, err := http.DefaultClient.Do(req); err == nil {
+		resp.Body.Close()
+		t.Error("expected the connection to be reset")
+	}
+	if code := doRequest(t, "POST", url+"/api/customers", "token", Customer{Name: "Jane Doe", Phone: "12345"}, nil); code != http.StatusOK {
+		t.Errorf("expected the reset to happen once, got %d", code)
+	}
+
+	start := time.Now()
+	doRequest(t, "GET", url+"/api/movies", "token", nil, nil)
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("expected the request to be delayed, took %s", elapsed)
+	}
+
+	var cleared FaultRules
+	doRequest(t, "DELETE", url+"/admin/faults", "token", nil, &cleared)
+	if len(cleared.Rules) != 0 || doRequest(t, "GET", url+"/api/genres/"+genre.ID, "token", nil, &genre) != http.StatusOK {
+		t.Errorf("expected the rules to be removed, got %+v", cleared)
+	}
+}
+
+func TestService_Snapshots(t *testing.T) {
+	s := NewService("", nil)
+	if err := s.Seed(&Dataset{Genres: []Genre{{ID: "5ee05b02340e2cae12c1bea5", Name: "sci-fic"}}}); err != nil {
+		t.Fatal(err)
+	}
+	url := startService(t, s)
+
+	var snapshot Snapshot
+	if code := doRequest(t, "POST", url+"/admin/snapshots", "token", map[string]string{"name": "before"}, &snapshot); code != http.StatusOK || snapshot.Genres != 1 {
+		t.Fatalf("expected the snapshot to hold the seeded genre, got %d %+v", code, snapshot)
+	}
+	if code := doRequest(t, "POST", url+"/admin/snapshots", "token", map[string]string{"name": "not/a/name"}, nil); code != http.StatusUnprocessableEntity {
+		t.Errorf("expected a name with slashes to be rejected, got %d", code)
+	}
+
+	postComedy := func() string {
+		req, err := http.NewRequest("POST", url+"/api/genres", bytes.NewBufferString(`{"name":"comedy"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("x-auth-token", "token")
+		req.Header.Set("Idempotency-Key", "comedy")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		genre := Genre{}
+		if err := json.NewDecoder(resp.Body).Decode(&genre); err != nil {
+			t.Fatal(err)
+		}
+		return genre.ID
+	}
+	comedy := postComedy()
+	doRequest(t, "PUT", url+"/api/genres/5ee05b02340e2cae12c1bea5", "token", Genre{Name: "science fiction"}, nil)
+	if code := doRequest(t, "POST", url+"/admin/snapshots/before/restore", "token", nil, nil); code != http.StatusOK {
+		t.Fatalf("expected the snapshot to be restored, got %d", code)
+	}
+	var genres []Genre
+	doRequest(t, "GET", url+"/api/genres", "token", nil, &genres)
+	if len(genres) != 1 || genres[0].Name != "sci-fic" {
+		t.Errorf("expected the store to be back to the snapshot, got %+v", genres)
+	}
+
+	// A create retried after the restore must not be answered with the genre the restore removed
+	if retried := postComedy(); retried == comedy || doRequest(t, "GET", url+"/api/genres/"+retried, "token", nil, nil) != http.StatusOK {
+		t.Errorf("expected the retried create to make a new genre, got %s", retried)
+	}
+	doRequest(t, "POST", url+"/admin/snapshots/before/restore", "token", nil, nil)
+	if code := doRequest(t, "POST", url+"/admin/snapshots/missing/restore", "token", nil, nil); code != http.StatusNotFound {
+		t.Errorf("expected an unknown snapshot to be not found, got %d", code)
+	}
+
+	var reset Snapshot
+	doRequest(t, "POST", url+"/admin/reset", "token", nil, &reset)
+	if reset.Genres != 0 || doRequest(t, "GET", url+"/api/genres/5ee05b02340e2cae12c1bea5", "token", nil, nil) != http.StatusNotFound {
+		t.Errorf("expected the store to be emptied, got %+v", reset)
+	}
+	doRequest(t, "POST", url+"/admin/reset?seed=true", "token", nil, &reset)
+	if reset.Genres != 1 || doRequest(t, "GET", url+"/api/genres/5ee05b02340e2cae12c1bea5", "token", nil, nil) != http.StatusOK {
+		t.Errorf("expected the store to be seeded again, got %+v", reset)
+	}
+
+	var list []Snapshot
+	doRequest(t, "DELETE", url+"/admin/snapshots/before", "token", nil, nil)
+	doRequest(t, "GET", url+"/admin/snapshots", "token", nil, &list)
+	if len(list) != 0 {
+		t.Errorf("expected the snapshot to be deleted, got %+v", list)
+	}
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Snapshot describes a copy of the store collections, taken with POST /admin/snapshots and put back with
+// POST /admin/snapshots/{name}/restore, and how many entities of each collection it holds
+type Snapshot struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+	Genres    int       `json:"genres"`
+	Customers int       `json:"customers"`
+	Movies    int       `json:"movies"`
+	Rentals   int       `json:"rentals"`
+	Webhooks  int       `json:"webhooks"`
+}
+
+// snapshots holds the snapshots of a Service by name, and the datasets it has been seeded with so that it can be
+// reset to them
+type snapshots struct {
+	byName map[string]*storedSnapshot
+	seeds  []*Dataset
+	sync.Mutex
+}
+
+// storedSnapshot is a Snapshot with the collections it copied
+type storedSnapshot struct {
+	Snapshot
+	dataset *Dataset
+}
+
+// snapshotName is what the name of a snapshot may look like, so that it can be used in a path
+var snapshotName = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// describe returns the Snapshot of dataset, named name
+func describe(name string, createdAt time.Time, dataset *Dataset) Snapshot {
+	return Snapshot{
+		Name:      name,
+		CreatedAt: createdAt,
+		Genres:    len(dataset.Genres),
+		Customers: len(dataset.Customers),
+		Movies:    len(dataset.Movies),
+		Rentals:   len(dataset.Rentals),
+		Webhooks:  len(dataset.Webhooks),
+	}
+}
+
+// replaceStore empties the store collections and then adds the entities of each of datasets, persisting the result.
+// The responses recorded for Idempotency-Keys are forgotten, as the entities they describe may be gone. Does not lock access to the store, expects the calling method to hold the locks of every collection but items
+func (s *Service) replaceStore(datasets ...*Dataset) {
+	s.genres = map[string]Genre{}
+	s.customers = map[string]Customer{}
+	s.movies = map[string]Movie{}
+	s.rentals = map[string]Rental{}
+	s.webhooks = map[string]Webhook{}
+	for _, dataset := range datasets {
+		s.seed(dataset)
+	}
+	s.saved(lockOrder[1:]...)
+	s.idempotency.forget()
+}
+
+// PostSnapshot copies the store collections into a snapshot named by the request body, replacing any snapshot with the
+// same name. The snapshot is given a new name when the body does not name it
+func (s *Service) PostSnapshot(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if r.ContentLength != 0 && !decodeBody(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		req.Name = newObjectID()
+	}
+	var errs fieldErrors
+	if !snapshotName.MatchString(req.Name) {
+		errs.add("name", `"name" must be 1 to 128 letters, digits, dots, dashes or underscores`)
+	}
+	if !checkValid(w, errs) {
+		return
+	}
+
+	dataset := &Dataset{}
+	unlock := s.lock(reading("genres"), reading("customers"), reading("movies"), reading("rentals"), reading("webhooks"))
+	s.copyCollections(dataset, lockOrder[1:]...)
+	unlock()
+
+	snapshot := &storedSnapshot{Snapshot: describe(req.Name, time.Now().UTC(), dataset), dataset: dataset}
+	s.snapshots.Lock()
+	s.snapshots.byName[req.Name] = snapshot
+	s.snapshots.Unlock()
+	log.Printf("took snapshot %s", req.Name)
+	writeJSON(w, snapshot.Snapshot)
+}
+
+// GetSnapshots writes the snapshots of the store, oldest first
+func (s *Service) GetSnapshots(w http.ResponseWriter, r *http.Request) {
+	s.snapshots.Lock()
+	list := make([]Snapshot, 0, len(s.snapshots.byName))
+	for _, snapshot := range s.snapshots.byName {
+		list = append(list, snapshot.Snapshot)
+	}
+	s.snapshots.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].Name < list[j].Name
+	})
+	writeJSON(w, list)
+}
+
+// RestoreSnapshot replaces the store collections with the snapshot named in the path
+func (s *Service) RestoreSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	s.snapshots.Lock()
+	snapshot, ok := s.snapshots.byName[name]
+	s.snapshots.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("The snapshot with the given name %s was not found.", name), http.StatusNotFound)
+		return
+	}
+
+	unlock := s.lock(writingAll()...)
+	s.replaceStore(snapshot.dataset)
+	unlock()
+	log.Printf("restored snapshot %s", name)
+	writeJSON(w, snapshot.Snapshot)
+}
+
+// DeleteSnapshot removes the snapshot named in the path
+func (s *Service) DeleteSnapshot(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	s.snapshots.Lock()
+	snapshot, ok := s.snapshots.byName[name]
+	delete(s.snapshots.byName, name)
+	s.snapshots.Unlock()
+	if !ok {
+		http.Error(w, fmt.Sprintf("The snapshot with the given name %s was not found.", name), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, snapshot.Snapshot)
+}
+
+// PostReset empties the store collections or, with ?seed=true, puts them back to the datasets the Service was seeded
+// with. It writes what the store holds afterwards
+func (s *Service) PostReset(w http.ResponseWriter, r *http.Request) {
+	var seeds []*Dataset
+	if r.URL.Query().Get("seed") == "true" {
+		s.snapshots.Lock()
+		seeds = s.snapshots.seeds
+		s.snapshots.Unlock()
+	}
+
+	dataset := &Dataset{}
+	unlock := s.lock(writingAll()...)
+	s.replaceStore(seeds...)
+	s.copyCollections(dataset, lockOrder[1:]...)
+	unlock()
+	log.Printf("reset the store to %d seed datasets", len(seeds))
+	writeJSON(w, describe("", time.Now().UTC(), dataset))
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// WithPurgeAfter makes the Service remove soft-deleted genres, customers and movies for good once they have been
+// deleted for longer than d. Soft-deleted entities are kept until they are hard deleted when d is zero
+func WithPurgeAfter(d time.Duration) Option {
+	return func(s *Service) {
+		s.purgeAfter = d
+	}
+}
+
+// purgeInterval returns how often to look for soft-deleted entities to purge, so that none outlives the purge-after
+// period by much
+func purgeInterval(purgeAfter time.Duration) time.Duration {
+	interval := purgeAfter / 10
+	if interval > time.Minute {
+		return time.Minute
+	}
+	if interval < 10*time.Millisecond {
+		return 10 * time.Millisecond
+	}
+	return interval
+}
+
+// includeDeleted reports whether the request asks for soft-deleted entities to be included, with ?deleted=true
+func includeDeleted(r *http.Request) bool {
+	return r.URL.Query().Get("deleted") == "true"
+}
+
+// softDelete reports whether the request asks for a soft delete, with ?soft=true
+func softDelete(r *http.Request) bool {
+	return r.URL.Query().Get("soft") == "true"
+}
+
+// deletedAt returns the time the entity was soft deleted at, or nil when it is not deleted
+func deletedAt(entity interface{}) *time.Time {
+	switch e := entity.(type) {
+	case Genre:
+		return e.DeletedAt
+	case Customer:
+		return e.DeletedAt
+	case Movie:
+		return e.DeletedAt
+	}
+	return nil
+}
+
+// purge removes the genres, customers and movies that were soft deleted before cutoff, reporting how many it
+// removed. A genre is kept while it has subgenres, deleted or not, so subgenres are purged before their parents.
+// Records an audit event for each entity it removes. Does not lock access to the store, expects the calling method
+// to lock genres, customers and movies
+func (s *Service) purge(cutoff time.Time) int {
+	purged := 0
+	for removed := true; removed; {
+		removed = false
+		for id, genre := range s.genres {
+			if genre.DeletedAt != nil && genre.DeletedAt.Before(cutoff) && !s.hasChildren(id, true) {
+				s.auditPurge("genres", id)
+				delete(s.genres, id)
+				removed = true
+				purged++
+			}
+		}
+	}
+	for id, customer := range s.customers {
+		if customer.DeletedAt != nil && customer.DeletedAt.Before(cutoff) {
+			s.auditPurge("customers", id)
+			delete(s.customers, id)
+			purged++
+		}
+	}
+	for id, movie := range s.movies {
+		if movie.DeletedAt != nil && movie.DeletedAt.Before(cutoff) {
+			s.auditPurge("movies", id)
+			delete(s.movies, id)
+			purged++
+		}
+	}
+	if purged > 0 {
+		s.saved("genres", "customers", "movies")
+	}
+	return purged
+}
+
+// auditPurge records an audit event for the purge of the entity of collection with ID id, which is about to be
+// removed. The purge is not made by a request, so the event has no token and its route is "purge". Does not lock
+// access to the store, expects the calling method to lock collection
+func (s *Service) auditPurge(collection, id string) {
+	s.audit.record(AuditEvent{
+		ID:         newObjectID(),
+		Time:       time.Now().UTC(),
+		Method:     http.MethodDelete,
+		Route:      "purge",
+		Collection: collection,
+		EntityID:   id,
+		Status:     http.StatusOK,
+		Before:     s.entityJSON(collection, id),
+	})
+}
+
+// purgeExpired purges the soft-deleted entities older than the purge-after period of the Service, every interval,
+// until stop is closed
+func (s *Service) purgeExpired(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			unlock := s.lock(writing("genres"), writing("customers"), writing("movies"))
+			purged := s.purge(now.Add(-s.purgeAfter))
+			unlock()
+			if purged > 0 {
+				log.Printf("purged %d soft-deleted entities", purged)
+			}
+		}
+	}
+}
+
+// RestoreGenre handles undoing the soft delete of a Genre with a specific ID. A genre whose name has been taken since
+// it was deleted is rejected with 409, and one whose parent is no longer in the store with 400
+func (s *Service) RestoreGenre(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(writing("genres"))
+	defer unlock()
+
+	genre, ok := s.genres[id]
+	if !ok {
+		http.Error(w, "The genre with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	if genre.DeletedAt == nil {
+		http.Error(w, "The genre is not deleted.", http.StatusBadRequest)
+		return
+	}
+	if !s.checkParent(w, genre) || !s.checkUnique(w, "genres", id, genre) {
+		return
+	}
+
+	genre.DeletedAt = nil
+	genre.Version++
+	tracked := s.track(r, "genres", id)
+	s.genres[id] = genre
+	tracked()
+	s.saved("genres")
+	log.Printf("restored genre: %s", id)
+	writeJSON(w, s.withPath(genre))
+}
+
+// RestoreCustomer handles undoing the soft delete of a Customer with a specific ID. A customer whose phone has been
+// taken since it was deleted is rejected with 409
+func (s *Service) RestoreCustomer(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(writing("customers"))
+	defer unlock()
+
+	customer, ok := s.customers[id]
+	if !ok {
+		http.Error(w, "The customer with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	if customer.DeletedAt == nil {
+		http.Error(w, "The customer is not deleted.", http.StatusBadRequest)
+		return
+	}
+	if !s.checkUnique(w, "customers", id, customer) {
+		return
+	}
+
+	customer.DeletedAt = nil
+	customer.Version++
+	tracked := s.track(r, "customers", id)
+	s.customers[id] = customer
+	tracked()
+	s.saved("customers")
+	log.Printf("restored customer: %s", id)
+	writeJSON(w, customer)
+}
+
+// RestoreMovie handles undoing the soft delete of a Movie with a specific ID. A movie whose title has been taken
+// since it was deleted, or one of whose genres is no longer in the store, is rejected
+func (s *Service) RestoreMovie(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(reading("genres"), writing("movies"))
+	defer unlock()
+
+	movie, ok := s.movies[id]
+	if !ok {
+		http.Error(w, "The movie with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	if movie.DeletedAt == nil {
+		http.Error(w, "The movie is not deleted.", http.StatusBadRequest)
+		return
+	}
+	ids := make([]string, 0, len(movie.Genres))
+	for _, genre := range movie.Genres {
+		ids = append(ids, genre.ID)
+	}
+	genres, ok := s.movieGenres(ids)
+	if !ok {
+		http.Error(w, "Invalid genre.", http.StatusBadRequest)
+		return
+	}
+	if !s.checkUnique(w, "movies", id, movie) {
+		return
+	}
+
+	movie.DeletedAt = nil
+	movie.Genre = genres[0]
+	movie.Genres = genres
+	movie.Version++
+	tracked := s.track(r, "movies", id)
+	s.movies[id] = movie
+	tracked()
+	s.saved("movies")
+	log.Printf("restored movie: %s", id)
+	writeJSON(w, movie)
+}
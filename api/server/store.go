@@ -0,0 +1,375 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Genre represents a single movie genre. A genre with a ParentID is a subgenre of that genre. Path, the names of the
+// genre and its ancestors from the root down, is worked out when the genre is read and never stored
+type Genre struct {
+	ID        string     `json:"_id"`
+	Name      string     `json:"name"`
+	ParentID  string     `json:"parentId,omitempty"`
+	Path      string     `json:"path,omitempty"`
+	Version   int        `json:"__v"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Customer represents a single customer of the store
+type Customer struct {
+	ID          string     `json:"_id"`
+	Name        string     `json:"name"`
+	IsGold      bool       `json:"isGold"`
+	Phone       string     `json:"phone"`
+	Email       string     `json:"email,omitempty"`
+	Address     *Address   `json:"address,omitempty"`
+	MemberSince string     `json:"memberSince,omitempty"`
+	Notes       string     `json:"notes,omitempty"`
+	Version     int        `json:"__v"`
+	DeletedAt   *time.Time `json:"deletedAt,omitempty"`
+}
+
+// Address is the postal address of a customer
+type Address struct {
+	Street     string `json:"street"`
+	City       string `json:"city"`
+	Region     string `json:"region,omitempty"`
+	PostalCode string `json:"postalCode,omitempty"`
+	Country    string `json:"country"`
+}
+
+// Movie represents a single movie in the catalog, embedding copies of its genres. Genre is the first of Genres, kept
+// for clients that expect a single genre
+type Movie struct {
+	ID              string     `json:"_id"`
+	Title           string     `json:"title"`
+	Genre           Genre      `json:"genre"`
+	Genres          []Genre    `json:"genres"`
+	NumberInStock   int        `json:"numberInStock"`
+	DailyRentalRate float64    `json:"dailyRentalRate"`
+	ReleaseYear     int        `json:"releaseYear,omitempty"`
+	Rating          string     `json:"rating,omitempty"`
+	RuntimeMinutes  int        `json:"runtimeMinutes,omitempty"`
+	Description     string     `json:"description,omitempty"`
+	Version         int        `json:"__v"`
+	DeletedAt       *time.Time `json:"deletedAt,omitempty"`
+}
+
+// RentalCustomer is the copy of a customer embedded in a rental
+type RentalCustomer struct {
+	ID     string `json:"_id"`
+	Name   string `json:"name"`
+	IsGold bool   `json:"isGold"`
+	Phone  string `json:"phone"`
+}
+
+// RentalMovie is the copy of a movie embedded in a rental
+type RentalMovie struct {
+	ID              string  `json:"_id"`
+	Title           string  `json:"title"`
+	DailyRentalRate float64 `json:"dailyRentalRate"`
+}
+
+// Rental represents a single movie rented out to a customer
+type Rental struct {
+	ID           string         `json:"_id"`
+	Customer     RentalCustomer `json:"customer"`
+	Movie        RentalMovie    `json:"movie"`
+	DateOut      time.Time      `json:"dateOut"`
+	DateReturned *time.Time     `json:"dateReturned,omitempty"`
+	RentalFee    float64        `json:"rentalFee,omitempty"`
+}
+
+// Dataset is the serialised form of the store collections. It is used both for seed fixtures and for the
+// on-disk storage file
+type Dataset struct {
+	Genres    []Genre    `json:"genres"`
+	Customers []Customer `json:"customers"`
+	Movies    []Movie    `json:"movies"`
+	Rentals   []Rental   `json:"rentals"`
+	Webhooks  []Webhook  `json:"webhooks,omitempty"`
+}
+
+// LoadDataset reads a Dataset in JSON form from the file at path
+func LoadDataset(path string) (*Dataset, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dataset := &Dataset{}
+	err = json.Unmarshal(data, dataset)
+	if err != nil {
+		return nil, err
+	}
+	return dataset, nil
+}
+
+// storage is the file the store is written to after every change, with the copy of each collection that was last
+// written. A change only copies the collections it made, so that it does not have to lock the others
+type storage struct {
+	path    string
+	dataset Dataset
+	loaded  bool
+	err     error
+	sync.Mutex
+}
+
+// Seed adds every entity of the dataset to the store, replacing any entity with the same ID. Entities without an
+// ID are given a new one. The dataset is remembered for POST /admin/reset?seed=true
+func (s *Service) Seed(dataset *Dataset) error {
+	s.snapshots.Lock()
+	s.snapshots.seeds = append(s.snapshots.seeds, dataset)
+	s.snapshots.Unlock()
+
+	unlock := s.lock(writingAll()...)
+	defer unlock()
+
+	s.seed(dataset)
+	return s.persist(lockOrder[1:]...)
+}
+
+// LoadStorage populates the store from the configured storage file. It reports false when no storage path is
+// configured or the file does not exist yet
+func (s *Service) LoadStorage() (bool, error) {
+	if s.storage.path == "" {
+		return false, nil
+	}
+	dataset, err := LoadDataset(s.storage.path)
+	if os.IsNotExist(err) {
+		s.storage.Lock()
+		s.storage.loaded = true
+		s.storage.Unlock()
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	unlock := s.lock(writingAll()...)
+	defer unlock()
+	s.seed(dataset)
+	s.storage.Lock()
+	s.snapshot(lockOrder[1:]...)
+	s.storage.loaded = true
+	s.storage.Unlock()
+	log.Printf("loaded %d genres, %d customers, %d movies and %d rentals from %s",
+		len(dataset.Genres), len(dataset.Customers), len(dataset.Movies), len(dataset.Rentals), s.storage.path)
+	return true, nil
+}
+
+// seed adds the entities of dataset to the store. Does not lock access to the store, expects this to be done by
+// the calling method
+func (s *Service) seed(dataset *Dataset) {
+	for _, genre := range dataset.Genres {
+		if genre.ID == "" {
+			genre.ID = newObjectID()
+		}
+		s.genres[genre.ID] = genre
+	}
+	for _, customer := range dataset.Customers {
+		if customer.ID == "" {
+			customer.ID = newObjectID()
+		}
+		s.customers[customer.ID] = customer
+	}
+	for _, movie := range dataset.Movies {
+		if movie.ID == "" {
+			movie.ID = newObjectID()
+		}
+		genres := movie.Genres
+		if len(genres) == 0 {
+			genres = []Genre{movie.Genre}
+		}
+		movie.Genres = make([]Genre, len(genres))
+		for i, genre := range genres {
+			if stored, ok := s.genres[genre.ID]; ok {
+				genre = stored
+			}
+			movie.Genres[i] = genre
+		}
+		movie.Genre = movie.Genres[0]
+		s.movies[movie.ID] = movie
+	}
+	for _, rental := range dataset.Rentals {
+		if rental.ID == "" {
+			rental.ID = newObjectID()
+		}
+		s.rentals[rental.ID] = rental
+	}
+	for _, webhook := range dataset.Webhooks {
+		if webhook.ID == "" {
+			webhook.ID = newObjectID()
+		}
+		s.webhooks[webhook.ID] = webhook
+	}
+}
+
+// snapshot copies the named collections into the storage dataset, ordered by ID. Does not lock access to the store or
+// the storage, expects the calling method to hold the locks of the collections and the storage
+func (s *Service) snapshot(collections ...string) {
+	s.copyCollections(&s.storage.dataset, collections...)
+}
+
+// copyCollections copies the named collections into dataset, ordered by ID. Does not lock access to the store, expects
+// the calling method to hold the locks of the collections
+func (s *Service) copyCollections(dataset *Dataset, collections ...string) {
+	for _, collection := range collections {
+		switch collection {
+		case "genres":
+			dataset.Genres = make([]Genre, 0, len(s.genres))
+			for _, genre := range s.genres {
+				dataset.Genres = append(dataset.Genres, genre)
+			}
+			sort.Slice(dataset.Genres, func(i, j int) bool { return dataset.Genres[i].ID < dataset.Genres[j].ID })
+		case "customers":
+			dataset.Customers = make([]Customer, 0, len(s.customers))
+			for _, customer := range s.customers {
+				dataset.Customers = append(dataset.Customers, customer)
+			}
+			sort.Slice(dataset.Customers, func(i, j int) bool { return dataset.Customers[i].ID < dataset.Customers[j].ID })
+		case "movies":
+			dataset.Movies = make([]Movie, 0, len(s.movies))
+			for _, movie := range s.movies {
+				dataset.Movies = append(dataset.Movies, movie)
+			}
+			sort.Slice(dataset.Movies, func(i, j int) bool { return dataset.Movies[i].ID < dataset.Movies[j].ID })
+		case "rentals":
+			dataset.Rentals = make([]Rental, 0, len(s.rentals))
+			for _, rental := range s.rentals {
+				dataset.Rentals = append(dataset.Rentals, rental)
+			}
+			sort.Slice(dataset.Rentals, func(i, j int) bool { return dataset.Rentals[i].ID < dataset.Rentals[j].ID })
+		case "webhooks":
+			dataset.Webhooks = nil
+			for _, webhook := range s.webhooks {
+				dataset.Webhooks = append(dataset.Webhooks, webhook)
+			}
+			sort.Slice(dataset.Webhooks, func(i, j int) bool { return dataset.Webhooks[i].ID < dataset.Webhooks[j].ID })
+		}
+	}
+}
+
+// persist copies the named collections into the storage dataset and writes it to the configured storage file, if
+// any. The file is replaced atomically so that a crash mid-write never leaves a truncated file behind. Does not lock
+// access to the store, expects the calling method to hold the locks of the named collections
+func (s *Service) persist(collections ...string) error {
+	if s.storage.path == "" {
+		return nil
+	}
+	s.storage.Lock()
+	defer s.storage.Unlock()
+
+	s.snapshot(collections...)
+	data, err := json.MarshalIndent(s.storage.dataset, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(s.storage.path), filepath.Base(s.storage.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.storage.path)
+}
+
+// saved persists the store after a mutation of the named collections and logs, rather than fails the request, when
+// the write does not succeed. Does not lock access to the store, expects the calling method to hold the locks of the
+// named collections
+func (s *Service) saved(collections ...string) {
+	err := s.persist(collections...)
+	s.storage.Lock()
+	s.storage.err = err
+	s.storage.Unlock()
+	if err != nil {
+		log.Printf("error writing storage file %s - %s", s.storage.path, err)
+	}
+}
+
+// dateLayout is the format of the calendar dates in the store
+const dateLayout = "2006-01-02"
+
+// newObjectID returns a new 24 character hex ID in the same shape as the MongoDB ObjectIDs the real store uses
+func newObjectID() string {
+	id := make([]byte, 12)
+	binary.BigEndian.PutUint32(id, uint32(time.Now().Unix()))
+	_, err := rand.Read(id[4:])
+	if err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
+
+// decodeBody decodes the JSON request body into v, writing a 400 response and returning false when it cannot
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Body == nil {
+		http.Error(w, "Please send a request body", http.StatusBadRequest)
+		return false
+	}
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// writeJSON encodes v as the JSON response body
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus encodes v as the JSON body of a response with the given status
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Printf("error sending response - %s", err)
+	}
+}
+
+// etag returns the tag If-Match is compared with for the given version of an entity
+func etag(version int) string {
+	return fmt.Sprintf("\"%d\"", version)
+}
+
+// checkIfMatch compares the If-Match header of the request, when there is one, with the current version of an entity
+// quoted, as in "3". It writes a 412 response and returns false when they differ
+func checkIfMatch(w http.ResponseWriter, r *http.Request, kind string, version int) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" || ifMatch == "*" || ifMatch == etag(version) {
+		return true
+	}
+	http.Error(w, fmt.Sprintf("The %s was modified since it was last read.", kind), http.StatusPreconditionFailed)
+	return false
+}
+
+// validateLength returns an error message when value is not between min and max characters long
+func validateLength(field, value string, min, max int) string {
+	if len(value) < min {
+		return fmt.Sprintf("\"%s\" length must be at least %d characters long", field, min)
+	}
+	if len(value) > max {
+		return fmt.Sprintf("\"%s\" length must be less than or equal to %d characters long", field, max)
+	}
+	return ""
+}
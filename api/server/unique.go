@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// DefaultUniqueIndexes are the unique indexes of the store collections when no others are configured: genres by
+// name, customers by phone and movies by title
+var DefaultUniqueIndexes = map[string][]string{
+	"genres":    {"name"},
+	"customers": {"phone"},
+	"movies":    {"title"},
+}
+
+// Conflict is the body of a 409 response to a write that would break a unique index
+type Conflict struct {
+	Message string `json:"message"`
+	Field   string `json:"field"`
+	Value   string `json:"value"`
+	ID      string `json:"_id"`
+}
+
+// WithUniqueIndexes sets the unique indexes of the store collections, by collection name, as a list of the JSON
+// field names that must each be unique within the collection
+func WithUniqueIndexes(indexes map[string][]string) Option {
+	return func(s *Service) {
+		s.uniqueIndexes = indexes
+	}
+}
+
+// indexedFields returns the values of the fields of an entity that unique indexes can be declared on, by JSON field
+// name
+func indexedFields(entity interface{}) map[string]string {
+	switch e := entity.(type) {
+	case Genre:
+		return map[string]string{"name": e.Name}
+	case Customer:
+		return map[string]string{"name": e.Name, "phone": e.Phone, "email": e.Email}
+	case Movie:
+		return map[string]string{"title": e.Title}
+	}
+	return nil
+}
+
+// findConflict returns the entity of collection, other than the one with ID id, that has the same value as entity
+// for a uniquely indexed field. Does not lock access to the store, expects this to be done by the calling method
+func (s *Service) findConflict(collection, id string, entity interface{}) *Conflict {
+	fields := indexedFields(entity)
+	for _, field := range s.uniqueIndexes[collection] {
+		value, ok := fields[field]
+		if !ok || value == "" {
+			continue
+		}
+		for otherID, other := range s.collection(collection) {
+			if otherID != id && deletedAt(other) == nil && indexedFields(other)[field] == value {
+				return &Conflict{
+					Message: fmt.Sprintf("A %s with %s %q already exists.", singular(collection), field, value),
+					Field:   field,
+					Value:   value,
+					ID:      otherID,
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkUnique writes a 409 response and returns false when entity would break a unique index of collection. Does
+// not lock access to the store, expects this to be done by the calling method
+func (s *Service) checkUnique(w http.ResponseWriter, collection, id string, entity interface{}) bool {
+	conflict := s.findConflict(collection, id, entity)
+	if conflict == nil {
+		return true
+	}
+	writeJSONStatus(w, http.StatusConflict, conflict)
+	return false
+}
+
+// collection returns the entities of the named collection by ID. Does not lock access to the store, expects this to
+// be done by the calling method
+func (s *Service) collection(name string) map[string]interface{} {
+	entities := map[string]interface{}{}
+	switch name {
+	case "genres":
+		for id, genre := range s.genres {
+			entities[id] = genre
+		}
+	case "customers":
+		for id, customer := range s.customers {
+			entities[id] = customer
+		}
+	case "movies":
+		for id, movie := range s.movies {
+			entities[id] = movie
+		}
+	}
+	return entities
+}
+
+// singular returns the name of a single entity of the named collection
+func singular(collection string) string {
+	return collection[:len(collection)-1]
+}
@@ -0,0 +1,34 @@
+package server
+
+import "net/http"
+
+// FieldError describes why the value of one field of a request body cannot be stored. Field is the JSON name of the
+// field, with a nested field named by its path from the body, such as address.country
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors is the body of a 422 response to a request with fields that cannot be stored
+type ValidationErrors struct {
+	Errors []FieldError `json:"errors"`
+}
+
+// fieldErrors collects the FieldErrors of a request body
+type fieldErrors []FieldError
+
+// add records message against field, unless message is empty
+func (e *fieldErrors) add(field, message string) {
+	if message != "" {
+		*e = append(*e, FieldError{Field: field, Message: message})
+	}
+}
+
+// checkValid writes a 422 response listing errs and returns false when there are any
+func checkValid(w http.ResponseWriter, errs fieldErrors) bool {
+	if len(errs) == 0 {
+		return true
+	}
+	writeJSONStatus(w, http.StatusUnprocessableEntity, ValidationErrors{Errors: errs})
+	return false
+}
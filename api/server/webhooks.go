@@ -0,0 +1,358 @@
+package server
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// WebhookEvents are the events a webhook can subscribe to
+var WebhookEvents = []string{
+	"genre.created", "genre.updated", "genre.deleted", "genre.restored",
+	"customer.created", "customer.updated", "customer.deleted", "customer.restored",
+	"movie.created", "movie.updated", "movie.deleted", "movie.restored", "movie.stock_changed",
+	"rental.opened", "rental.closed",
+}
+
+// DefaultWebhookAttempts is how many times a webhook delivery is attempted before it is dead-lettered
+const DefaultWebhookAttempts = 5
+
+// DefaultWebhookBackoff is how long the first retry of a failed webhook delivery waits. Each later retry waits
+// twice as long as the one before
+const DefaultWebhookBackoff = time.Second
+
+// Webhook is a subscription to change notifications. Every delivery is signed with Secret, which is never returned
+// by the API
+type Webhook struct {
+	ID      string   `json:"_id"`
+	URL     string   `json:"url"`
+	Events  []string `json:"events"`
+	Secret  string   `json:"secret,omitempty"`
+	Version int      `json:"__v"`
+}
+
+// WebhookPayload is the JSON body POSTed to a webhook
+type WebhookPayload struct {
+	ID    string          `json:"_id"`
+	Event string          `json:"event"`
+	Time  time.Time       `json:"time"`
+	Data  json.RawMessage `json:"data"`
+}
+
+// DeadLetter is a webhook delivery that failed every attempt
+type DeadLetter struct {
+	WebhookID string         `json:"webhookId"`
+	URL       string         `json:"url"`
+	Payload   WebhookPayload `json:"payload"`
+	Attempts  int            `json:"attempts"`
+	LastError string         `json:"lastError"`
+	FailedAt  time.Time      `json:"failedAt"`
+}
+
+// webhookDeliveries holds the delivery settings of a Service, the deliveries in progress and the dead letters. stop
+// is closed when the Service gives up waiting for the deliveries in progress on shutdown
+type webhookDeliveries struct {
+	attempts    int
+	backoff     time.Duration
+	httpClient  *http.Client
+	inProgress  sync.WaitGroup
+	deadLetters []DeadLetter
+	stop        chan struct{}
+	stopping    sync.Once
+	sync.Mutex
+}
+
+// WithWebhookRetries sets how many times a webhook delivery is attempted before it is dead-lettered, and how long
+// the first retry waits
+func WithWebhookRetries(attempts int, backoff time.Duration) Option {
+	return func(s *Service) {
+		if attempts > 0 {
+			s.deliveries.attempts = attempts
+		}
+		if backoff > 0 {
+			s.deliveries.backoff = backoff
+		}
+	}
+}
+
+// SignWebhookPayload returns the value of the X-Store-Signature header of a delivery of body signed with secret
+func SignWebhookPayload(body []byte, secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notify delivers event, about entity, to every webhook subscribed to it. The deliveries are made in the
+// background. Locks the webhooks itself, so the calling method must not hold their lock
+func (s *Service) notify(event string, entity interface{}) {
+	data, err := json.Marshal(entity)
+	if err != nil {
+		log.Printf("error encoding %s notification - %s", event, err)
+		return
+	}
+	payload := WebhookPayload{
+		ID:    newObjectID(),
+		Event: event,
+		Time:  time.Now().UTC(),
+		Data:  data,
+	}
+	unlock := s.lock(reading("webhooks"))
+	defer unlock()
+	for _, webhook := range s.webhooks {
+		if !subscribed(webhook, event) {
+			continue
+		}
+		s.deliveries.inProgress.Add(1)
+		go func(webhook Webhook) {
+			defer s.deliveries.inProgress.Done()
+			s.deliver(webhook, payload)
+		}(webhook)
+	}
+}
+
+// subscribed reports whether webhook subscribed to event. A webhook without events subscribes to all of them
+func subscribed(webhook Webhook, event string) bool {
+	if len(webhook.Events) == 0 {
+		return true
+	}
+	for _, e := range webhook.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver POSTs payload to webhook until it answers with a 2xx status, backing off exponentially between attempts.
+// A delivery that fails every attempt, or is still failing when the Service stops waiting for it on shutdown, is
+// added to the dead letters
+func (s *Service) deliver(webhook Webhook, payload WebhookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("error encoding webhook payload %s - %s", payload.ID, err)
+		return
+	}
+
+	backoff := s.deliveries.backoff
+	var lastErr error
+	attempt := 1
+	for ; ; attempt++ {
+		lastErr = s.post(webhook, payload, body)
+		if lastErr == nil {
+			return
+		}
+		log.Printf("webhook %s delivery %s attempt %d failed - %s", webhook.ID, payload.ID, attempt, lastErr)
+		if attempt == s.deliveries.attempts || !s.backOff(backoff) {
+			break
+		}
+		backoff *= 2
+	}
+
+	s.deliveries.Lock()
+	defer s.deliveries.Unlock()
+	s.deliveries.deadLetters = append(s.deliveries.deadLetters, DeadLetter{
+		WebhookID: webhook.ID,
+		URL:       webhook.URL,
+		Payload:   payload,
+		Attempts:  attempt,
+		LastError: lastErr.Error(),
+		FailedAt:  time.Now().UTC(),
+	})
+}
+
+// backOff waits for d before the next delivery attempt, reporting false when the deliveries are stopped first
+func (s *Service) backOff(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-s.deliveries.stop:
+		return false
+	}
+}
+
+// post makes a single delivery attempt of body to webhook
+func (s *Service) post(webhook Webhook, payload WebhookPayload, body []byte) error {
+	req, err := http.NewRequest("POST", webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Store-Event", payload.Event)
+	req.Header.Set("X-Store-Delivery", payload.ID)
+	req.Header.Set("X-Store-Signature", SignWebhookPayload(body, webhook.Secret))
+
+	resp, err := s.deliveries.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("got a %d status code", resp.StatusCode)
+	}
+	return nil
+}
+
+// GetWebhooks returns all of the Webhooks that exist in the store, ordered by URL
+func (s *Service) GetWebhooks(w http.ResponseWriter, r *http.Request) {
+	unlock := s.lock(reading("webhooks"))
+	webhooks := make([]Webhook, 0, len(s.webhooks))
+	for _, webhook := range s.webhooks {
+		webhooks = append(webhooks, redactWebhook(webhook))
+	}
+	unlock()
+
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].URL < webhooks[j].URL })
+	writeJSON(w, webhooks)
+}
+
+// GetWebhook handles retrieving a Webhook with a specific ID
+func (s *Service) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(reading("webhooks"))
+	webhook, ok := s.webhooks[id]
+	unlock()
+
+	if !ok {
+		http.Error(w, "The webhook with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, redactWebhook(webhook))
+}
+
+// PostWebhook handles adding a new Webhook
+func (s *Service) PostWebhook(w http.ResponseWriter, r *http.Request) {
+	var webhook Webhook
+	if !decodeBody(w, r, &webhook) {
+		return
+	}
+	if !checkValid(w, validateWebhook(&webhook)) {
+		return
+	}
+
+	unlock := s.lock(writing("webhooks"))
+	defer unlock()
+
+	webhook.ID = newObjectID()
+	webhook.Version = 0
+	tracked := s.track(r, "webhooks", webhook.ID)
+	s.webhooks[webhook.ID] = webhook
+	tracked()
+	s.saved("webhooks")
+	log.Printf("added webhook: %s", webhook.ID)
+	writeJSON(w, redactWebhook(webhook))
+}
+
+// PutWebhook handles updating a Webhook with a specific ID. The secret is kept when the request does not set one. A
+// request with an If-Match header is rejected when the webhook has changed since that version
+func (s *Service) PutWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var webhook Webhook
+	if !decodeBody(w, r, &webhook) {
+		return
+	}
+
+	unlock := s.lock(writing("webhooks"))
+	defer unlock()
+
+	current, ok := s.webhooks[id]
+	if !ok {
+		http.Error(w, "The webhook with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+	if !checkIfMatch(w, r, "webhook", current.Version) {
+		return
+	}
+	if webhook.Secret == "" {
+		webhook.Secret = current.Secret
+	}
+	if !checkValid(w, validateWebhook(&webhook)) {
+		return
+	}
+
+	webhook.ID = id
+	webhook.Version = current.Version + 1
+	tracked := s.track(r, "webhooks", id)
+	s.webhooks[id] = webhook
+	tracked()
+	s.saved("webhooks")
+	log.Printf("updated webhook: %s", id)
+	writeJSON(w, redactWebhook(webhook))
+}
+
+// DeleteWebhook handles removing a Webhook with a specific ID, responding with the removed Webhook
+func (s *Service) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	unlock := s.lock(writing("webhooks"))
+	defer unlock()
+
+	webhook, ok := s.webhooks[id]
+	if !ok {
+		http.Error(w, "The webhook with the given ID was not found.", http.StatusNotFound)
+		return
+	}
+
+	tracked := s.track(r, "webhooks", id)
+	delete(s.webhooks, id)
+	tracked()
+	s.saved("webhooks")
+	log.Printf("deleted webhook: %s", id)
+	writeJSON(w, redactWebhook(webhook))
+}
+
+// GetDeadLetters returns the deliveries to a Webhook with a specific ID that failed every attempt, oldest first
+func (s *Service) GetDeadLetters(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	s.deliveries.Lock()
+	defer s.deliveries.Unlock()
+
+	deadLetters := []DeadLetter{}
+	for _, deadLetter := range s.deliveries.deadLetters {
+		if deadLetter.WebhookID == id {
+			deadLetters = append(deadLetters, deadLetter)
+		}
+	}
+	writeJSON(w, deadLetters)
+}
+
+// redactWebhook returns webhook without its secret
+func redactWebhook(webhook Webhook) Webhook {
+	webhook.Secret = ""
+	return webhook
+}
+
+// validateWebhook returns the reasons webhook cannot be stored, none when it is valid
+func validateWebhook(webhook *Webhook) fieldErrors {
+	var errs fieldErrors
+	u, err := url.Parse(webhook.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.add("url", "\"url\" must be an http or https URL")
+	}
+	errs.add("secret", validateLength("secret", webhook.Secret, 16, 255))
+	for _, event := range webhook.Events {
+		known := false
+		for _, e := range WebhookEvents {
+			known = known || e == event
+		}
+		if !known {
+			errs.add("events", fmt.Sprintf("\"events\" contains unknown event %q", event))
+		}
+	}
+	return errs
+}
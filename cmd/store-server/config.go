@@ -0,0 +1,73 @@
+package main
+
+import (
+	"io/ioutil"
+	"time"
+
+	"github.com/milamice62/terraplugin/api/server"
+	"gopkg.in/yaml.v2"
+)
+
+// Config holds the settings of the store server
+type Config struct {
+	// Listen is the host:port the server listens on
+	Listen string `yaml:"listen"`
+	// AuthSecret, when set, is the secret auth tokens must be signed with
+	AuthSecret string `yaml:"auth_secret"`
+	// Seed is a JSON fixtures file loaded into the store when there is no existing storage file
+	Seed string `yaml:"seed"`
+	// StoragePath is the JSON file the store is persisted to. The store is in-memory only when it is empty
+	StoragePath string `yaml:"storage_path"`
+	// AuditLog is the JSONL file every change to the store is recorded in. Audit events are kept in memory only when
+	// it is empty
+	AuditLog string `yaml:"audit_log"`
+	// ShutdownTimeout is how long in-flight requests are given to finish on SIGTERM
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// IdempotencyWindow is how long responses to requests with an Idempotency-Key are replayed for
+	IdempotencyWindow time.Duration `yaml:"idempotency_window"`
+	// UniqueIndexes lists, by collection, the fields that must each be unique within it. The server defaults are used
+	// when it is not set
+	UniqueIndexes map[string][]string `yaml:"unique_indexes"`
+	// PurgeAfter is how long soft-deleted genres, customers and movies are kept before they are removed for good. They
+	// are kept until they are hard deleted when it is zero
+	PurgeAfter time.Duration `yaml:"purge_after"`
+	// WebhookAttempts is how many times a webhook delivery is attempted before it is dead-lettered
+	WebhookAttempts int `yaml:"webhook_attempts"`
+	// WebhookBackoff is how long the first retry of a failed webhook delivery waits, doubling on each later retry
+	WebhookBackoff time.Duration `yaml:"webhook_backoff"`
+	// RateLimit is how many requests per second the server accepts before answering 429 Too Many Requests. There is
+	// no limit when it is zero
+	RateLimit float64 `yaml:"rate_limit"`
+	// RateLimitBurst is how many requests over the rate limit are accepted in a burst
+	RateLimitBurst int `yaml:"rate_limit_burst"`
+	// AccessLog is the file a JSON line is appended to for every request. The access log is written to stderr, along
+	// with the rest of the log, when it is empty
+	AccessLog string `yaml:"access_log"`
+	// CORSOrigins are the origins of the browser pages allowed to call the server, "*" allowing every origin. No
+	// cross-origin request is allowed when it is empty
+	CORSOrigins []string `yaml:"cors_origins"`
+	// Faults are the rules the server misbehaves by, to test how clients cope with a failing store. They can be
+	// replaced at runtime through /admin/faults
+	Faults []server.FaultRule `yaml:"faults"`
+}
+
+// defaultConfig returns the Config used for any setting not given in the config file or on the command line
+func defaultConfig() *Config {
+	return &Config{
+		Listen:            "localhost:3000",
+		ShutdownTimeout:   10 * time.Second,
+		IdempotencyWindow: server.DefaultIdempotencyWindow,
+		WebhookAttempts:   server.DefaultWebhookAttempts,
+		WebhookBackoff:    server.DefaultWebhookBackoff,
+		RateLimitBurst:    1,
+	}
+}
+
+// loadConfig reads the YAML config file at path over the values already in cfg
+func loadConfig(path string, cfg *Config) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return yaml.UnmarshalStrict(data, cfg)
+}
@@ -0,0 +1,144 @@
+// Command store-server runs the mock store API the provider talks to, for local development and acceptance tests.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/milamice62/terraplugin/api/server"
+)
+
+func main() {
+	cfg := defaultConfig()
+
+	configPath := flag.String("config", "", "a YAML file to read settings from; flags take precedence over it")
+	listen := flag.String("listen", cfg.Listen, "the host:port to listen on")
+	secret := flag.String("secret", "", "the secret auth tokens must be signed with; any token is accepted when empty")
+	seed := flag.String("seed", "", "a JSON fixtures file to seed the store with when there is no storage file yet")
+	storage := flag.String("storage", "", "a JSON file to persist the store to; the store is in-memory only when empty")
+	auditLog := flag.String("audit-log", "", "a JSONL file to record every change to the store in; audit events are in-memory only when empty")
+	shutdownTimeout := flag.Duration("shutdown-timeout", cfg.ShutdownTimeout, "how long in-flight requests get to finish on shutdown")
+	idempotencyWindow := flag.Duration("idempotency-window", cfg.IdempotencyWindow, "how long responses to requests with an Idempotency-Key are replayed for")
+	purgeAfter := flag.Duration("purge-after", cfg.PurgeAfter, "how long soft-deleted records are kept before they are removed for good; kept until hard deleted when 0")
+	webhookAttempts := flag.Int("webhook-attempts", cfg.WebhookAttempts, "how many times a webhook delivery is attempted before it is dead-lettered")
+	webhookBackoff := flag.Duration("webhook-backoff", cfg.WebhookBackoff, "how long the first retry of a failed webhook delivery waits, doubling on each later retry")
+	rateLimit := flag.Float64("rate-limit", cfg.RateLimit, "requests per second accepted before answering 429 Too Many Requests; no limit when 0")
+	rateLimitBurst := flag.Int("rate-limit-burst", cfg.RateLimitBurst, "how many requests over the rate limit are accepted in a burst")
+	accessLog := flag.String("access-log", "", "a file to append a JSON line to for every request; written to stderr when empty")
+	corsOrigins := flag.String("cors-origins", "", "a comma separated list of the origins allowed to call the server from a browser, * for any")
+	flag.Parse()
+
+	if *configPath != "" {
+		err := loadConfig(*configPath, cfg)
+		if err != nil {
+			log.Fatalf("error reading config file %s - %s", *configPath, err)
+		}
+	}
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "listen":
+			cfg.Listen = *listen
+		case "secret":
+			cfg.AuthSecret = *secret
+		case "seed":
+			cfg.Seed = *seed
+		case "storage":
+			cfg.StoragePath = *storage
+		case "audit-log":
+			cfg.AuditLog = *auditLog
+		case "shutdown-timeout":
+			cfg.ShutdownTimeout = *shutdownTimeout
+		case "idempotency-window":
+			cfg.IdempotencyWindow = *idempotencyWindow
+		case "purge-after":
+			cfg.PurgeAfter = *purgeAfter
+		case "webhook-attempts":
+			cfg.WebhookAttempts = *webhookAttempts
+		case "webhook-backoff":
+			cfg.WebhookBackoff = *webhookBackoff
+		case "rate-limit":
+			cfg.RateLimit = *rateLimit
+		case "rate-limit-burst":
+			cfg.RateLimitBurst = *rateLimitBurst
+		case "access-log":
+			cfg.AccessLog = *accessLog
+		case "cors-origins":
+			cfg.CORSOrigins = strings.Split(*corsOrigins, ",")
+		}
+	})
+
+	if err := server.ValidateFaultRules(cfg.Faults); err != nil {
+		log.Fatalf("error in fault rules - %s", err)
+	}
+
+	opts := []server.Option{
+		server.WithAuthSecret(cfg.AuthSecret),
+		server.WithStoragePath(cfg.StoragePath),
+		server.WithAuditLog(cfg.AuditLog),
+		server.WithIdempotencyWindow(cfg.IdempotencyWindow),
+		server.WithPurgeAfter(cfg.PurgeAfter),
+		server.WithWebhookRetries(cfg.WebhookAttempts, cfg.WebhookBackoff),
+		server.WithRateLimit(cfg.RateLimit, cfg.RateLimitBurst),
+		server.WithCORS(cfg.CORSOrigins...),
+		server.WithFaultRules(cfg.Faults...),
+	}
+	if cfg.AccessLog != "" {
+		f, err := os.OpenFile(cfg.AccessLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("error opening access log %s - %s", cfg.AccessLog, err)
+		}
+		defer f.Close()
+		opts = append(opts, server.WithAccessLog(f))
+	}
+	if cfg.UniqueIndexes != nil {
+		opts = append(opts, server.WithUniqueIndexes(cfg.UniqueIndexes))
+	}
+	service := server.NewService(cfg.Listen, nil, opts...)
+
+	loaded, err := service.LoadStorage()
+	if err != nil {
+		log.Fatalf("error reading storage file %s - %s", cfg.StoragePath, err)
+	}
+	err = service.LoadAuditLog()
+	if err != nil {
+		log.Fatalf("error reading audit log %s - %s", cfg.AuditLog, err)
+	}
+	if !loaded && cfg.Seed != "" {
+		dataset, err := server.LoadDataset(cfg.Seed)
+		if err != nil {
+			log.Fatalf("error reading seed file %s - %s", cfg.Seed, err)
+		}
+		err = service.Seed(dataset)
+		if err != nil {
+			log.Fatalf("error seeding store - %s", err)
+		}
+		log.Printf("seeded store from %s", cfg.Seed)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+		sig := <-signals
+		log.Printf("received %s, shutting down", sig)
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+		err := service.Shutdown(ctx)
+		if err != nil {
+			log.Printf("error shutting down - %s", err)
+		}
+	}()
+
+	err = service.ListenAndServe()
+	if err != nil {
+		log.Fatal(err)
+	}
+	<-done
+}
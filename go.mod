@@ -3,6 +3,10 @@ module github.com/milamice62/terraplugin
 go 1.14
 
 require (
+	github.com/gorilla/mux v1.6.2
+	github.com/hashicorp/go-uuid v1.0.1
 	github.com/hashicorp/terraform v0.12.26
 	github.com/spaceapegames/terraform-provider-example v0.0.0-20181120111032-a11993c5df8c
+	github.com/zclconf/go-cty v1.2.1
+	gopkg.in/yaml.v2 v2.2.2
 )
@@ -8,25 +8,47 @@ provider "store" {
 #   name = "comedy"
 # }
 
+# resource "store_genres" "slapstick" {
+#   name      = "slapstick"
+#   parent_id = store_genres.kind.id
+# }
+
 # resource "store_movies" "saw" {
-#   title = "sawIII"
-#   genre {
-#     _id = "5ee19f2a1363f7c0493761e9"
-#   }
-#   stock      = 10
-#   daily_rate = 12.10
+#   title           = "sawIII"
+#   genre_ids       = ["5ee19f2a1363f7c0493761e9", "5ee05b02340e2cae12c1bea5"]
+#   stock           = 10
+#   daily_rate      = 12.10
+#   release_year    = 2006
+#   rating          = "R"
+#   runtime_minutes = 108
 # }
 
 # resource "store_customers" "customer1" {
-#   name  = "foobar"
-#   phone = "123456789"
+#   name         = "Jane Doe"
+#   phone        = "123456789"
+#   email        = "jane@example.com"
+#   member_since = "2019-05-01"
+#
+#   address {
+#     street      = "742 Evergreen Terrace"
+#     city        = "Springfield"
+#     postal_code = "49007"
+#     country     = "US"
+#   }
 # }
 
 # resource "store_rentals" "myrental" {
-#   customer {
-#     id = "5ee998a7073cfb0d8696fec1"
-#   }
-#   movie {
-#     id = "5ee6fe17de7e8d5eb0ae60ea"
-#   }
+#   customer_id = "5ee998a7073cfb0d8696fec1"
+#   movie_id    = "5ee6fe17de7e8d5eb0ae60ea"
+# }
+
+# resource "store_rental_batch" "promotion" {
+#   customer_id = "5ee998a7073cfb0d8696fec1"
+#   movie_ids   = ["5ee6fe17de7e8d5eb0ae60ea", "5ef199b9edf86a20de80b4a2"]
+# }
+
+# resource "store_webhook" "stock" {
+#   url    = "http://localhost:8080/hooks/stock"
+#   events = ["movie.stock_changed", "rental.opened", "rental.closed"]
+#   secret = "change-me-to-a-long-secret"
 # }
@@ -0,0 +1,94 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/milamice62/terraplugin/api/client"
+)
+
+// AuditEventsData reads the audit events the store recorded for changes to it, optionally narrowed down to one
+// entity, collection or token subject
+func AuditEventsData() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"entity_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Only return the events for the genre, customer, movie or rental with this ID",
+			},
+			"collection": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Only return the events for this collection: genres, customers, movies or rentals",
+				ValidateFunc: validation.StringInSlice([]string{"genres", "customers", "movies", "rentals"}, false),
+			},
+			"subject": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Only return the events for requests made with a token issued to this subject",
+			},
+			"events": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "The matching audit events, oldest first",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id":         {Type: schema.TypeString, Computed: true},
+						"time":       {Type: schema.TypeString, Computed: true},
+						"subject":    {Type: schema.TypeString, Computed: true},
+						"token":      {Type: schema.TypeString, Computed: true, Description: "A fingerprint of the auth token the request was made with"},
+						"method":     {Type: schema.TypeString, Computed: true},
+						"route":      {Type: schema.TypeString, Computed: true},
+						"collection": {Type: schema.TypeString, Computed: true},
+						"entity_id":  {Type: schema.TypeString, Computed: true},
+						"status":     {Type: schema.TypeInt, Computed: true},
+						"before":     {Type: schema.TypeString, Computed: true, Description: "The JSON of the entity before the request, empty when it did not exist"},
+						"after":      {Type: schema.TypeString, Computed: true, Description: "The JSON of the entity after the request, empty when it no longer exists"},
+					},
+				},
+			},
+		},
+		Read: readAuditEvents,
+	}
+}
+
+func readAuditEvents(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	filter := client.AuditFilter{
+		EntityID:   d.Get("entity_id").(string),
+		Collection: d.Get("collection").(string),
+		Subject:    d.Get("subject").(string),
+	}
+	events, err := apiClient.GetAuditEvents(filter)
+	if err != nil {
+		return fmt.Errorf("error reading audit events: %s", err)
+	}
+
+	items := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		items = append(items, map[string]interface{}{
+			"id":         event.ID,
+			"time":       event.Time,
+			"subject":    event.Subject,
+			"token":      event.Token,
+			"method":     event.Method,
+			"route":      event.Route,
+			"collection": event.Collection,
+			"entity_id":  event.EntityID,
+			"status":     event.Status,
+			"before":     string(event.Before),
+			"after":      string(event.After),
+		})
+	}
+	if err := d.Set("events", items); err != nil {
+		return err
+	}
+
+	d.SetId(strconv.Itoa(hashcode.String(fmt.Sprintf("%s/%s/%s", filter.EntityID, filter.Collection, filter.Subject))))
+	return nil
+}
@@ -0,0 +1,70 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/milamice62/terraplugin/api/client"
+)
+
+func Test_AuditEvents_Genre(t *testing.T) {
+	testAccTest(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t) },
+		Providers: testAccProviders,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccCheckAuditEventsGenre(),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("data.store_audit_events.kind", "events.#", "1"),
+					resource.TestCheckResourceAttr("data.store_audit_events.kind", "events.0.method", "POST"),
+					resource.TestCheckResourceAttrPair("data.store_audit_events.kind", "events.0.entity_id", "store_genres.kind", "id"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckAuditEventsGenre() string {
+	return fmt.Sprintf(`
+	resource "store_genres" "kind" {
+		name = "%saudited"
+	}
+
+	data "store_audit_events" "kind" {
+		entity_id = store_genres.kind.id
+	}
+	`, testAccPrefix)
+}
+
+func TestReadAuditEvents(t *testing.T) {
+	meta := testStoreMeta(t, nil)
+	genre := client.Genre{Name: "comedy"}
+	if _, err := meta.client.NewGenre(&genre); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := meta.client.NewCustomer(&client.Customer{Name: "foobar", Phone: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := schema.TestResourceDataRaw(t, AuditEventsData().Schema, map[string]interface{}{
+		"collection": "genres",
+	})
+	if err := readAuditEvents(d, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := d.Get("events.#").(int); got != 1 {
+		t.Fatalf("expected 1 genre audit event, got %d", got)
+	}
+	if got := d.Get("events.0.route").(string); got != "/api/genres" {
+		t.Errorf("expected the event for POST /api/genres, got %s", got)
+	}
+	if got := d.Get("events.0.after").(string); got == "" {
+		t.Error("expected the event to record the created genre")
+	}
+	if d.Id() == "" {
+		t.Error("expected the data source to have an ID")
+	}
+}
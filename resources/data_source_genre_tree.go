@@ -0,0 +1,115 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/milamice62/terraplugin/api/client"
+)
+
+// GenreTreeData reads the genre hierarchy of the store, flattened depth first so that every genre is followed by its
+// subgenres
+func GenreTreeData() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"root_id": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Only return the genre with this ID and its subgenres",
+			},
+			"genres": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "The genres depth first, with the subgenres of each genre ordered by name",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id":        {Type: schema.TypeString, Computed: true},
+						"name":      {Type: schema.TypeString, Computed: true},
+						"parent_id": {Type: schema.TypeString, Computed: true},
+						"path":      {Type: schema.TypeString, Computed: true},
+						"depth":     {Type: schema.TypeInt, Computed: true, Description: "How many ancestors the genre has"},
+						"child_ids": {
+							Type:        schema.TypeList,
+							Computed:    true,
+							Description: "The IDs of the subgenres of the genre, ordered by name",
+							Elem:        &schema.Schema{Type: schema.TypeString},
+						},
+					},
+				},
+			},
+		},
+		Read: readGenreTree,
+	}
+}
+
+func readGenreTree(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	genres, err := apiClient.GetAllGenres()
+	if err != nil {
+		return fmt.Errorf("error reading genres: %s", err)
+	}
+
+	byID := map[string]client.Genre{}
+	for _, genre := range genres {
+		byID[genre.ID] = genre
+	}
+	children := map[string][]client.Genre{}
+	for _, genre := range genres {
+		parentID := genre.ParentID
+		// A genre whose parent cannot be read is shown as a root
+		if _, ok := byID[parentID]; !ok {
+			parentID = ""
+		}
+		children[parentID] = append(children[parentID], genre)
+	}
+	for _, siblings := range children {
+		sort.Slice(siblings, func(i, j int) bool { return siblings[i].Name < siblings[j].Name })
+	}
+
+	roots := children[""]
+	rootDepth := 0
+	rootID := d.Get("root_id").(string)
+	if rootID != "" {
+		root, ok := byID[rootID]
+		if !ok {
+			return fmt.Errorf("root_id: genre %q does not exist", rootID)
+		}
+		roots = []client.Genre{root}
+		for parent, ok := byID[root.ParentID]; ok && rootDepth < len(genres); parent, ok = byID[parent.ParentID] {
+			rootDepth++
+		}
+	}
+
+	items := []interface{}{}
+	var walk func(genre client.Genre, depth int)
+	walk = func(genre client.Genre, depth int) {
+		childIDs := make([]interface{}, 0, len(children[genre.ID]))
+		for _, child := range children[genre.ID] {
+			childIDs = append(childIDs, child.ID)
+		}
+		items = append(items, map[string]interface{}{
+			"id":        genre.ID,
+			"name":      genre.Name,
+			"parent_id": genre.ParentID,
+			"path":      genre.Path,
+			"depth":     depth,
+			"child_ids": childIDs,
+		})
+		for _, child := range children[genre.ID] {
+			walk(child, depth+1)
+		}
+	}
+	for _, root := range roots {
+		walk(root, rootDepth)
+	}
+	if err := d.Set("genres", items); err != nil {
+		return err
+	}
+
+	d.SetId(strconv.Itoa(hashcode.String(rootID)))
+	return nil
+}
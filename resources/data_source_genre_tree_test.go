@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/milamice62/terraplugin/api/server"
+)
+
+func Test_GenreTree(t *testing.T) {
+	testAccTest(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckGenreDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccCheckGenreTree(),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("store_genres.slasher", "path", testAccPrefix+"horror > "+testAccPrefix+"slasher"),
+					resource.TestCheckResourceAttr("data.store_genre_tree.horror", "genres.#", "2"),
+					resource.TestCheckResourceAttrPair("data.store_genre_tree.horror", "genres.1.id", "store_genres.slasher", "id"),
+					resource.TestCheckResourceAttr("data.store_genre_tree.horror", "genres.1.depth", "1"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckGenreTree() string {
+	return fmt.Sprintf(`
+	resource "store_genres" "horror" {
+		name = "%[1]shorror"
+	}
+
+	resource "store_genres" "slasher" {
+		name      = "%[1]sslasher"
+		parent_id = store_genres.horror.id
+	}
+
+	data "store_genre_tree" "horror" {
+		root_id = store_genres.slasher.parent_id
+	}
+	`, testAccPrefix)
+}
+
+func TestReadGenreTree(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres: []server.Genre{
+			{ID: "5ee05b02340e2cae12c1bea5", Name: "horror"},
+			{ID: "5ee19f2a1363f7c0493761e9", Name: "slasher", ParentID: "5ee05b02340e2cae12c1bea5"},
+			{ID: "5ee6fe17de7e8d5eb0ae60ea", Name: "giallo", ParentID: "5ee05b02340e2cae12c1bea5"},
+			{ID: "5ee998a7073cfb0d8696fec1", Name: "comedy"},
+		},
+	})
+
+	d := schema.TestResourceDataRaw(t, GenreTreeData().Schema, map[string]interface{}{})
+	if err := readGenreTree(d, meta); err != nil {
+		t.Fatal(err)
+	}
+
+	var order []string
+	for i := 0; i < d.Get("genres.#").(int); i++ {
+		order = append(order, fmt.Sprintf("%s@%d", d.Get(fmt.Sprintf("genres.%d.name", i)), d.Get(fmt.Sprintf("genres.%d.depth", i))))
+	}
+	if fmt.Sprint(order) != "[comedy@0 horror@0 giallo@1 slasher@1]" {
+		t.Fatalf("expected the genres depth first with siblings by name, got %v", order)
+	}
+	if got := d.Get("genres.1.child_ids").([]interface{}); len(got) != 2 || got[0] != "5ee6fe17de7e8d5eb0ae60ea" {
+		t.Errorf("expected horror to list its subgenres by name, got %v", got)
+	}
+	if got := d.Get("genres.3.path").(string); got != "horror > slasher" {
+		t.Errorf("expected the path of slasher, got %q", got)
+	}
+
+	d = schema.TestResourceDataRaw(t, GenreTreeData().Schema, map[string]interface{}{
+		"root_id": "5ee19f2a1363f7c0493761e9",
+	})
+	if err := readGenreTree(d, meta); err != nil {
+		t.Fatal(err)
+	}
+	if d.Get("genres.#").(int) != 1 || d.Get("genres.0.depth").(int) != 1 {
+		t.Errorf("expected only slasher at its own depth, got %v", d.Get("genres"))
+	}
+}
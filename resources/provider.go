@@ -1,7 +1,11 @@
 package provider
 
 import (
+	"math"
+	"time"
+
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 	"github.com/hashicorp/terraform/terraform"
 	"github.com/milamice62/terraplugin/api/client"
 )
@@ -24,21 +28,102 @@ func Provider() terraform.ResourceProvider {
 				Required:    true,
 				DefaultFunc: schema.EnvDefaultFunc("SERVICE_TOKEN", ""),
 			},
+			"skip_reference_validation": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("SERVICE_SKIP_REFERENCE_VALIDATION", false),
+				Description: "Skip the plan-time checks that referenced genres, customers and movies exist, so that plans can be made without reaching the store",
+			},
+			"soft_delete": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("SERVICE_SOFT_DELETE", false),
+				Description: "Destroy genres, customers and movies with a soft delete, so that they can be restored on the store until it purges them",
+			},
+			"requests_per_second": {
+				Type:         schema.TypeFloat,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("SERVICE_REQUESTS_PER_SECOND", 0),
+				ValidateFunc: validation.FloatBetween(0, math.MaxFloat64),
+				Description:  "The most requests per second sent to the store, shared by all resources. There is no limit when it is 0",
+			},
+			"max_concurrent_requests": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("SERVICE_MAX_CONCURRENT_REQUESTS", 0),
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "The most requests in flight to the store at once, shared by all resources. There is no limit when it is 0",
+			},
+			"prefetch": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("SERVICE_PREFETCH", false),
+				Description: "Read each collection once per run with its list endpoint and serve the reads of single genres, customers, movies and rentals from it, rather than making a request for each",
+			},
+			"conditional_reads": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("SERVICE_CONDITIONAL_READS", true),
+				Description: "Remember the ETag of everything read during a run and send it back with the next read of the same entity, so that the store answers 304 Not Modified instead of the full body when it has not changed",
+			},
+			"batch_window_ms": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("SERVICE_BATCH_WINDOW_MS", 0),
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "How many milliseconds to collect concurrent creates, updates and deletes of genres, customers and movies for, to send them to the store in bulk requests. Each is sent on its own when it is 0",
+			},
+			"max_batch_size": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("SERVICE_MAX_BATCH_SIZE", 100),
+				ValidateFunc: validation.IntBetween(1, 1000),
+				Description:  "The most items sent to the store in one bulk request",
+			},
+			"request_timeout_ms": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				DefaultFunc:  schema.EnvDefaultFunc("SERVICE_REQUEST_TIMEOUT_MS", 0),
+				ValidateFunc: validation.IntAtLeast(0),
+				Description:  "How many milliseconds to wait for the store to answer a request before failing it. Requests wait for as long as it takes when it is 0",
+			},
 		},
 		ResourcesMap: map[string]*schema.Resource{
-			"store_genres":    GenreItem(),
-			"store_movies":    MovieItem(),
-			"store_customers": CustomerItem(),
-			"store_rentals":   RentalItem(),
+			"store_genres":       GenreItem(),
+			"store_movies":       MovieItem(),
+			"store_customers":    CustomerItem(),
+			"store_rentals":      RentalItem(),
+			"store_rental_batch": RentalBatchItem(),
+			"store_webhook":      WebhookItem(),
+		},
+		DataSourcesMap: map[string]*schema.Resource{
+			"store_audit_events": AuditEventsData(),
+			"store_genre_tree":   GenreTreeData(),
 		},
 		ConfigureFunc: providerConfigure,
 	}
 }
 
+// providerMeta is the configured provider handed to every resource
+type providerMeta struct {
+	client                  *client.Client
+	skipReferenceValidation bool
+}
+
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 	address := d.Get("address").(string)
 	port := d.Get("port").(int)
 	token := d.Get("token").(string)
-	return client.NewClient(address, port, token), nil
-
+	return &providerMeta{
+		client: client.NewClient(address, port, token,
+			client.WithRateLimit(d.Get("requests_per_second").(float64)),
+			client.WithMaxConcurrentRequests(d.Get("max_concurrent_requests").(int)),
+			client.WithSoftDelete(d.Get("soft_delete").(bool)),
+			client.WithPrefetch(d.Get("prefetch").(bool)),
+			client.WithConditionalReads(d.Get("conditional_reads").(bool)),
+			client.WithBatching(time.Duration(d.Get("batch_window_ms").(int))*time.Millisecond, d.Get("max_batch_size").(int)),
+			client.WithTimeout(time.Duration(d.Get("request_timeout_ms").(int))*time.Millisecond),
+		),
+		skipReferenceValidation: d.Get("skip_reference_validation").(bool),
+	}, nil
 }
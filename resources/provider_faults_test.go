@@ -0,0 +1,113 @@
+package provider
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/milamice62/terraplugin/api/client"
+	"github.com/milamice62/terraplugin/api/server"
+)
+
+// testFaultMeta returns a provider meta, with a client configured with opts, talking to an in-process store seeded
+// with dataset that misbehaves as rules say
+func testFaultMeta(t *testing.T, dataset *server.Dataset, rules []server.FaultRule, opts ...client.Option) *providerMeta {
+	return testMetaFor(t, testStoreService(t, dataset, server.WithFaultRules(rules...)).Handler(), opts...)
+}
+
+func TestFaults_ServerError(t *testing.T) {
+	meta := testFaultMeta(t, nil, []server.FaultRule{
+		{Route: "/api/genres", Method: "POST", Action: server.FaultStatus, Status: http.StatusInternalServerError},
+	})
+
+	d := schema.TestResourceDataRaw(t, GenreItem().Schema, map[string]interface{}{"name": "comedy"})
+	err := createGenre(d, meta)
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("expected the 500 to be reported, got %v", err)
+	}
+	if d.Id() != "" {
+		t.Errorf("expected no genre to be recorded in state, got %s", d.Id())
+	}
+}
+
+func TestFaults_ConnectionReset(t *testing.T) {
+	meta := testFaultMeta(t, &server.Dataset{
+		Genres: []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "hhhhh"}},
+	}, []server.FaultRule{
+		{Route: "/api/movies", Method: "POST", Times: 1, Action: server.FaultReset},
+	})
+
+	d := schema.TestResourceDataRaw(t, MovieItem().Schema, map[string]interface{}{
+		"title":      "example",
+		"genre_ids":  []interface{}{"5ee19f2a1363f7c0493761e9"},
+		"stock":      100,
+		"daily_rate": 10.0,
+	})
+	if err := createMovie(d, meta); err != nil {
+		t.Fatalf("expected the create to be retried after the reset, got %s", err)
+	}
+	movies, err := meta.client.GetAllMovies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 1 || movies[0].MovieID != d.Id() {
+		t.Errorf("expected exactly the movie in state to be created, got %+v", movies)
+	}
+}
+
+func TestFaults_SlowResponse(t *testing.T) {
+	dataset := &server.Dataset{Customers: []server.Customer{{ID: "5ee05f73d2efa2ae8580f6cd", Name: "Jane Doe", Phone: "12345"}}}
+
+	for name, c := range map[string]struct {
+		delay   int
+		timeout time.Duration
+		err     bool
+	}{
+		"slow":     {delay: 50, timeout: time.Second},
+		"time out": {delay: 500, timeout: 100 * time.Millisecond, err: true},
+	} {
+		meta := testFaultMeta(t, dataset, []server.FaultRule{
+			{Route: "/api/customers/*", Method: "GET", Action: server.FaultDelay, DelayMs: c.delay},
+		}, client.WithTimeout(c.timeout))
+
+		d := schema.TestResourceDataRaw(t, CustomerItem().Schema, map[string]interface{}{"name": "Jane Doe", "phone": "12345"})
+		d.SetId("5ee05f73d2efa2ae8580f6cd")
+		err := readCustomer(d, meta)
+		if c.err && (err == nil || !strings.Contains(err.Error(), "Client.Timeout")) {
+			t.Errorf("%s: expected the read to time out, got %v", name, err)
+		}
+		if !c.err && err != nil {
+			t.Errorf("%s: expected the read to wait for the store, got %s", name, err)
+		}
+		if d.Id() != "5ee05f73d2efa2ae8580f6cd" {
+			t.Errorf("%s: expected the customer to stay in state, got %q", name, d.Id())
+		}
+	}
+}
+
+func TestFaults_TruncatedBody(t *testing.T) {
+	meta := testFaultMeta(t, &server.Dataset{
+		Genres: []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "horror"}},
+	}, []server.FaultRule{
+		{Route: "/api/genres/*", Method: "GET", Times: 1, Action: server.FaultBadBody},
+	}, client.WithConditionalReads(true))
+
+	d := schema.TestResourceDataRaw(t, GenreItem().Schema, map[string]interface{}{"name": "horror"})
+	d.SetId("5ee19f2a1363f7c0493761e9")
+	if err := readGenre(d, meta); err == nil || !strings.Contains(err.Error(), "unexpected EOF") {
+		t.Fatalf("expected the truncated body to be reported, got %v", err)
+	}
+	if d.Id() != "5ee19f2a1363f7c0493761e9" {
+		t.Fatalf("expected the genre to stay in state, got %q", d.Id())
+	}
+
+	// The truncated body must not be remembered for the ETag it came with
+	if err := readGenre(d, meta); err != nil {
+		t.Fatalf("expected the next read to succeed, got %s", err)
+	}
+	if d.Get("name") != "horror" {
+		t.Errorf("expected the genre to be read, got %q", d.Get("name"))
+	}
+}
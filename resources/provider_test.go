@@ -1,11 +1,24 @@
 package provider
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/hashicorp/terraform/helper/resource"
 	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
+	"github.com/milamice62/terraplugin/api/client"
+	"github.com/milamice62/terraplugin/api/server"
+	ctyjson "github.com/zclconf/go-cty/cty/json"
 )
 
 var testAccProviders map[string]terraform.ResourceProvider
@@ -18,6 +31,15 @@ func init() {
 	}
 }
 
+// testAccPrefix starts the name of every genre, customer and movie the acceptance tests create, so that the sweepers
+// can tell them apart from the rest of the store
+const testAccPrefix = "tf-acc-"
+
+// TestMain runs the sweepers, rather than the tests, when -sweep is given
+func TestMain(m *testing.M) {
+	resource.TestMain(m)
+}
+
 func TestProvider(t *testing.T) {
 	if err := Provider().(*schema.Provider).InternalValidate(); err != nil {
 		t.Fatalf("err: %s", err)
@@ -35,3 +57,160 @@ func testAccPreCheck(t *testing.T) {
 		t.Fatal("SERVICE_TOKEN must be set for acceptance tests")
 	}
 }
+
+// testAccClient returns a client for the store the acceptance tests run against, configured from the same
+// environment variables as the provider
+func testAccClient(t *testing.T) *client.Client {
+	testAccPreCheck(t)
+	apiClient, err := testEnvClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return apiClient
+}
+
+// testEnvClient returns a client for the store configured by the same environment variables as the provider, for
+// the acceptance tests and the sweepers
+func testEnvClient() (*client.Client, error) {
+	address, port, token := os.Getenv("SERVICE_ADDRESS"), os.Getenv("SERVICE_PORT"), os.Getenv("SERVICE_TOKEN")
+	if address == "" || port == "" || token == "" {
+		return nil, errors.New("SERVICE_ADDRESS, SERVICE_PORT and SERVICE_TOKEN must be set to reach the store")
+	}
+	portNumber, err := strconv.Atoi(port)
+	if err != nil {
+		return nil, fmt.Errorf("SERVICE_PORT must be a number: %s", err)
+	}
+	return client.NewClient(address, portNumber, token), nil
+}
+
+// sweepErrors returns an error listing the entities of kind a sweeper failed to delete, or nil when there are none
+func sweepErrors(kind string, errs []string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("error sweeping %d %s: %s", len(errs), kind, strings.Join(errs, "; "))
+}
+
+// testAccTest runs c with resource.Test between taking a snapshot of the store the acceptance tests run against and
+// restoring it, so that whatever the test leaves behind, even when it fails, does not leak into the next test
+func testAccTest(t *testing.T, c resource.TestCase) {
+	t.Helper()
+	if os.Getenv(resource.TestEnvVar) == "" {
+		// resource.Test skips the test without touching the store
+		resource.Test(t, c)
+		return
+	}
+	apiClient := testAccClient(t)
+	snapshot, err := apiClient.CreateSnapshot("")
+	if err != nil {
+		t.Fatalf("error taking a snapshot of the store: %s", err)
+	}
+	defer func() {
+		if err := apiClient.RestoreSnapshot(snapshot.Name); err != nil {
+			t.Errorf("error restoring snapshot %s of the store: %s", snapshot.Name, err)
+			return
+		}
+		if err := apiClient.DeleteSnapshot(snapshot.Name); err != nil {
+			t.Errorf("error deleting snapshot %s of the store: %s", snapshot.Name, err)
+		}
+	}()
+	resource.Test(t, c)
+}
+
+// testV0StateAttributes returns the attributes of the first instance of resourceType recorded in the version 0
+// state file under testdata
+func testV0StateAttributes(t *testing.T, resourceType string) map[string]interface{} {
+	data, err := ioutil.ReadFile("testdata/v0.tfstate")
+	if err != nil {
+		t.Fatalf("error reading recorded state: %s", err)
+	}
+	var state struct {
+		Resources []struct {
+			Type      string `json:"type"`
+			Instances []struct {
+				SchemaVersion int                    `json:"schema_version"`
+				Attributes    map[string]interface{} `json:"attributes"`
+			} `json:"instances"`
+		} `json:"resources"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatalf("error decoding recorded state: %s", err)
+	}
+	for _, rs := range state.Resources {
+		if rs.Type == resourceType && len(rs.Instances) > 0 && rs.Instances[0].SchemaVersion == 0 {
+			return rs.Instances[0].Attributes
+		}
+	}
+	t.Fatalf("no version 0 %s instance in recorded state", resourceType)
+	return nil
+}
+
+// testUpgradeState runs the state upgraders of r from version 0 over rawState, the way Terraform does, and checks
+// that the result decodes with the current schema of r
+func testUpgradeState(t *testing.T, r *schema.Resource, rawState map[string]interface{}) map[string]interface{} {
+	version := 0
+	for _, upgrader := range r.StateUpgraders {
+		if upgrader.Version != version {
+			continue
+		}
+		var err error
+		rawState, err = upgrader.Upgrade(rawState, nil)
+		if err != nil {
+			t.Fatalf("error upgrading state from version %d: %s", version, err)
+		}
+		version++
+	}
+	if version != r.SchemaVersion {
+		t.Fatalf("expected state to be upgraded to version %d, got %d", r.SchemaVersion, version)
+	}
+
+	data, err := json.Marshal(rawState)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ctyjson.Unmarshal(data, r.CoreConfigSchema().ImpliedType()); err != nil {
+		t.Fatalf("upgraded state does not match the current schema: %s", err)
+	}
+	return rawState
+}
+
+// testStoreMeta starts an in-process store server seeded with dataset and returns a provider meta configured to
+// talk to it. The server is closed when the test finishes
+func testStoreMeta(t *testing.T, dataset *server.Dataset, opts ...server.Option) *providerMeta {
+	return testMetaFor(t, testStoreService(t, dataset, opts...).Handler())
+}
+
+// testStoreService returns a store service seeded with dataset
+func testStoreService(t *testing.T, dataset *server.Dataset, opts ...server.Option) *server.Service {
+	service := server.NewService("", nil, opts...)
+	if dataset != nil {
+		if err := service.Seed(dataset); err != nil {
+			t.Fatalf("error seeding store: %s", err)
+		}
+	}
+	return service
+}
+
+// testMetaFor serves handler on a local port and returns a provider meta whose client, configured with opts, talks
+// to it. The server is closed when the test finishes
+func testMetaFor(t *testing.T, handler http.Handler, opts ...client.Option) *providerMeta {
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	host, portStr, err := net.SplitHostPort(ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &providerMeta{
+		client: client.NewClient("http://"+host, port, "token", opts...),
+	}
+}
+
+// testPlan runs the plan-time diff of r for a new resource with the given configuration
+func testPlan(r *schema.Resource, config map[string]interface{}, meta *providerMeta) (*terraform.InstanceDiff, error) {
+	return r.Diff(nil, terraform.NewResourceConfigRaw(config), meta)
+}
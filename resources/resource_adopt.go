@@ -0,0 +1,59 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/milamice62/terraplugin/api/client"
+)
+
+// adoptExistingSchema is the adopt_existing argument of resources that can take an existing record under management
+// on create, matching it by naturalKey
+func adoptExistingSchema(naturalKey string) *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		Default:     false,
+		Description: fmt.Sprintf("On create, take an existing record with the same %s under management instead of creating a new one", naturalKey),
+	}
+}
+
+// adoptedIDSchema is the adopted_id attribute of resources that can take an existing record under management. It is
+// the ID of the record adopted on create, and empty when the resource created its own record
+func adoptedIDSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeString,
+		Computed:    true,
+		Description: "The ID of the existing record taken under management on create, empty when a new record was created",
+	}
+}
+
+// adoptMatch returns the ID of the single record in ids, which matched the natural key of a resource being created,
+// and logs that it is being adopted. No ID is returned when nothing matched, and an error when the match is ambiguous
+func adoptMatch(kind, key, value string, ids []string) (string, error) {
+	switch len(ids) {
+	case 0:
+		log.Printf("[DEBUG] no existing %s with %s %q to adopt, creating one", kind, key, value)
+		return "", nil
+	case 1:
+		log.Printf("[WARN] adopted existing %s %s with %s %q instead of creating a new one", kind, ids[0], key, value)
+		return ids[0], nil
+	default:
+		return "", fmt.Errorf("cannot adopt an existing %s: %d records have %s %q (%s), import the intended one instead",
+			kind, len(ids), key, value, strings.Join(ids, ", "))
+	}
+}
+
+// conflictError explains a conflict on a unique field reported by the store in terms of how to take the existing
+// record under management. Any other error is returned unchanged
+func conflictError(err error, kind, resourceType string) error {
+	var conflict *client.ConflictError
+	if !errors.As(err, &conflict) {
+		return err
+	}
+	return fmt.Errorf("%s with %s %q already exists as %s; import it with `terraform import %s.<name> %s` or set adopt_existing = true",
+		kind, conflict.Field, conflict.Value, conflict.ID, resourceType, conflict.ID)
+}
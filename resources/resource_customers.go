@@ -2,36 +2,122 @@ package provider
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 	"github.com/milamice62/terraplugin/api/client"
 )
 
+// customerAttributes maps the fields of a customer in the API to the attributes of store_customers
+var customerAttributes = map[string]string{
+	"name":               "name",
+	"isGold":             "is_gold",
+	"phone":              "phone",
+	"email":              "email",
+	"address.street":     "address.0.street",
+	"address.city":       "address.0.city",
+	"address.region":     "address.0.region",
+	"address.postalCode": "address.0.postal_code",
+	"address.country":    "address.0.country",
+	"memberSince":        "member_since",
+	"notes":              "notes",
+}
+
 func CustomerItem() *schema.Resource {
-	fmt.Print()
 	return &schema.Resource{
 		Schema: map[string]*schema.Schema{
 			"name": {
 				Type:         schema.TypeString,
 				Required:     true,
-				Description:  "The name of the customer",
-				ForceNew:     true,
-				ValidateFunc: validateName,
+				Description:  "The full name of the customer",
+				ValidateFunc: validateCustomerName,
 			},
-			"isgold": {
+			"is_gold": {
 				Type:        schema.TypeBool,
 				Default:     false,
 				Optional:    true,
 				Description: "The status of the customer",
-				ForceNew:    true,
 			},
 			"phone": {
 				Type:        schema.TypeString,
 				Required:    true,
 				Description: "The phone number of customer",
 			},
+			"email": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The email address of the customer",
+				ValidateFunc: validateEmail,
+			},
+			"address": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "The postal address of the customer",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"street": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringLenBetween(1, 255),
+						},
+						"city": {
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validation.StringLenBetween(1, 100),
+						},
+						"region": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringLenBetween(0, 100),
+						},
+						"postal_code": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.StringLenBetween(0, 20),
+						},
+						"country": {
+							Type:         schema.TypeString,
+							Required:     true,
+							Description:  "The two letter ISO 3166 code of the country",
+							ValidateFunc: validation.StringMatch(regexp.MustCompile(`^[A-Z]{2}$`), "must be a two letter ISO 3166 country code"),
+						},
+					},
+				},
+			},
+			"member_since": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Computed:     true,
+				Description:  "The date, in YYYY-MM-DD format, the customer became a member. The store sets it to the day the customer is created when it is not given",
+				ValidateFunc: validateDate,
+			},
+			"notes": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Free-form notes about the customer",
+				ValidateFunc: validation.StringLenBetween(0, 1024),
+			},
+			"adopted_id":          adoptedIDSchema(),
+			"adopt_existing":      adoptExistingSchema("phone"),
+			"deletion_protection": deletionProtectionSchema(),
+			"version": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The version of the customer on the server when it was last read",
+			},
+		},
+		SchemaVersion: 1,
+		StateUpgraders: []schema.StateUpgrader{
+			{
+				Version: 0,
+				Type:    customerItemV0().CoreConfigSchema().ImpliedType(),
+				Upgrade: customerStateUpgradeV0,
+			},
 		},
 		Create: createCustomer,
 		Read:   readCustomer,
@@ -45,65 +131,131 @@ func CustomerItem() *schema.Resource {
 }
 
 func updateCustomer(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	customerID := d.Id()
-	customer, err := apiClient.GetCustomer(customerID)
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			d.SetId("")
-		} else {
-			return fmt.Errorf("error finding customer with id %s", customerID)
-		}
-	}
+	customer := customerFromConfig(d)
+	customer.CustomerID = customerID
+	customer.Version = d.Get("version").(int)
 
-	if d.HasChange("phone") {
-		p := d.Get("phone").(string)
-		customer.Phone = p
+	err := apiClient.UpdateCustomer(customer)
+	if errors.Is(err, client.ErrModified) {
+		return fmt.Errorf("customer %s was modified outside Terraform since it was last read, refresh and retry", customerID)
 	}
-
-	err = apiClient.UpdateCustomer(customer)
 	if err != nil {
-		return err
+		return conflictError(fieldError(err, customerAttributes), "customer", "store_customers")
 	}
 
 	return readCustomer(d, m)
 }
 
 func createCustomer(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
-	customer := client.Customer{
-		Name:  d.Get("name").(string),
-		Phone: d.Get("phone").(string),
+	if d.Get("adopt_existing").(bool) {
+		adopted, err := adoptCustomer(d, m)
+		if err != nil || adopted {
+			return err
+		}
 	}
 
-	resBody, err := apiClient.NewCustomer(&customer)
+	customer := customerFromConfig(d)
+
+	resBody, err := apiClient.NewCustomer(customer)
 
 	if err != nil {
-		return err
+		return conflictError(fieldError(err, customerAttributes), "customer", "store_customers")
 	}
 
-	err = json.NewDecoder(*resBody).Decode(&customer)
+	err = json.NewDecoder(*resBody).Decode(customer)
 	if err != nil {
 		return err
 	}
 
 	d.SetId(customer.CustomerID)
+	d.Set("version", customer.Version)
+	d.Set("member_since", customer.MemberSince)
 	return nil
 }
 
+// customerFromConfig returns the customer described by the configuration of a store_customers
+func customerFromConfig(d *schema.ResourceData) *client.Customer {
+	customer := &client.Customer{
+		Name:        d.Get("name").(string),
+		IsGold:      d.Get("is_gold").(bool),
+		Phone:       d.Get("phone").(string),
+		Email:       d.Get("email").(string),
+		MemberSince: d.Get("member_since").(string),
+		Notes:       d.Get("notes").(string),
+	}
+	if addresses := d.Get("address").([]interface{}); len(addresses) > 0 && addresses[0] != nil {
+		address := addresses[0].(map[string]interface{})
+		customer.Address = &client.Address{
+			Street:     address["street"].(string),
+			City:       address["city"].(string),
+			Region:     address["region"].(string),
+			PostalCode: address["postal_code"].(string),
+			Country:    address["country"].(string),
+		}
+	}
+	return customer
+}
+
+// flattenAddress returns the address block of a store_customers for address
+func flattenAddress(address *client.Address) []interface{} {
+	if address == nil {
+		return []interface{}{}
+	}
+	return []interface{}{
+		map[string]interface{}{
+			"street":      address.Street,
+			"city":        address.City,
+			"region":      address.Region,
+			"postal_code": address.PostalCode,
+			"country":     address.Country,
+		},
+	}
+}
+
+// adoptCustomer takes the existing customer with the configured phone number under management and updates it to match
+// the configuration, reporting false when there is none
+func adoptCustomer(d *schema.ResourceData, m interface{}) (bool, error) {
+	apiClient := m.(*providerMeta).client
+
+	phone := d.Get("phone").(string)
+	customers, err := apiClient.GetAllCustomers()
+	if err != nil {
+		return false, err
+	}
+	var ids []string
+	versions := map[string]int{}
+	for _, customer := range customers {
+		if customer.Phone == phone {
+			ids = append(ids, customer.CustomerID)
+			versions[customer.CustomerID] = customer.Version
+		}
+	}
+	id, err := adoptMatch("customer", "phone", phone, ids)
+	if err != nil || id == "" {
+		return false, err
+	}
+	d.SetId(id)
+	d.Set("adopted_id", id)
+	d.Set("version", versions[id])
+	return true, updateCustomer(d, m)
+}
+
 func readCustomer(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	customerID := d.Id()
 	customer, err := apiClient.GetCustomer(customerID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			d.SetId("")
-		} else {
-			return fmt.Errorf("error finding customer with id %s", customerID)
+			return nil
 		}
+		return fmt.Errorf("error finding customer with id %s: %s", customerID, err)
 	}
 
 	d.SetId(customer.CustomerID)
@@ -113,14 +265,29 @@ func readCustomer(d *schema.ResourceData, m interface{}) error {
 	if d.Set("phone", customer.Phone); err != nil {
 		return err
 	}
-	if d.Set("isGold", customer.IsGold); err != nil {
+	if d.Set("is_gold", customer.IsGold); err != nil {
+		return err
+	}
+	if d.Set("version", customer.Version); err != nil {
+		return err
+	}
+	if err := d.Set("email", customer.Email); err != nil {
+		return err
+	}
+	if err := d.Set("address", flattenAddress(customer.Address)); err != nil {
+		return err
+	}
+	if err := d.Set("member_since", customer.MemberSince); err != nil {
+		return err
+	}
+	if err := d.Set("notes", customer.Notes); err != nil {
 		return err
 	}
 	return nil
 }
 
 func existCustomer(d *schema.ResourceData, m interface{}) (bool, error) {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	customerID := d.Id()
 	_, err := apiClient.GetCustomer(customerID)
@@ -135,7 +302,11 @@ func existCustomer(d *schema.ResourceData, m interface{}) (bool, error) {
 }
 
 func deleteCustomer(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
+
+	if err := checkDeletionProtection(d, "store_customers"); err != nil {
+		return err
+	}
 
 	customerID := d.Id()
 
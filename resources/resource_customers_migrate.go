@@ -0,0 +1,36 @@
+package provider
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// customerItemV0 is the schema of store_customers before version 1, where the gold status was named isgold
+func customerItemV0() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"name": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"isgold": {
+				Type:     schema.TypeBool,
+				Default:  false,
+				Optional: true,
+				ForceNew: true,
+			},
+			"phone": {
+				Type:     schema.TypeString,
+				Required: true,
+			},
+		},
+	}
+}
+
+// customerStateUpgradeV0 renames the isgold attribute of a version 0 state to is_gold
+func customerStateUpgradeV0(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	isGold, _ := rawState["isgold"].(bool)
+	delete(rawState, "isgold")
+	rawState["is_gold"] = isGold
+	return rawState, nil
+}
@@ -0,0 +1,20 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCustomerStateUpgradeV0(t *testing.T) {
+	upgraded := testUpgradeState(t, CustomerItem(), testV0StateAttributes(t, "store_customers"))
+
+	expected := map[string]interface{}{
+		"id":      "5ee998a7073cfb0d8696fec1",
+		"name":    "foobar",
+		"phone":   "123456789",
+		"is_gold": true,
+	}
+	if !reflect.DeepEqual(upgraded, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, upgraded)
+	}
+}
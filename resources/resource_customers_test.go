@@ -2,16 +2,51 @@ package provider
 
 import (
 	"fmt"
+	"log"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
 	"github.com/milamice62/terraplugin/api/client"
+	"github.com/milamice62/terraplugin/api/server"
 )
 
+func init() {
+	resource.AddTestSweepers("store_customers", &resource.Sweeper{
+		Name:         "store_customers",
+		Dependencies: []string{"store_rentals"},
+		F:            sweepCustomers,
+	})
+}
+
+// sweepCustomers deletes the customers left behind by the acceptance tests
+func sweepCustomers(region string) error {
+	apiClient, err := testEnvClient()
+	if err != nil {
+		return err
+	}
+	customers, err := apiClient.GetAllCustomers()
+	if err != nil {
+		return fmt.Errorf("error listing customers: %s", err)
+	}
+	var errs []string
+	for _, customer := range customers {
+		if !strings.HasPrefix(customer.Name, testAccPrefix) {
+			continue
+		}
+		log.Printf("[INFO] sweeping customer %s (%s)", customer.CustomerID, customer.Name)
+		if err := apiClient.DeleteCustomer(customer.CustomerID); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", customer.CustomerID, err))
+		}
+	}
+	return sweepErrors("customers", errs)
+}
+
 func Test_Customer_Init(t *testing.T) {
-	resource.Test(t, resource.TestCase{
+	testAccTest(t, resource.TestCase{
 		PreCheck:     func() { testAccPreCheck(t) },
 		Providers:    testAccProviders,
 		CheckDestroy: testAccCheckCustomerDestroy,
@@ -21,9 +56,9 @@ func Test_Customer_Init(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testAccCheckExampleCustomerExists("store_customers.customer1"),
 					resource.TestCheckResourceAttr(
-						"store_customers.customer1", "name", "foobar"),
+						"store_customers.customer1", "name", testAccPrefix+"foobar"),
 					resource.TestCheckResourceAttr(
-						"store_customers.customer1", "phone", "123456789"),
+						"store_customers.customer1", "phone", "555123456"),
 				),
 			},
 		},
@@ -31,7 +66,7 @@ func Test_Customer_Init(t *testing.T) {
 }
 
 func Test_Customer_Update(t *testing.T) {
-	resource.Test(t, resource.TestCase{
+	testAccTest(t, resource.TestCase{
 		PreCheck:     func() { testAccPreCheck(t) },
 		Providers:    testAccProviders,
 		CheckDestroy: testAccCheckCustomerDestroy,
@@ -41,9 +76,9 @@ func Test_Customer_Update(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testAccCheckExampleCustomerExists("store_customers.customer1"),
 					resource.TestCheckResourceAttr(
-						"store_customers.customer1", "name", "foobar"),
+						"store_customers.customer1", "name", testAccPrefix+"foobar"),
 					resource.TestCheckResourceAttr(
-						"store_customers.customer1", "phone", "123456789"),
+						"store_customers.customer1", "phone", "555123456"),
 				),
 			},
 			{
@@ -51,9 +86,15 @@ func Test_Customer_Update(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testAccCheckExampleCustomerExists("store_customers.customer1"),
 					resource.TestCheckResourceAttr(
-						"store_customers.customer1", "name", "barfoo"),
+						"store_customers.customer1", "name", testAccPrefix+"Jane Doe"),
+					resource.TestCheckResourceAttr(
+						"store_customers.customer1", "phone", "555987654"),
+					resource.TestCheckResourceAttr(
+						"store_customers.customer1", "email", "jane@example.com"),
 					resource.TestCheckResourceAttr(
-						"store_customers.customer1", "phone", "987654321"),
+						"store_customers.customer1", "address.0.city", "Springfield"),
+					resource.TestCheckResourceAttr(
+						"store_customers.customer1", "member_since", "2019-05-01"),
 				),
 			},
 		},
@@ -61,7 +102,7 @@ func Test_Customer_Update(t *testing.T) {
 }
 
 func testAccCheckCustomerDestroy(s *terraform.State) error {
-	apiClient := testAccProvider.Meta().(*client.Client)
+	apiClient := testAccProvider.Meta().(*providerMeta).client
 
 	for _, rs := range s.RootModule().Resources {
 		if rs.Type != "store_customers" {
@@ -92,7 +133,7 @@ func testAccCheckExampleCustomerExists(resource string) resource.TestCheckFunc {
 			return fmt.Errorf("No Record ID is set")
 		}
 		id := rs.Primary.ID
-		apiClient := testAccProvider.Meta().(*client.Client)
+		apiClient := testAccProvider.Meta().(*providerMeta).client
 		_, err := apiClient.GetCustomer(id)
 		if err != nil {
 			return fmt.Errorf("error fetching customer with resource %s. %s", resource, err)
@@ -104,17 +145,147 @@ func testAccCheckExampleCustomerExists(resource string) resource.TestCheckFunc {
 func testAccCheckCustomerInit() string {
 	return fmt.Sprintf(`
 resource "store_customers" "customer1" {
-  name = "foobar"
-  phone = "123456789"
+  name = "%sfoobar"
+  phone = "555123456"
 }
-`)
+`, testAccPrefix)
 }
 
 func testAccCheckCustomerUpdate() string {
 	return fmt.Sprintf(`
 resource "store_customers" "customer1" {
-  name = "barfoo"
-  phone = "987654321"
+  name = "%sJane Doe"
+  phone = "555987654"
+  email = "jane@example.com"
+  member_since = "2019-05-01"
+  notes = "Prefers to be called about new releases"
+
+  address {
+    street = "742 Evergreen Terrace"
+    city = "Springfield"
+    postal_code = "49007"
+    country = "US"
+  }
+}
+`, testAccPrefix)
 }
-`)
+
+func TestUpdateCustomer_ModifiedOutsideTerraform(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Customers: []server.Customer{{ID: "5ee998a7073cfb0d8696fec1", Name: "foobar", Phone: "123456789"}},
+	})
+
+	// Another apply changes the customer after this one read version 0
+	err := meta.client.UpdateCustomer(&client.Customer{CustomerID: "5ee998a7073cfb0d8696fec1", Name: "foobar", Phone: "555555555"})
+	if err != nil {
+		t.Fatalf("error updating customer: %s", err)
+	}
+
+	d := schema.TestResourceDataRaw(t, CustomerItem().Schema, map[string]interface{}{
+		"name":  "foobar",
+		"phone": "987654321",
+	})
+	d.SetId("5ee998a7073cfb0d8696fec1")
+	d.Set("version", 0)
+
+	err = updateCustomer(d, meta)
+	if err == nil || !strings.Contains(err.Error(), "modified outside Terraform") {
+		t.Fatalf("expected a stale update to be rejected, got %v", err)
+	}
+
+	d.Set("version", 1)
+	if err := updateCustomer(d, meta); err != nil {
+		t.Fatalf("expected an update from the current version to succeed, got %s", err)
+	}
+	if phone := d.Get("phone").(string); phone != "987654321" {
+		t.Fatalf("expected phone to be updated, got %s", phone)
+	}
+	if version := d.Get("version").(int); version != 2 {
+		t.Fatalf("expected version 2 after the update, got %d", version)
+	}
+}
+
+func TestUpdateCustomer_InPlace(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Customers: []server.Customer{{ID: "5ee998a7073cfb0d8696fec1", Name: "foobar", Phone: "123456789", MemberSince: "2019-05-01"}},
+	})
+
+	old := schema.TestResourceDataRaw(t, CustomerItem().Schema, map[string]interface{}{
+		"name":  "foobar",
+		"phone": "123456789",
+	})
+	old.SetId("5ee998a7073cfb0d8696fec1")
+	if err := readCustomer(old, meta); err != nil {
+		t.Fatal(err)
+	}
+	config := map[string]interface{}{
+		"name":    "Jane Doe",
+		"phone":   "123456789",
+		"is_gold": true,
+		"email":   "jane@example.com",
+		"notes":   "Prefers to be called about new releases",
+		"address": []interface{}{map[string]interface{}{
+			"street":      "742 Evergreen Terrace",
+			"city":        "Springfield",
+			"postal_code": "49007",
+			"country":     "US",
+		}},
+	}
+	diff, err := CustomerItem().Diff(old.State(), terraform.NewResourceConfigRaw(config), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.RequiresNew() {
+		t.Fatalf("expected the customer to be updated in place, got %#v", diff)
+	}
+
+	d := schema.TestResourceDataRaw(t, CustomerItem().Schema, config)
+	d.SetId("5ee998a7073cfb0d8696fec1")
+	d.Set("version", 0)
+	d.Set("member_since", "2019-05-01")
+	if err := updateCustomer(d, meta); err != nil {
+		t.Fatalf("error updating customer: %s", err)
+	}
+
+	customer, err := meta.client.GetCustomer("5ee998a7073cfb0d8696fec1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customer.Name != "Jane Doe" || !customer.IsGold || customer.Email != "jane@example.com" || customer.Notes == "" {
+		t.Errorf("expected the customer to be updated, got %+v", customer)
+	}
+	if customer.Address == nil || customer.Address.City != "Springfield" || customer.Address.Country != "US" {
+		t.Errorf("expected the address to be stored, got %+v", customer.Address)
+	}
+	if customer.MemberSince != "2019-05-01" {
+		t.Errorf("expected the membership date to be kept, got %s", customer.MemberSince)
+	}
+	if got := d.Get("address.0.postal_code").(string); got != "49007" {
+		t.Errorf("expected the address to be read back, got postal code %q", got)
+	}
+}
+
+func TestCustomerItem_Validation(t *testing.T) {
+	cases := map[string]struct {
+		key   string
+		value interface{}
+		valid bool
+	}{
+		"name with spaces":       {"name", "Jane Doe", true},
+		"name padded":            {"name", " Jane Doe", false},
+		"name too short":         {"name", "Jane", false},
+		"email":                  {"email", "jane@example.com", true},
+		"email with a name":      {"email", "Jane <jane@example.com>", false},
+		"email without a domain": {"email", "jane", false},
+		"member_since":           {"member_since", "2019-05-01", true},
+		"member_since format":    {"member_since", "01/05/2019", false},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, errs := CustomerItem().Schema[c.key].ValidateFunc(c.value, c.key)
+			if valid := len(errs) == 0; valid != c.valid {
+				t.Errorf("expected %q to be valid %v, got errors %v", c.value, c.valid, errs)
+			}
+		})
+	}
 }
@@ -0,0 +1,26 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// deletionProtectionSchema is the deletion_protection argument of resources whose records are too valuable to lose
+// to a mistaken destroy
+func deletionProtectionSchema() *schema.Schema {
+	return &schema.Schema{
+		Type:        schema.TypeBool,
+		Optional:    true,
+		Default:     false,
+		Description: "Refuse to destroy the record, including to replace it, until this is set to false and applied",
+	}
+}
+
+// checkDeletionProtection returns an error when the resource being destroyed has deletion_protection enabled
+func checkDeletionProtection(d *schema.ResourceData, resourceType string) error {
+	if d.Get("deletion_protection").(bool) {
+		return fmt.Errorf("cannot destroy %s %s while deletion_protection is enabled, set it to false and apply first", resourceType, d.Id())
+	}
+	return nil
+}
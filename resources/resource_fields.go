@@ -0,0 +1,30 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/milamice62/terraplugin/api/client"
+)
+
+// fieldError explains the fields rejected by the store in terms of the arguments of a resource, naming each argument
+// by the attribute that attributes maps its API field to. Fields without an attribute keep their API name. Any other
+// error is returned unchanged
+func fieldError(err error, attributes map[string]string) error {
+	var invalid *client.ValidationError
+	if !errors.As(err, &invalid) {
+		return err
+	}
+	messages := make([]string, len(invalid.Errors))
+	for i, e := range invalid.Errors {
+		attribute, ok := attributes[e.Field]
+		if !ok {
+			attribute = e.Field
+		}
+		// The store starts each message with the quoted API field
+		message := strings.Replace(e.Message, fmt.Sprintf("%q", e.Field), fmt.Sprintf("%q", attribute), 1)
+		messages[i] = fmt.Sprintf("%s: %s", attribute, message)
+	}
+	return fmt.Errorf("the store rejected the configuration:\n%s", strings.Join(messages, "\n"))
+}
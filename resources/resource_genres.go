@@ -2,6 +2,7 @@ package provider
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
 
@@ -9,8 +10,13 @@ import (
 	"github.com/milamice62/terraplugin/api/client"
 )
 
+// genreAttributes maps the fields of a genre in the API to the attributes of store_genres
+var genreAttributes = map[string]string{
+	"name":     "name",
+	"parentId": "parent_id",
+}
+
 func GenreItem() *schema.Resource {
-	fmt.Print()
 	return &schema.Resource{
 		Schema: map[string]*schema.Schema{
 			"name": {
@@ -20,28 +26,66 @@ func GenreItem() *schema.Resource {
 				ForceNew:     true,
 				ValidateFunc: validateName,
 			},
+			"parent_id": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The id of the genre this genre is a subgenre of",
+				ValidateFunc: validateObjectID,
+			},
+			"path": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The names of the genre and its ancestors from the root down, separated by \" > \"",
+			},
+			"version": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The version of the genre on the server when it was last read",
+			},
+			"adopted_id":          adoptedIDSchema(),
+			"adopt_existing":      adoptExistingSchema("name"),
+			"deletion_protection": deletionProtectionSchema(),
 		},
-		Create: createGenre,
-		Read:   readGenre,
-		Delete: deleteGenre,
-		Exists: existGenre,
+		CustomizeDiff: customizeGenreDiff,
+		Create:        createGenre,
+		Read:          readGenre,
+		Update:        updateGenre,
+		Delete:        deleteGenre,
+		Exists:        existGenre,
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
 	}
 }
 
+// customizeGenreDiff checks at plan time that the parent genre exists
+func customizeGenreDiff(d *schema.ResourceDiff, m interface{}) error {
+	meta := m.(*providerMeta)
+	return checkReference(d, meta, "parent_id", "genre", func(id string) error {
+		_, err := meta.client.GetGenre(id)
+		return err
+	})
+}
+
 func createGenre(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
+
+	if d.Get("adopt_existing").(bool) {
+		adopted, err := adoptGenre(d, m)
+		if err != nil || adopted {
+			return err
+		}
+	}
 
 	genre := client.Genre{
-		Name: d.Get("name").(string),
+		Name:     d.Get("name").(string),
+		ParentID: d.Get("parent_id").(string),
 	}
 
 	resBody, err := apiClient.NewGenre(&genre)
 
 	if err != nil {
-		return err
+		return conflictError(fieldError(err, genreAttributes), "genre", "store_genres")
 	}
 
 	err = json.NewDecoder(resBody).Decode(&genre)
@@ -50,31 +94,87 @@ func createGenre(d *schema.ResourceData, m interface{}) error {
 	}
 
 	d.SetId(genre.ID)
+	d.Set("path", genre.Path)
+	d.Set("version", genre.Version)
 	return nil
 }
 
+// adoptGenre takes the existing genre with the configured name under management and updates it to match the
+// configuration, reporting false when there is none
+func adoptGenre(d *schema.ResourceData, m interface{}) (bool, error) {
+	apiClient := m.(*providerMeta).client
+
+	name := d.Get("name").(string)
+	genres, err := apiClient.GetAllGenres()
+	if err != nil {
+		return false, err
+	}
+	var ids []string
+	versions := map[string]int{}
+	for _, genre := range genres {
+		if genre.Name == name {
+			ids = append(ids, genre.ID)
+			versions[genre.ID] = genre.Version
+		}
+	}
+	id, err := adoptMatch("genre", "name", name, ids)
+	if err != nil || id == "" {
+		return false, err
+	}
+	d.SetId(id)
+	d.Set("adopted_id", id)
+	d.Set("version", versions[id])
+	return true, updateGenre(d, m)
+}
+
 func readGenre(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	genreID := d.Id()
 	genre, err := apiClient.GetGenre(genreID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			d.SetId("")
-		} else {
-			return fmt.Errorf("error finding Genre with name %s", genreID)
+			return nil
 		}
+		return fmt.Errorf("error finding Genre with name %s: %s", genreID, err)
 	}
 
 	d.SetId(genre.ID)
-	if d.Set("name", genre.Name); err != nil {
+	if err := d.Set("name", genre.Name); err != nil {
 		return err
 	}
+	d.Set("parent_id", genre.ParentID)
+	d.Set("path", genre.Path)
+	d.Set("version", genre.Version)
 	return nil
 }
 
+// updateGenre moves the genre under its configured parent. The name is replaced rather than updated
+func updateGenre(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	genreID := d.Id()
+	genre := &client.Genre{
+		ID:       genreID,
+		Name:     d.Get("name").(string),
+		ParentID: d.Get("parent_id").(string),
+		Version:  d.Get("version").(int),
+	}
+
+	err := apiClient.UpdateGenre(genre)
+	if errors.Is(err, client.ErrModified) {
+		return fmt.Errorf("genre %s was modified outside Terraform since it was last read, refresh and retry", genreID)
+	}
+	if err != nil {
+		return conflictError(fieldError(err, genreAttributes), "genre", "store_genres")
+	}
+
+	return readGenre(d, m)
+}
+
 func existGenre(d *schema.ResourceData, m interface{}) (bool, error) {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	genreID := d.Id()
 	_, err := apiClient.GetGenre(genreID)
@@ -89,7 +189,11 @@ func existGenre(d *schema.ResourceData, m interface{}) (bool, error) {
 }
 
 func deleteGenre(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
+
+	if err := checkDeletionProtection(d, "store_genres"); err != nil {
+		return err
+	}
 
 	genreID := d.Id()
 
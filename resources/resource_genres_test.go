@@ -2,16 +2,56 @@ package provider
 
 import (
 	"fmt"
+	"log"
 	"regexp"
+	"sort"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
 	"github.com/milamice62/terraplugin/api/client"
+	"github.com/milamice62/terraplugin/api/server"
 )
 
+func init() {
+	resource.AddTestSweepers("store_genres", &resource.Sweeper{
+		Name:         "store_genres",
+		Dependencies: []string{"store_movies"},
+		F:            sweepGenres,
+	})
+}
+
+// sweepGenres deletes the genres left behind by the acceptance tests. Subgenres are deleted before their parents,
+// since the store refuses to delete a genre that still has subgenres
+func sweepGenres(region string) error {
+	apiClient, err := testEnvClient()
+	if err != nil {
+		return err
+	}
+	genres, err := apiClient.GetAllGenres()
+	if err != nil {
+		return fmt.Errorf("error listing genres: %s", err)
+	}
+	sort.SliceStable(genres, func(i, j int) bool {
+		return strings.Count(genres[i].Path, " > ") > strings.Count(genres[j].Path, " > ")
+	})
+	var errs []string
+	for _, genre := range genres {
+		if !strings.HasPrefix(genre.Name, testAccPrefix) {
+			continue
+		}
+		log.Printf("[INFO] sweeping genre %s (%s)", genre.ID, genre.Name)
+		if err := apiClient.DeleteGenre(genre.ID); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", genre.ID, err))
+		}
+	}
+	return sweepErrors("genres", errs)
+}
+
 func Test_Genre_Init(t *testing.T) {
-	resource.Test(t, resource.TestCase{
+	testAccTest(t, resource.TestCase{
 		PreCheck:     func() { testAccPreCheck(t) },
 		Providers:    testAccProviders,
 		CheckDestroy: testAccCheckGenreDestroy,
@@ -21,7 +61,7 @@ func Test_Genre_Init(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testAccCheckExampleGenreExists("store_genres.kind"),
 					resource.TestCheckResourceAttr(
-						"store_genres.kind", "name", "comedy"),
+						"store_genres.kind", "name", testAccPrefix+"comedy"),
 				),
 			},
 		},
@@ -29,7 +69,7 @@ func Test_Genre_Init(t *testing.T) {
 }
 
 func Test_Genre_Update(t *testing.T) {
-	resource.Test(t, resource.TestCase{
+	testAccTest(t, resource.TestCase{
 		PreCheck:     func() { testAccPreCheck(t) },
 		Providers:    testAccProviders,
 		CheckDestroy: testAccCheckGenreDestroy,
@@ -39,7 +79,7 @@ func Test_Genre_Update(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testAccCheckExampleGenreExists("store_genres.kind"),
 					resource.TestCheckResourceAttr(
-						"store_genres.kind", "name", "comedy"),
+						"store_genres.kind", "name", testAccPrefix+"comedy"),
 				),
 			},
 			{
@@ -47,7 +87,7 @@ func Test_Genre_Update(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testAccCheckExampleGenreExists("store_genres.kind"),
 					resource.TestCheckResourceAttr(
-						"store_genres.kind", "name", "drama"),
+						"store_genres.kind", "name", testAccPrefix+"drama"),
 				),
 			},
 		},
@@ -55,7 +95,7 @@ func Test_Genre_Update(t *testing.T) {
 }
 
 func testAccCheckGenreDestroy(s *terraform.State) error {
-	apiClient := testAccProvider.Meta().(*client.Client)
+	apiClient := testAccProvider.Meta().(*providerMeta).client
 
 	for _, rs := range s.RootModule().Resources {
 		if rs.Type != "store_genres" {
@@ -86,7 +126,7 @@ func testAccCheckExampleGenreExists(resource string) resource.TestCheckFunc {
 			return fmt.Errorf("No Record ID is set")
 		}
 		id := rs.Primary.ID
-		apiClient := testAccProvider.Meta().(*client.Client)
+		apiClient := testAccProvider.Meta().(*providerMeta).client
 		_, err := apiClient.GetGenre(id)
 		if err != nil {
 			return fmt.Errorf("error fetching genre with resource %s. %s", resource, err)
@@ -98,15 +138,178 @@ func testAccCheckExampleGenreExists(resource string) resource.TestCheckFunc {
 func testAccCheckGenreInit() string {
 	return fmt.Sprintf(`
 resource "store_genres" "kind" {
-  name = "comedy"
+  name = "%scomedy"
 }
-`)
+`, testAccPrefix)
 }
 
 func testAccCheckGenreUpdate() string {
 	return fmt.Sprintf(`
 resource "store_genres" "kind" {
-  name = "drama"
+  name = "%sdrama"
 }
-`)
+`, testAccPrefix)
+}
+
+func TestCreateGenre_AdoptExisting(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres: []server.Genre{
+			{ID: "5ee05b02340e2cae12c1bea5", Name: "horror"},
+			{ID: "5ee19f2a1363f7c0493761e9", Name: "comedy"},
+		},
+	})
+
+	d := schema.TestResourceDataRaw(t, GenreItem().Schema, map[string]interface{}{
+		"name":           "comedy",
+		"parent_id":      "5ee05b02340e2cae12c1bea5",
+		"adopt_existing": true,
+	})
+	if err := createGenre(d, meta); err != nil {
+		t.Fatalf("error creating genre: %s", err)
+	}
+	if d.Id() != "5ee19f2a1363f7c0493761e9" || d.Get("adopted_id") != "5ee19f2a1363f7c0493761e9" {
+		t.Fatalf("expected the existing genre to be adopted, got ID %q and adopted_id %q", d.Id(), d.Get("adopted_id"))
+	}
+
+	genres, err := meta.client.GetAllGenres()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(genres) != 2 {
+		t.Fatalf("expected no genre to be created, got %d genres", len(genres))
+	}
+	// The adopted genre is updated to match the configuration
+	genre, err := meta.client.GetGenre("5ee19f2a1363f7c0493761e9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if genre.ParentID != "5ee05b02340e2cae12c1bea5" || d.Get("path") != "horror > comedy" {
+		t.Fatalf("expected the adopted genre to be moved under horror, got parent %q and path %q", genre.ParentID, d.Get("path"))
+	}
+
+	d = schema.TestResourceDataRaw(t, GenreItem().Schema, map[string]interface{}{
+		"name":           "drama",
+		"adopt_existing": true,
+	})
+	if err := createGenre(d, meta); err != nil {
+		t.Fatalf("error creating genre: %s", err)
+	}
+	if d.Id() == "" || d.Id() == "5ee19f2a1363f7c0493761e9" || d.Get("adopted_id") != "" {
+		t.Fatalf("expected a new genre to be created when there is nothing to adopt, got ID %q and adopted_id %q", d.Id(), d.Get("adopted_id"))
+	}
+}
+
+func TestCreateGenre_Conflict(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres: []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "comedy"}},
+	})
+
+	d := schema.TestResourceDataRaw(t, GenreItem().Schema, map[string]interface{}{
+		"name": "comedy",
+	})
+	err := createGenre(d, meta)
+	expected := "genre with name \"comedy\" already exists as 5ee19f2a1363f7c0493761e9; import it with `terraform import store_genres.<name> 5ee19f2a1363f7c0493761e9`"
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Fatalf("expected error %q, got %v", expected, err)
+	}
+}
+
+func TestDeleteGenre_DeletionProtection(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres: []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "comedy"}},
+	})
+
+	d := schema.TestResourceDataRaw(t, GenreItem().Schema, map[string]interface{}{
+		"name":                "comedy",
+		"deletion_protection": true,
+	})
+	d.SetId("5ee19f2a1363f7c0493761e9")
+	err := deleteGenre(d, meta)
+	if err == nil || !strings.Contains(err.Error(), "deletion_protection is enabled") {
+		t.Fatalf("expected destroy to be refused, got %v", err)
+	}
+	if _, err := meta.client.GetGenre("5ee19f2a1363f7c0493761e9"); err != nil {
+		t.Fatalf("expected the genre to still exist: %s", err)
+	}
+
+	d.Set("deletion_protection", false)
+	if err := deleteGenre(d, meta); err != nil {
+		t.Fatalf("error deleting genre: %s", err)
+	}
+}
+
+func TestDeleteGenre_SoftDelete(t *testing.T) {
+	service := testStoreService(t, &server.Dataset{
+		Genres: []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "comedy"}},
+	})
+	meta := testMetaFor(t, service.Handler(), client.WithSoftDelete(true))
+
+	d := schema.TestResourceDataRaw(t, GenreItem().Schema, map[string]interface{}{
+		"name": "comedy",
+	})
+	d.SetId("5ee19f2a1363f7c0493761e9")
+	if err := deleteGenre(d, meta); err != nil {
+		t.Fatalf("error deleting genre: %s", err)
+	}
+	if _, err := meta.client.GetGenre("5ee19f2a1363f7c0493761e9"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Fatalf("expected a soft-deleted genre to be not found, got %v", err)
+	}
+
+	if err := meta.client.RestoreGenre("5ee19f2a1363f7c0493761e9"); err != nil {
+		t.Fatalf("error restoring genre: %s", err)
+	}
+	if _, err := meta.client.GetGenre("5ee19f2a1363f7c0493761e9"); err != nil {
+		t.Fatalf("expected the genre to be restored: %s", err)
+	}
+}
+
+func TestUpdateGenre_Parent(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres: []server.Genre{
+			{ID: "5ee05b02340e2cae12c1bea5", Name: "horror"},
+			{ID: "5ee19f2a1363f7c0493761e9", Name: "slasher"},
+		},
+	})
+
+	d := schema.TestResourceDataRaw(t, GenreItem().Schema, map[string]interface{}{
+		"name":      "slasher",
+		"parent_id": "5ee05b02340e2cae12c1bea5",
+	})
+	d.SetId("5ee19f2a1363f7c0493761e9")
+	if err := updateGenre(d, meta); err != nil {
+		t.Fatalf("error updating genre: %s", err)
+	}
+	if got := d.Get("path").(string); got != "horror > slasher" {
+		t.Errorf("expected the genre to be moved under horror, got path %q", got)
+	}
+
+	d = schema.TestResourceDataRaw(t, GenreItem().Schema, map[string]interface{}{
+		"name":      "horror",
+		"parent_id": "5ee19f2a1363f7c0493761e9",
+	})
+	d.SetId("5ee05b02340e2cae12c1bea5")
+	if err := updateGenre(d, meta); err == nil || !strings.Contains(err.Error(), "its own ancestor") {
+		t.Errorf("expected a cycle to be rejected, got %v", err)
+	}
+}
+
+func TestGenreItem_Validation(t *testing.T) {
+	cases := map[string]struct {
+		key   string
+		value interface{}
+		valid bool
+	}{
+		"parent_id":            {"parent_id", "5ee19f2a1363f7c0493761e9", true},
+		"parent_id too short":  {"parent_id", "5ee19f2a", false},
+		"parent_id not hex":    {"parent_id", "horror-horror-horror-hor", false},
+		"parent_id upper case": {"parent_id", "5EE19F2A1363F7C0493761E9", false},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			_, errs := GenreItem().Schema[c.key].ValidateFunc(c.value, c.key)
+			if valid := len(errs) == 0; valid != c.valid {
+				t.Errorf("expected %q to be valid %v, got errors %v", c.value, c.valid, errs)
+			}
+		})
+	}
 }
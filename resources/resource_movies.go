@@ -2,13 +2,28 @@ package provider
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 	"github.com/milamice62/terraplugin/api/client"
 )
 
+// movieAttributes maps the fields of a movie in the API to the attributes of store_movies
+var movieAttributes = map[string]string{
+	"title":           "title",
+	"genreIds":        "genre_ids",
+	"numberInStock":   "stock",
+	"dailyRentalRate": "daily_rate",
+	"releaseYear":     "release_year",
+	"rating":          "rating",
+	"runtimeMinutes":  "runtime_minutes",
+	"description":     "description",
+}
+
 func MovieItem() *schema.Resource {
 	return &schema.Resource{
 		Schema: map[string]*schema.Schema{
@@ -16,139 +31,245 @@ func MovieItem() *schema.Resource {
 				Type:         schema.TypeString,
 				Required:     true,
 				Description:  "The movie title",
-				ForceNew:     true,
 				ValidateFunc: validateName,
 			},
-			"genre": {
-				Type:        schema.TypeList,
+			"genre_ids": {
+				Type:        schema.TypeSet,
 				Required:    true,
-				Description: "The movie genre",
-				MaxItems:    1,
-				ForceNew:    true,
-				Elem: &schema.Resource{
-					Schema: map[string]*schema.Schema{
-						"name": {
-							Type:        schema.TypeString,
-							Computed:    true,
-							Description: "The name of the genre",
-							ForceNew:    true,
-						},
-						"_id": {
-							Type:         schema.TypeString,
-							Required:     true,
-							Description:  "The id of the genre",
-							ForceNew:     true,
-							ValidateFunc: validateName,
-						},
-					}},
+				MinItems:    1,
+				Description: "The ids of the movie genres. The lowest id is sent first, as the primary genre",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validateName,
+				},
+			},
+			"genre_names": {
+				Type:        schema.TypeMap,
+				Computed:    true,
+				Description: "The names of the movie genres, keyed by genre id",
+				Elem:        &schema.Schema{Type: schema.TypeString},
 			},
 			"stock": {
 				Type:         schema.TypeInt,
 				Required:     true,
 				Description:  "The movie stock",
-				ForceNew:     true,
 				ValidateFunc: validateInt,
 			},
 			"daily_rate": {
 				Type:         schema.TypeFloat,
 				Required:     true,
 				Description:  "The movie daily rental rate",
-				ForceNew:     true,
 				ValidateFunc: validateFloat,
 			},
+			"release_year": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "The year the movie was released",
+				ValidateFunc: validateReleaseYear,
+			},
+			"rating": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "The MPAA-style rating of the movie, one of G, PG, PG-13, R and NC-17",
+				ValidateFunc: validation.StringInSlice(client.MovieRatings, false),
+			},
+			"runtime_minutes": {
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Description:  "The running time of the movie in minutes",
+				ValidateFunc: validation.IntBetween(1, 1000),
+			},
+			"description": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "A description of the movie",
+				ValidateFunc: validation.StringLenBetween(0, 2000),
+			},
+			"version": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The version of the movie on the server when it was last read",
+			},
+			"adopted_id":          adoptedIDSchema(),
+			"adopt_existing":      adoptExistingSchema("title"),
+			"deletion_protection": deletionProtectionSchema(),
 		},
-		Create: createMovie,
-		Read:   readMovie,
-		Delete: deleteMovie,
-		Exists: existMovie,
+		SchemaVersion: 2,
+		StateUpgraders: []schema.StateUpgrader{
+			{
+				Version: 0,
+				Type:    movieItemV0().CoreConfigSchema().ImpliedType(),
+				Upgrade: movieStateUpgradeV0,
+			},
+			{
+				Version: 1,
+				Type:    movieItemV1().CoreConfigSchema().ImpliedType(),
+				Upgrade: movieStateUpgradeV1,
+			},
+		},
+		CustomizeDiff: customizeMovieDiff,
+		Create:        createMovie,
+		Read:          readMovie,
+		Update:        updateMovie,
+		Delete:        deleteMovie,
+		Exists:        existMovie,
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
 	}
 }
 
-func expandGenre(genre []interface{}) (*client.Genre, error) {
-	gen := &client.Genre{}
+// customizeMovieDiff checks at plan time that the genres the movie references exist
+func customizeMovieDiff(d *schema.ResourceDiff, m interface{}) error {
+	meta := m.(*providerMeta)
+	return checkReferences(d, meta, "genre_ids", "genre", func(id string) error {
+		_, err := meta.client.GetGenre(id)
+		return err
+	})
+}
 
-	if len(genre) == 0 || genre[0] == nil {
-		return nil, fmt.Errorf("Genre should be specified, but get %v", genre)
+// movieFromConfig returns the movie described by the configuration of a store_movies resource
+func movieFromConfig(d *schema.ResourceData) *client.Movie {
+	ids := []string{}
+	for _, id := range d.Get("genre_ids").(*schema.Set).List() {
+		ids = append(ids, id.(string))
 	}
+	sort.Strings(ids)
 
-	in, ok := genre[0].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("Error fetching genre element: %v", in)
+	movie := &client.Movie{
+		Title:       d.Get("title").(string),
+		Stock:       d.Get("stock").(int),
+		Rate:        d.Get("daily_rate").(float64),
+		ReleaseYear: d.Get("release_year").(int),
+		Rating:      d.Get("rating").(string),
+		Runtime:     d.Get("runtime_minutes").(int),
+		Description: d.Get("description").(string),
 	}
-
-	gen.Name = in["name"].(string)
-	gen.ID = in["_id"].(string)
-	return gen, nil
-}
-
-func flattenGenre(movie *client.Movie, d *schema.ResourceData) []interface{} {
-	m := make(map[string]interface{})
-	m["name"] = movie.Genre.Name
-	m["_id"] = movie.Genre.ID
-
-	return []interface{}{m}
+	for _, id := range ids {
+		movie.Genres = append(movie.Genres, client.Genre{ID: id})
+	}
+	return movie
 }
 
 func createMovie(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
-	genre, err := expandGenre(d.Get("genre").([]interface{}))
-	if err != nil {
-		fmt.Printf("%v", err)
+	if d.Get("adopt_existing").(bool) {
+		adopted, err := adoptMovie(d, m)
+		if err != nil || adopted {
+			return err
+		}
 	}
 
-	movie := client.Movie{}
-	movie.Title = d.Get("title").(string)
-	movie.Stock = d.Get("stock").(int)
-	movie.Rate = d.Get("daily_rate").(float64)
-	movie.Genre = genre
+	movie := movieFromConfig(d)
 
-	body, err := apiClient.NewMovie(&movie)
+	body, err := apiClient.NewMovie(movie)
 
 	if err != nil {
-		return err
+		return conflictError(fieldError(err, movieAttributes), "movie", "store_movies")
 	}
 
-	err = json.NewDecoder(*body).Decode(&movie)
+	err = json.NewDecoder(*body).Decode(movie)
 	if err != nil {
 		return err
 	}
 
 	d.SetId(movie.MovieID)
-	gen := flattenGenre(&movie, d)
-	d.Set("genre", gen)
+	d.Set("genre_names", movieGenreNames(movie))
+	d.Set("version", movie.Version)
 
 	return nil
 }
 
+// adoptMovie takes the existing movie with the configured title under management and updates it to match the
+// configuration, reporting false when there is none
+func adoptMovie(d *schema.ResourceData, m interface{}) (bool, error) {
+	apiClient := m.(*providerMeta).client
+
+	title := d.Get("title").(string)
+	movies, err := apiClient.GetAllMovies()
+	if err != nil {
+		return false, err
+	}
+	var ids []string
+	versions := map[string]int{}
+	for _, movie := range movies {
+		if movie.Title == title {
+			ids = append(ids, movie.MovieID)
+			versions[movie.MovieID] = movie.Version
+		}
+	}
+	id, err := adoptMatch("movie", "title", title, ids)
+	if err != nil || id == "" {
+		return false, err
+	}
+	d.SetId(id)
+	d.Set("adopted_id", id)
+	d.Set("version", versions[id])
+	return true, updateMovie(d, m)
+}
+
 func readMovie(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	movieID := d.Id()
 	movie, err := apiClient.GetMovie(movieID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			d.SetId("")
-		} else {
-			return fmt.Errorf("error finding movie with id %s", movieID)
+			return nil
 		}
+		return fmt.Errorf("error finding movie with id %s: %s", movieID, err)
 	}
 
-	genre := flattenGenre(movie, d)
 	d.SetId(movieID)
 	d.Set("title", movie.Title)
 	d.Set("daily_rate", movie.Rate)
 	d.Set("stock", movie.Stock)
-	d.Set("genre", genre)
+	d.Set("genre_ids", movie.GenreIDs())
+	d.Set("genre_names", movieGenreNames(movie))
+	d.Set("release_year", movie.ReleaseYear)
+	d.Set("rating", movie.Rating)
+	d.Set("runtime_minutes", movie.Runtime)
+	d.Set("description", movie.Description)
+	d.Set("version", movie.Version)
 
 	return nil
 }
 
+// movieGenreNames returns the names of the genres of movie keyed by genre id
+func movieGenreNames(movie *client.Movie) map[string]string {
+	names := map[string]string{}
+	for _, genre := range movie.Genres {
+		names[genre.ID] = genre.Name
+	}
+	if len(names) == 0 && movie.Genre != nil {
+		names[movie.Genre.ID] = movie.Genre.Name
+	}
+	return names
+}
+
+func updateMovie(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	movieID := d.Id()
+	movie := movieFromConfig(d)
+	movie.MovieID = movieID
+	movie.Version = d.Get("version").(int)
+
+	err := apiClient.UpdateMovie(movie)
+	if errors.Is(err, client.ErrModified) {
+		return fmt.Errorf("movie %s was modified outside Terraform since it was last read, refresh and retry", movieID)
+	}
+	if err != nil {
+		return conflictError(fieldError(err, movieAttributes), "movie", "store_movies")
+	}
+
+	return readMovie(d, m)
+}
+
 func existMovie(d *schema.ResourceData, m interface{}) (bool, error) {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	movieID := d.Id()
 	_, err := apiClient.GetMovie(movieID)
@@ -163,7 +284,11 @@ func existMovie(d *schema.ResourceData, m interface{}) (bool, error) {
 }
 
 func deleteMovie(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
+
+	if err := checkDeletionProtection(d, "store_movies"); err != nil {
+		return err
+	}
 
 	movieID := d.Id()
 
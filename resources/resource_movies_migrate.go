@@ -0,0 +1,134 @@
+package provider
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// movieItemV0 is the schema of store_movies before version 1, where the genre was a nested block keyed by _id
+func movieItemV0() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"title": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"genre": {
+				Type:     schema.TypeList,
+				Required: true,
+				MaxItems: 1,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+							ForceNew: true,
+						},
+						"_id": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+					}},
+			},
+			"stock": {
+				Type:     schema.TypeInt,
+				Required: true,
+				ForceNew: true,
+			},
+			"daily_rate": {
+				Type:     schema.TypeFloat,
+				Required: true,
+				ForceNew: true,
+			},
+		},
+	}
+}
+
+// movieStateUpgradeV0 replaces the genre block of a version 0 state with the flat genre_id reference and its
+// genre_name mirror
+func movieStateUpgradeV0(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	genre, err := nestedBlock(rawState, "genre")
+	if err != nil {
+		return nil, err
+	}
+	delete(rawState, "genre")
+	rawState["genre_id"] = genre["_id"]
+	rawState["genre_name"] = genre["name"]
+	return rawState, nil
+}
+
+// movieItemV1 is the schema of store_movies before version 2, where a movie had a single genre referenced by
+// genre_id
+func movieItemV1() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"title": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"genre_id": {
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"genre_name": {
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"stock": {
+				Type:     schema.TypeInt,
+				Required: true,
+				ForceNew: true,
+			},
+			"daily_rate": {
+				Type:     schema.TypeFloat,
+				Required: true,
+				ForceNew: true,
+			},
+		},
+	}
+}
+
+// movieStateUpgradeV1 replaces the genre_id reference of a version 1 state with a genre_ids set holding it, and its
+// genre_name mirror with the genre_names map
+func movieStateUpgradeV1(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	genreIDs := []interface{}{}
+	genreNames := map[string]interface{}{}
+	if id, ok := rawState["genre_id"].(string); ok && id != "" {
+		genreIDs = append(genreIDs, id)
+		if name, ok := rawState["genre_name"].(string); ok {
+			genreNames[id] = name
+		}
+	}
+	delete(rawState, "genre_id")
+	delete(rawState, "genre_name")
+	rawState["genre_ids"] = genreIDs
+	rawState["genre_names"] = genreNames
+	return rawState, nil
+}
+
+// nestedBlock returns the attributes of the single element of the MaxItems: 1 block key in a raw state. A missing
+// or empty block is returned as an empty map
+func nestedBlock(rawState map[string]interface{}, key string) (map[string]interface{}, error) {
+	raw, ok := rawState[key]
+	if !ok || raw == nil {
+		return map[string]interface{}{}, nil
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Error upgrading state: expected %s to be a list, got %T", key, raw)
+	}
+	if len(list) == 0 || list[0] == nil {
+		return map[string]interface{}{}, nil
+	}
+	block, ok := list[0].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Error upgrading state: expected %s element to be an object, got %T", key, list[0])
+	}
+	return block, nil
+}
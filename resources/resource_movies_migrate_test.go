@@ -0,0 +1,64 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMovieStateUpgradeV0(t *testing.T) {
+	upgraded := testUpgradeState(t, MovieItem(), testV0StateAttributes(t, "store_movies"))
+
+	expected := map[string]interface{}{
+		"id":          "5ef199b9edf86a20de80b4a2",
+		"title":       "sawIII",
+		"genre_ids":   []interface{}{"5ee19f2a1363f7c0493761e9"},
+		"genre_names": map[string]interface{}{"5ee19f2a1363f7c0493761e9": "hhhhh"},
+		"stock":       float64(10),
+		"daily_rate":  12.1,
+	}
+	if !reflect.DeepEqual(upgraded, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, upgraded)
+	}
+}
+
+func TestMovieStateUpgradeV0_MissingGenre(t *testing.T) {
+	upgraded := testUpgradeState(t, MovieItem(), map[string]interface{}{
+		"id":         "5ef199b9edf86a20de80b4a2",
+		"title":      "sawIII",
+		"genre":      []interface{}{},
+		"stock":      float64(10),
+		"daily_rate": 12.1,
+	})
+
+	if ids := upgraded["genre_ids"].([]interface{}); len(ids) != 0 {
+		t.Fatalf("expected no genre_ids, got %#v", ids)
+	}
+}
+
+func TestMovieStateUpgradeV1(t *testing.T) {
+	upgraded, err := movieStateUpgradeV1(map[string]interface{}{
+		"id":                  "5ef199b9edf86a20de80b4a2",
+		"title":               "sawIII",
+		"genre_id":            "5ee19f2a1363f7c0493761e9",
+		"genre_name":          "hhhhh",
+		"stock":               float64(10),
+		"daily_rate":          12.1,
+		"deletion_protection": true,
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"id":                  "5ef199b9edf86a20de80b4a2",
+		"title":               "sawIII",
+		"genre_ids":           []interface{}{"5ee19f2a1363f7c0493761e9"},
+		"genre_names":         map[string]interface{}{"5ee19f2a1363f7c0493761e9": "hhhhh"},
+		"stock":               float64(10),
+		"daily_rate":          12.1,
+		"deletion_protection": true,
+	}
+	if !reflect.DeepEqual(upgraded, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, upgraded)
+	}
+}
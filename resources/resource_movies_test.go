@@ -1,17 +1,55 @@
 package provider
 
 import (
+	"encoding/json"
 	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
 	"regexp"
+	"strings"
 	"testing"
 
+	"github.com/hashicorp/terraform/configs/hcl2shim"
 	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
-	"github.com/milamice62/terraplugin/api/client"
+	"github.com/milamice62/terraplugin/api/server"
 )
 
+func init() {
+	resource.AddTestSweepers("store_movies", &resource.Sweeper{
+		Name:         "store_movies",
+		Dependencies: []string{"store_rentals"},
+		F:            sweepMovies,
+	})
+}
+
+// sweepMovies deletes the movies left behind by the acceptance tests
+func sweepMovies(region string) error {
+	apiClient, err := testEnvClient()
+	if err != nil {
+		return err
+	}
+	movies, err := apiClient.GetAllMovies()
+	if err != nil {
+		return fmt.Errorf("error listing movies: %s", err)
+	}
+	var errs []string
+	for _, movie := range movies {
+		if !strings.HasPrefix(movie.Title, testAccPrefix) {
+			continue
+		}
+		log.Printf("[INFO] sweeping movie %s (%s)", movie.MovieID, movie.Title)
+		if err := apiClient.DeleteMovie(movie.MovieID); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", movie.MovieID, err))
+		}
+	}
+	return sweepErrors("movies", errs)
+}
+
 func Test_Movie_Init(t *testing.T) {
-	resource.Test(t, resource.TestCase{
+	testAccTest(t, resource.TestCase{
 		PreCheck:     func() { testAccPreCheck(t) },
 		Providers:    testAccProviders,
 		CheckDestroy: testAccCheckMovieDestroy,
@@ -21,17 +59,15 @@ func Test_Movie_Init(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testAccCheckExampleMovieExists("store_movies.movie_example"),
 					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "title", "example"),
+						"store_movies.movie_example", "title", testAccPrefix+"example"),
 					resource.TestCheckResourceAttr(
 						"store_movies.movie_example", "stock", "100"),
 					resource.TestCheckResourceAttr(
 						"store_movies.movie_example", "daily_rate", "10"),
 					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "genre.#", "1"),
-					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "genre.0._id", "5ee19f2a1363f7c0493761e9"),
+						"store_movies.movie_example", "genre_ids.#", "1"),
 					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "genre.0.name", "hhhhh"),
+						"store_movies.movie_example", "genre_names.5ee19f2a1363f7c0493761e9", "hhhhh"),
 				),
 			},
 		},
@@ -39,7 +75,7 @@ func Test_Movie_Init(t *testing.T) {
 }
 
 func Test_Movie_Update(t *testing.T) {
-	resource.Test(t, resource.TestCase{
+	testAccTest(t, resource.TestCase{
 		PreCheck:     func() { testAccPreCheck(t) },
 		Providers:    testAccProviders,
 		CheckDestroy: testAccCheckMovieDestroy,
@@ -49,17 +85,15 @@ func Test_Movie_Update(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testAccCheckExampleMovieExists("store_movies.movie_example"),
 					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "title", "example"),
+						"store_movies.movie_example", "title", testAccPrefix+"example"),
 					resource.TestCheckResourceAttr(
 						"store_movies.movie_example", "stock", "100"),
 					resource.TestCheckResourceAttr(
 						"store_movies.movie_example", "daily_rate", "10"),
 					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "genre.#", "1"),
-					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "genre.0._id", "5ee19f2a1363f7c0493761e9"),
+						"store_movies.movie_example", "genre_ids.#", "1"),
 					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "genre.0.name", "hhhhh"),
+						"store_movies.movie_example", "genre_names.5ee19f2a1363f7c0493761e9", "hhhhh"),
 				),
 			},
 			{
@@ -67,17 +101,19 @@ func Test_Movie_Update(t *testing.T) {
 				Check: resource.ComposeTestCheckFunc(
 					testAccCheckExampleMovieExists("store_movies.movie_example"),
 					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "title", "example"),
+						"store_movies.movie_example", "title", testAccPrefix+"example"),
 					resource.TestCheckResourceAttr(
 						"store_movies.movie_example", "stock", "10"),
 					resource.TestCheckResourceAttr(
 						"store_movies.movie_example", "daily_rate", "11.1"),
 					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "genre.#", "1"),
+						"store_movies.movie_example", "genre_ids.#", "1"),
 					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "genre.0._id", "5ee05b02340e2cae12c1bea5"),
+						"store_movies.movie_example", "genre_names.5ee05b02340e2cae12c1bea5", "sci-fic"),
 					resource.TestCheckResourceAttr(
-						"store_movies.movie_example", "genre.0.name", "sci-fic"),
+						"store_movies.movie_example", "release_year", "2006"),
+					resource.TestCheckResourceAttr(
+						"store_movies.movie_example", "rating", "R"),
 				),
 			},
 		},
@@ -85,7 +121,7 @@ func Test_Movie_Update(t *testing.T) {
 }
 
 func testAccCheckMovieDestroy(s *terraform.State) error {
-	apiClient := testAccProvider.Meta().(*client.Client)
+	apiClient := testAccProvider.Meta().(*providerMeta).client
 
 	for _, rs := range s.RootModule().Resources {
 		if rs.Type != "store_movies" {
@@ -116,7 +152,7 @@ func testAccCheckExampleMovieExists(resource string) resource.TestCheckFunc {
 			return fmt.Errorf("No Record ID is set")
 		}
 		id := rs.Primary.ID
-		apiClient := testAccProvider.Meta().(*client.Client)
+		apiClient := testAccProvider.Meta().(*providerMeta).client
 		_, err := apiClient.GetMovie(id)
 		if err != nil {
 			return fmt.Errorf("error fetching movie with resource %s. %s", resource, err)
@@ -128,25 +164,228 @@ func testAccCheckExampleMovieExists(resource string) resource.TestCheckFunc {
 func testAccCheckMovieInit() string {
 	return fmt.Sprintf(`
 	resource "store_movies" "movie_example" {
-		title = "example"
-		genre {
-		  _id  = "5ee19f2a1363f7c0493761e9"
-		}
+		title      = "%sexample"
+		genre_ids  = ["5ee19f2a1363f7c0493761e9"]
 		stock      = 100
 		daily_rate = 10.00
 	  }
-`)
+`, testAccPrefix)
 }
 
 func testAccCheckMovieUpdate() string {
 	return fmt.Sprintf(`
 	resource "store_movies" "movie_example" {
-		title = "example"
-		genre {
-		  _id  = "5ee05b02340e2cae12c1bea5"
-		}
-		stock      = 10
-		daily_rate = 11.10
+		title        = "%sexample"
+		genre_ids    = ["5ee05b02340e2cae12c1bea5"]
+		stock        = 10
+		daily_rate   = 11.10
+		release_year = 2006
+		rating       = "R"
 	  }
-`)
+`, testAccPrefix)
+}
+
+func TestMovieCustomizeDiff(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres: []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "hhhhh"}},
+	})
+	config := func(genreID string) map[string]interface{} {
+		return map[string]interface{}{
+			"title":      "example",
+			"genre_ids":  []interface{}{genreID},
+			"stock":      100,
+			"daily_rate": 10.0,
+		}
+	}
+
+	if _, err := testPlan(MovieItem(), config("5ee19f2a1363f7c0493761e9"), meta); err != nil {
+		t.Fatalf("expected plan with an existing genre to succeed, got %s", err)
+	}
+	if _, err := testPlan(MovieItem(), config(hcl2shim.UnknownVariableValue), meta); err != nil {
+		t.Fatalf("expected plan with an unknown genre to succeed, got %s", err)
+	}
+
+	_, err := testPlan(MovieItem(), config("5ee05b02340e2cae12c1bea5"), meta)
+	if err == nil || !strings.Contains(err.Error(), `genre "5ee05b02340e2cae12c1bea5" does not exist`) {
+		t.Fatalf("expected plan with a missing genre to fail, got %v", err)
+	}
+
+	meta.skipReferenceValidation = true
+	if _, err := testPlan(MovieItem(), config("5ee05b02340e2cae12c1bea5"), meta); err != nil {
+		t.Fatalf("expected plan to skip reference validation, got %s", err)
+	}
+}
+
+func TestCreateMovie_ResponseLost(t *testing.T) {
+	service := testStoreService(t, &server.Dataset{
+		Genres: []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "hhhhh"}},
+	})
+	handler := service.Handler()
+	dropped := false
+	meta := testMetaFor(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" && !dropped {
+			// The server commits the movie, but the connection drops before the response reaches the client
+			dropped = true
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			conn, _, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			conn.Close()
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+
+	d := schema.TestResourceDataRaw(t, MovieItem().Schema, map[string]interface{}{
+		"title":      "example",
+		"genre_ids":  []interface{}{"5ee19f2a1363f7c0493761e9"},
+		"stock":      100,
+		"daily_rate": 10.0,
+	})
+	if err := createMovie(d, meta); err != nil {
+		t.Fatalf("expected the create to be retried, got %s", err)
+	}
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/api/movies", nil)
+	req.Header.Set("x-auth-token", "token")
+	handler.ServeHTTP(rec, req)
+	var movies []server.Movie
+	if err := json.NewDecoder(rec.Body).Decode(&movies); err != nil {
+		t.Fatal(err)
+	}
+	if len(movies) != 1 {
+		t.Fatalf("expected exactly one movie to be created, got %d", len(movies))
+	}
+	if d.Id() != movies[0].ID {
+		t.Fatalf("expected resource ID %s to match the created movie, got %s", movies[0].ID, d.Id())
+	}
+}
+
+func TestCreateMovie_FieldErrors(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres: []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "hhhhh"}},
+	})
+
+	d := schema.TestResourceDataRaw(t, MovieItem().Schema, map[string]interface{}{
+		"title":      "saw",
+		"genre_ids":  []interface{}{"5ee19f2a1363f7c0493761e9"},
+		"stock":      256,
+		"daily_rate": 10.0,
+	})
+	err := createMovie(d, meta)
+	if err == nil {
+		t.Fatal("expected the store to reject the movie")
+	}
+	for _, want := range []string{`title: "title" length must be at least 5`, `stock: "stock" must be between 0 and 255`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected the error to contain %q, got %q", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "numberInStock") {
+		t.Errorf("expected the error to name the argument rather than the API field, got %q", err)
+	}
+}
+
+func TestCreateMovie_AdoptAmbiguous(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres: []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "hhhhh"}},
+		Movies: []server.Movie{
+			{ID: "5ee6fe17de7e8d5eb0ae60ea", Title: "example", Genre: server.Genre{ID: "5ee19f2a1363f7c0493761e9"}},
+			{ID: "5ef199b9edf86a20de80b4a2", Title: "example", Genre: server.Genre{ID: "5ee19f2a1363f7c0493761e9"}},
+		},
+	})
+
+	d := schema.TestResourceDataRaw(t, MovieItem().Schema, map[string]interface{}{
+		"title":          "example",
+		"genre_ids":      []interface{}{"5ee19f2a1363f7c0493761e9"},
+		"stock":          100,
+		"daily_rate":     10.0,
+		"adopt_existing": true,
+	})
+	err := createMovie(d, meta)
+	if err == nil || !strings.Contains(err.Error(), "2 records have title \"example\"") {
+		t.Fatalf("expected adoption of an ambiguous title to fail, got %v", err)
+	}
+	if d.Id() != "" {
+		t.Fatalf("expected no ID to be set, got %q", d.Id())
+	}
+}
+
+func TestUpdateMovie_InPlace(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres: []server.Genre{{ID: "5ee05b02340e2cae12c1bea5", Name: "sci-fic"}, {ID: "5ee19f2a1363f7c0493761e9", Name: "hhhhh"}},
+		Movies: []server.Movie{{ID: "5ee6fe17de7e8d5eb0ae60ea", Title: "sawIII", Genre: server.Genre{ID: "5ee19f2a1363f7c0493761e9"}, NumberInStock: 10, DailyRentalRate: 12.1}},
+	})
+
+	old := schema.TestResourceDataRaw(t, MovieItem().Schema, map[string]interface{}{})
+	old.SetId("5ee6fe17de7e8d5eb0ae60ea")
+	if err := readMovie(old, meta); err != nil {
+		t.Fatal(err)
+	}
+	config := map[string]interface{}{
+		"title":           "sawIV",
+		"genre_ids":       []interface{}{"5ee19f2a1363f7c0493761e9", "5ee05b02340e2cae12c1bea5"},
+		"stock":           5,
+		"daily_rate":      3.5,
+		"release_year":    2007,
+		"rating":          "R",
+		"runtime_minutes": 93,
+		"description":     "Jigsaw is dead",
+	}
+	diff, err := MovieItem().Diff(old.State(), terraform.NewResourceConfigRaw(config), meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.RequiresNew() {
+		t.Fatalf("expected the movie to be updated in place, got %#v", diff)
+	}
+
+	d := schema.TestResourceDataRaw(t, MovieItem().Schema, config)
+	d.SetId("5ee6fe17de7e8d5eb0ae60ea")
+	d.Set("version", 0)
+	if err := updateMovie(d, meta); err != nil {
+		t.Fatalf("error updating movie: %s", err)
+	}
+
+	movie, err := meta.client.GetMovie("5ee6fe17de7e8d5eb0ae60ea")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if movie.Title != "sawIV" || movie.Stock != 5 || movie.ReleaseYear != 2007 || movie.Rating != "R" || movie.Runtime != 93 {
+		t.Errorf("expected the movie to be updated, got %+v", movie)
+	}
+	// The lowest genre ID is sent first, as the primary genre
+	if movie.Genre == nil || movie.Genre.ID != "5ee05b02340e2cae12c1bea5" || len(movie.Genres) != 2 {
+		t.Errorf("expected both genres with the lowest ID as primary, got %+v", movie.Genres)
+	}
+	names := d.Get("genre_names").(map[string]interface{})
+	if names["5ee05b02340e2cae12c1bea5"] != "sci-fic" || names["5ee19f2a1363f7c0493761e9"] != "hhhhh" {
+		t.Errorf("expected genre_names to be read back, got %#v", names)
+	}
+
+	d.Set("version", 0)
+	if err := updateMovie(d, meta); err == nil || !strings.Contains(err.Error(), "modified outside Terraform") {
+		t.Errorf("expected an update from a stale version to fail, got %v", err)
+	}
+}
+
+func TestMovieItem_Validation(t *testing.T) {
+	for name, c := range map[string]struct {
+		key   string
+		value interface{}
+	}{
+		"early year":   {"release_year", 1887},
+		"late year":    {"release_year", 3000},
+		"bad rating":   {"rating", "X"},
+		"zero runtime": {"runtime_minutes", 0},
+	} {
+		s := MovieItem().Schema[c.key]
+		_, errs := s.ValidateFunc(c.value, c.key)
+		if len(errs) == 0 {
+			t.Errorf("%s: expected %v to be rejected", name, c.value)
+		}
+	}
 }
@@ -0,0 +1,177 @@
+package provider
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/milamice62/terraplugin/api/client"
+)
+
+// rentalBatchAttributes maps the fields of a rental batch in the API to the attributes of store_rental_batch
+var rentalBatchAttributes = map[string]string{
+	"customerId": "customer_id",
+	"movieIds":   "movie_ids",
+}
+
+// RentalBatchItem opens a rental of each of a set of movies for one customer in a single request, which the server
+// applies as a whole. Its ID is the comma-separated IDs of the rentals
+func RentalBatchItem() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"customer_id": {
+				Type:        schema.TypeString,
+				Required:    true,
+				Description: "The id of the customer",
+				ForceNew:    true,
+			},
+			"movie_ids": {
+				Type:        schema.TypeSet,
+				Required:    true,
+				Description: "The ids of the movies to rent, one copy each",
+				ForceNew:    true,
+				MinItems:    1,
+				MaxItems:    100,
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"rentals": {
+				Type:        schema.TypeList,
+				Computed:    true,
+				Description: "The rentals that were opened, ordered by movie id",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"id":       {Type: schema.TypeString, Computed: true},
+						"movie_id": {Type: schema.TypeString, Computed: true},
+						"dateout":  {Type: schema.TypeString, Computed: true, Description: "The date and time of checkout"},
+					},
+				},
+			},
+		},
+		CustomizeDiff: customizeRentalBatchDiff,
+		Create:        createRentalBatch,
+		Read:          readRentalBatch,
+		Delete:        deleteRentalBatch,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+	}
+}
+
+// customizeRentalBatchDiff checks at plan time that the customer and movies the batch references exist and that every
+// movie has a copy in stock to rent out
+func customizeRentalBatchDiff(d *schema.ResourceDiff, m interface{}) error {
+	meta := m.(*providerMeta)
+	err := checkReference(d, meta, "customer_id", "customer", func(id string) error {
+		_, err := meta.client.GetCustomer(id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	var movies []*client.Movie
+	err = checkReferences(d, meta, "movie_ids", "movie", func(id string) error {
+		movie, err := meta.client.GetMovie(id)
+		if err == nil {
+			movies = append(movies, movie)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, movie := range movies {
+		if movie.Stock < 1 {
+			return fmt.Errorf("movie_ids: movie %q (%s) is not in stock", movie.Title, movie.MovieID)
+		}
+	}
+	return nil
+}
+
+func createRentalBatch(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	movieIDs := []string{}
+	for _, id := range d.Get("movie_ids").(*schema.Set).List() {
+		movieIDs = append(movieIDs, id.(string))
+	}
+	sort.Strings(movieIDs)
+
+	rentals, err := apiClient.NewRentals(d.Get("customer_id").(string), movieIDs)
+	if err != nil {
+		return fieldError(err, rentalBatchAttributes)
+	}
+
+	rentalIDs := make([]string, 0, len(rentals))
+	for _, rental := range rentals {
+		rentalIDs = append(rentalIDs, rental.RentalID)
+	}
+	d.SetId(strings.Join(rentalIDs, ","))
+	return setRentalBatchAttributes(rentals, d)
+}
+
+// readRentalBatch drops the rentals that were closed outside Terraform, so that the batch is opened again. The batch
+// is gone once all of them are
+func readRentalBatch(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	var rentals []client.Rental
+	var rentalIDs []string
+	for _, rentalID := range strings.Split(d.Id(), ",") {
+		rental, err := apiClient.GetRental(rentalID)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				continue
+			}
+			return fmt.Errorf("error finding rental with id %s: %s", rentalID, err)
+		}
+		rentals = append(rentals, *rental)
+		rentalIDs = append(rentalIDs, rental.RentalID)
+	}
+	if len(rentals) == 0 {
+		d.SetId("")
+		return nil
+	}
+
+	d.SetId(strings.Join(rentalIDs, ","))
+	return setRentalBatchAttributes(rentals, d)
+}
+
+// setRentalBatchAttributes sets the customer, movies and rentals of a batch from the rentals it opened
+func setRentalBatchAttributes(rentals []client.Rental, d *schema.ResourceData) error {
+	movieIDs := make([]interface{}, 0, len(rentals))
+	items := make([]interface{}, 0, len(rentals))
+	for _, rental := range rentals {
+		item := map[string]interface{}{
+			"id":      rental.RentalID,
+			"dateout": rental.DateOut,
+		}
+		if rental.Customer != nil {
+			if err := d.Set("customer_id", rental.Customer.CustomerID); err != nil {
+				return err
+			}
+		}
+		if rental.Movie != nil {
+			item["movie_id"] = rental.Movie.MovieID
+			movieIDs = append(movieIDs, rental.Movie.MovieID)
+		}
+		items = append(items, item)
+	}
+	if err := d.Set("movie_ids", movieIDs); err != nil {
+		return err
+	}
+	return d.Set("rentals", items)
+}
+
+func deleteRentalBatch(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	for _, rentalID := range strings.Split(d.Id(), ",") {
+		err := apiClient.DeleteRental(rentalID)
+		if err != nil && !strings.Contains(err.Error(), "not found") {
+			return err
+		}
+	}
+	d.SetId("")
+	return nil
+}
@@ -0,0 +1,66 @@
+package provider
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/milamice62/terraplugin/api/server"
+)
+
+func TestRentalBatch(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres:    []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "hhhhh"}},
+		Customers: []server.Customer{{ID: "5ee998a7073cfb0d8696fec1", Name: "foobar", Phone: "123456789"}},
+		Movies: []server.Movie{
+			{ID: "5ee6fe17de7e8d5eb0ae60ea", Title: "sawIII", Genre: server.Genre{ID: "5ee19f2a1363f7c0493761e9"}, NumberInStock: 1},
+			{ID: "5ef199b9edf86a20de80b4a2", Title: "sawIV", Genre: server.Genre{ID: "5ee19f2a1363f7c0493761e9"}, NumberInStock: 1},
+		},
+	})
+	config := map[string]interface{}{
+		"customer_id": "5ee998a7073cfb0d8696fec1",
+		"movie_ids":   []interface{}{"5ef199b9edf86a20de80b4a2", "5ee6fe17de7e8d5eb0ae60ea"},
+	}
+
+	d := schema.TestResourceDataRaw(t, RentalBatchItem().Schema, config)
+	if err := createRentalBatch(d, meta); err != nil {
+		t.Fatalf("error creating rental batch: %s", err)
+	}
+	if got := strings.Split(d.Id(), ","); len(got) != 2 {
+		t.Fatalf("expected the ID to list both rentals, got %q", d.Id())
+	}
+	if d.Get("rentals.0.movie_id") != "5ee6fe17de7e8d5eb0ae60ea" || d.Get("rentals.1.dateout") == "" {
+		t.Errorf("expected the rentals ordered by movie id with their dateout, got %v", d.Get("rentals"))
+	}
+
+	// Both movies are now out of stock, so a second batch fails as a whole
+	again := schema.TestResourceDataRaw(t, RentalBatchItem().Schema, config)
+	if err := createRentalBatch(again, meta); err == nil || !strings.Contains(err.Error(), "not in stock") {
+		t.Fatalf("expected the second batch to fail, got %v", err)
+	}
+	if _, err := testPlan(RentalBatchItem(), config, meta); err == nil || !strings.Contains(err.Error(), "is not in stock") {
+		t.Errorf("expected the plan of the second batch to fail, got %v", err)
+	}
+
+	// A rental closed outside Terraform drops out of the batch
+	if err := meta.client.DeleteRental(d.Get("rentals.0.id").(string)); err != nil {
+		t.Fatal(err)
+	}
+	if err := readRentalBatch(d, meta); err != nil {
+		t.Fatal(err)
+	}
+	if d.Get("movie_ids").(*schema.Set).Len() != 1 || strings.Contains(d.Id(), ",") {
+		t.Errorf("expected only the open rental to be left, got %q and %v", d.Id(), d.Get("movie_ids"))
+	}
+
+	if err := deleteRentalBatch(d, meta); err != nil {
+		t.Fatalf("error deleting rental batch: %s", err)
+	}
+	rentals, err := meta.client.GetAllRentals()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rentals) != 0 {
+		t.Errorf("expected every rental to be closed, got %d", len(rentals))
+	}
+}
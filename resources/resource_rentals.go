@@ -9,8 +9,13 @@ import (
 	"github.com/milamice62/terraplugin/api/client"
 )
 
+// rentalAttributes maps the fields of a new rental in the API to the attributes of store_rentals
+var rentalAttributes = map[string]string{
+	"customerId": "customer_id",
+	"movieId":    "movie_id",
+}
+
 func RentalItem() *schema.Resource {
-	fmt.Print()
 	return &schema.Resource{
 		Schema: map[string]*schema.Schema{
 			"dateout": {
@@ -19,182 +24,126 @@ func RentalItem() *schema.Resource {
 				Description: "The date and time of checkout",
 				ForceNew:    true,
 			},
-			"customer": {
-				Type:        schema.TypeList,
+			"customer_id": {
+				Type:        schema.TypeString,
 				Required:    true,
-				Description: "The customer information",
-				MaxItems:    1,
+				Description: "The id of the customer",
 				ForceNew:    true,
-				Elem: &schema.Resource{
-					Schema: map[string]*schema.Schema{
-						"name": {
-							Type:        schema.TypeString,
-							Computed:    true,
-							Description: "The name of the customer",
-							ForceNew:    true,
-						},
-						"isgold": {
-							Type:        schema.TypeBool,
-							Computed:    true,
-							Description: "The status of the customer",
-							ForceNew:    true,
-						},
-						"phone": {
-							Type:        schema.TypeString,
-							Computed:    true,
-							Description: "The phone number of customer",
-							ForceNew:    true,
-						},
-						"id": {
-							Type:        schema.TypeString,
-							Required:    true,
-							Description: "The id of the customer",
-							ForceNew:    true,
-						},
-					}},
 			},
-			"movie": {
-				Type:        schema.TypeList,
+			"customer_name": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The name of the customer",
+			},
+			"customer_is_gold": {
+				Type:        schema.TypeBool,
+				Computed:    true,
+				Description: "The status of the customer",
+			},
+			"customer_phone": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The phone number of customer",
+			},
+			"movie_id": {
+				Type:        schema.TypeString,
 				Required:    true,
-				Description: "The movie information",
-				MaxItems:    1,
+				Description: "The id of the movie",
 				ForceNew:    true,
-				Elem: &schema.Resource{
-					Schema: map[string]*schema.Schema{
-						"dailyrentalrate": {
-							Type:        schema.TypeInt,
-							Computed:    true,
-							Description: "The daily rental rate of the movie",
-							ForceNew:    true,
-						},
-						"title": {
-							Type:        schema.TypeString,
-							Computed:    true,
-							Description: "The title of the movie",
-							ForceNew:    true,
-						},
-						"id": {
-							Type:        schema.TypeString,
-							Required:    true,
-							Description: "The id of the movie",
-							ForceNew:    true,
-						},
-					}},
+			},
+			"movie_title": {
+				Type:        schema.TypeString,
+				Computed:    true,
+				Description: "The title of the movie",
+			},
+			"movie_daily_rate": {
+				Type:        schema.TypeFloat,
+				Computed:    true,
+				Description: "The daily rental rate of the movie",
+			},
+		},
+		SchemaVersion: 1,
+		StateUpgraders: []schema.StateUpgrader{
+			{
+				Version: 0,
+				Type:    rentalItemV0().CoreConfigSchema().ImpliedType(),
+				Upgrade: rentalStateUpgradeV0,
 			},
 		},
-		Create: createRental,
-		Read:   readRental,
-		Delete: deleteRental,
-		Exists: existRental,
+		CustomizeDiff: customizeRentalDiff,
+		Create:        createRental,
+		Read:          readRental,
+		Delete:        deleteRental,
+		Exists:        existRental,
 		Importer: &schema.ResourceImporter{
 			State: schema.ImportStatePassthrough,
 		},
 	}
 }
 
-func expandCustomer(customer []interface{}) (*client.Customer, error) {
-	cus := &client.Customer{}
-
-	if len(customer) == 0 || customer[0] == nil {
-		return nil, fmt.Errorf("Customer parameters should be specified, but get %v", customer)
-	}
-
-	in, ok := customer[0].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("Error fetching customer element: %v", in)
+// customizeRentalDiff checks at plan time that the customer and movie the rental references exist and that the
+// movie has a copy in stock to rent out
+func customizeRentalDiff(d *schema.ResourceDiff, m interface{}) error {
+	meta := m.(*providerMeta)
+	err := checkReference(d, meta, "customer_id", "customer", func(id string) error {
+		_, err := meta.client.GetCustomer(id)
+		return err
+	})
+	if err != nil {
+		return err
 	}
-
-	cus.CustomerID = in["id"].(string)
-
-	return cus, nil
-}
-
-func flattenCustomer(customer *client.Customer, d *schema.ResourceData) []interface{} {
-	m := make(map[string]interface{})
-	m["id"] = customer.CustomerID
-	m["isgold"] = customer.IsGold
-	m["name"] = customer.Name
-	m["phone"] = customer.Phone
-
-	return []interface{}{m}
-}
-
-func expandMovie(movie []interface{}) (*client.Movie, error) {
-	mov := &client.Movie{}
-
-	if len(movie) == 0 || movie[0] == nil {
-		return nil, fmt.Errorf("Movie parameters should be specified, but get %v", movie)
+	var movie *client.Movie
+	err = checkReference(d, meta, "movie_id", "movie", func(id string) (err error) {
+		movie, err = meta.client.GetMovie(id)
+		return err
+	})
+	if err != nil {
+		return err
 	}
-
-	in, ok := movie[0].(map[string]interface{})
-	if !ok {
-		return nil, fmt.Errorf("Error fetching movie element: %v", in)
+	if movie != nil && movie.Stock < 1 {
+		return fmt.Errorf("movie_id: movie %q (%s) is not in stock", movie.Title, movie.MovieID)
 	}
-
-	mov.MovieID = in["id"].(string)
-
-	return mov, nil
-}
-
-func flattenMovie(movie *client.Movie, d *schema.ResourceData) []interface{} {
-	m := make(map[string]interface{})
-	m["id"] = movie.MovieID
-	m["dailyrentalrate"] = movie.Rate
-	m["title"] = movie.Title
-
-	return []interface{}{m}
+	return nil
 }
 
-func updateRental(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
-
-	rentalID := d.Id()
-	rental, err := apiClient.GetRental(rentalID)
-	if err != nil {
-		if strings.Contains(err.Error(), "not found") {
-			d.SetId("")
-		} else {
-			return fmt.Errorf("error finding rental with id %s", rentalID)
+// setRentalAttributes mirrors the customer and movie embedded in rental into the computed attributes of d
+func setRentalAttributes(rental *client.Rental, d *schema.ResourceData) error {
+	attributes := map[string]interface{}{
+		"dateout": rental.DateOut,
+	}
+	if rental.Customer != nil {
+		attributes["customer_id"] = rental.Customer.CustomerID
+		attributes["customer_name"] = rental.Customer.Name
+		attributes["customer_is_gold"] = rental.Customer.IsGold
+		attributes["customer_phone"] = rental.Customer.Phone
+	}
+	if rental.Movie != nil {
+		attributes["movie_id"] = rental.Movie.MovieID
+		attributes["movie_title"] = rental.Movie.Title
+		attributes["movie_daily_rate"] = rental.Movie.Rate
+	}
+	for key, value := range attributes {
+		if err := d.Set(key, value); err != nil {
+			return err
 		}
 	}
-
-	if d.HasChange("rentalid") {
-		p := d.Get("rentalid").(string)
-		rental.RentalID = p
-	}
-
-	err = apiClient.UpdateRental(rental)
-	if err != nil {
-		return err
-	}
-
-	return readRental(d, m)
+	return nil
 }
 
 func createRental(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	rental := client.Rental{}
 
-	customer, err := expandCustomer(d.Get("customer").([]interface{}))
-	if err != nil {
-		fmt.Printf("%v", err)
-	}
-
-	movie, err := expandMovie(d.Get("movie").([]interface{}))
-	if err != nil {
-		fmt.Printf("%v", err)
-	}
-
 	rentalID := client.RentalID{
-		CustomerID: customer.CustomerID,
-		MovieID:    movie.MovieID,
+		CustomerID: d.Get("customer_id").(string),
+		MovieID:    d.Get("movie_id").(string),
 	}
 
 	resBody, err := apiClient.NewRental(&rentalID)
 
 	if err != nil {
-		return err
+		return fieldError(err, rentalAttributes)
 	}
 
 	err = json.NewDecoder(*resBody).Decode(&rental)
@@ -203,53 +152,28 @@ func createRental(d *schema.ResourceData, m interface{}) error {
 	}
 
 	d.SetId(rental.RentalID)
-	customerRes := flattenCustomer(rental.Customer, d)
-	movieRes := flattenMovie(rental.Movie, d)
-
-	d.SetId(rental.RentalID)
-	if d.Set("customer", customerRes); err != nil {
-		return err
-	}
-	if d.Set("movie", movieRes); err != nil {
-		return err
-	}
-	if d.Set("dateout", rental.DateOut); err != nil {
-		return err
-	}
-	return nil
+	return setRentalAttributes(&rental, d)
 }
 
 func readRental(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	rentalID := d.Id()
 	rental, err := apiClient.GetRental(rentalID)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			d.SetId("")
-		} else {
-			return fmt.Errorf("error finding rental with id %s", rentalID)
+			return nil
 		}
+		return fmt.Errorf("error finding rental with id %s: %s", rentalID, err)
 	}
 
-	customer := flattenCustomer(rental.Customer, d)
-	movie := flattenMovie(rental.Movie, d)
-
 	d.SetId(rental.RentalID)
-	if d.Set("customer", customer); err != nil {
-		return err
-	}
-	if d.Set("movie", movie); err != nil {
-		return err
-	}
-	if d.Set("dateout", rental.DateOut); err != nil {
-		return err
-	}
-	return nil
+	return setRentalAttributes(rental, d)
 }
 
 func existRental(d *schema.ResourceData, m interface{}) (bool, error) {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	rentalID := d.Id()
 	_, err := apiClient.GetRental(rentalID)
@@ -264,7 +188,7 @@ func existRental(d *schema.ResourceData, m interface{}) (bool, error) {
 }
 
 func deleteRental(d *schema.ResourceData, m interface{}) error {
-	apiClient := m.(*client.Client)
+	apiClient := m.(*providerMeta).client
 
 	rentalID := d.Id()
 
@@ -0,0 +1,127 @@
+package provider
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// rentalItemV0 is the schema of store_rentals before version 1, where the customer and movie were nested blocks
+// keyed by id and the movie daily rental rate was an integer
+func rentalItemV0() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"dateout": {
+				Type:     schema.TypeString,
+				Computed: true,
+				ForceNew: true,
+			},
+			"customer": {
+				Type:     schema.TypeList,
+				Required: true,
+				MaxItems: 1,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": {
+							Type:     schema.TypeString,
+							Computed: true,
+							ForceNew: true,
+						},
+						"isgold": {
+							Type:     schema.TypeBool,
+							Computed: true,
+							ForceNew: true,
+						},
+						"phone": {
+							Type:     schema.TypeString,
+							Computed: true,
+							ForceNew: true,
+						},
+						"id": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+					}},
+			},
+			"movie": {
+				Type:     schema.TypeList,
+				Required: true,
+				MaxItems: 1,
+				ForceNew: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"dailyrentalrate": {
+							Type:     schema.TypeInt,
+							Computed: true,
+							ForceNew: true,
+						},
+						"title": {
+							Type:     schema.TypeString,
+							Computed: true,
+							ForceNew: true,
+						},
+						"id": {
+							Type:     schema.TypeString,
+							Required: true,
+							ForceNew: true,
+						},
+					}},
+			},
+		},
+	}
+}
+
+// rentalStateUpgradeV0 replaces the customer and movie blocks of a version 0 state with flat customer_id and
+// movie_id references and their mirrors, converting the daily rental rate to a float
+func rentalStateUpgradeV0(rawState map[string]interface{}, meta interface{}) (map[string]interface{}, error) {
+	customer, err := nestedBlock(rawState, "customer")
+	if err != nil {
+		return nil, err
+	}
+	movie, err := nestedBlock(rawState, "movie")
+	if err != nil {
+		return nil, err
+	}
+	rate, err := upgradeFloat(movie["dailyrentalrate"])
+	if err != nil {
+		return nil, fmt.Errorf("Error upgrading movie.dailyrentalrate: %s", err)
+	}
+
+	delete(rawState, "customer")
+	delete(rawState, "movie")
+	rawState["customer_id"] = customer["id"]
+	rawState["customer_name"] = customer["name"]
+	rawState["customer_is_gold"] = customer["isgold"]
+	rawState["customer_phone"] = customer["phone"]
+	rawState["movie_id"] = movie["id"]
+	rawState["movie_title"] = movie["title"]
+	rawState["movie_daily_rate"] = rate
+	return rawState, nil
+}
+
+// upgradeFloat converts a number read from a raw state, which may have been recorded as an integer or a string,
+// to a float64. A missing value stays missing
+func upgradeFloat(v interface{}) (interface{}, error) {
+	switch value := v.(type) {
+	case nil:
+		return nil, nil
+	case float64:
+		return value, nil
+	case int:
+		return float64(value), nil
+	case string:
+		if value == "" {
+			return nil, nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, err
+		}
+		return f, nil
+	default:
+		return nil, fmt.Errorf("expected a number, got %T", v)
+	}
+}
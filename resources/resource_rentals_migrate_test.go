@@ -0,0 +1,42 @@
+package provider
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRentalStateUpgradeV0(t *testing.T) {
+	upgraded := testUpgradeState(t, RentalItem(), testV0StateAttributes(t, "store_rentals"))
+
+	expected := map[string]interface{}{
+		"id":               "5ee99a19073cfb0d8696fec2",
+		"dateout":          "2020-06-17T04:21:13.353Z",
+		"customer_id":      "5ee998a7073cfb0d8696fec1",
+		"customer_name":    "foobar",
+		"customer_is_gold": true,
+		"customer_phone":   "123456789",
+		"movie_id":         "5ee6fe17de7e8d5eb0ae60ea",
+		"movie_title":      "sawIII",
+		"movie_daily_rate": float64(12),
+	}
+	if !reflect.DeepEqual(upgraded, expected) {
+		t.Fatalf("expected %#v, got %#v", expected, upgraded)
+	}
+}
+
+func TestRentalStateUpgradeV0_StringRate(t *testing.T) {
+	upgraded := testUpgradeState(t, RentalItem(), map[string]interface{}{
+		"id":      "5ee99a19073cfb0d8696fec2",
+		"dateout": "2020-06-17T04:21:13.353Z",
+		"customer": []interface{}{
+			map[string]interface{}{"id": "5ee998a7073cfb0d8696fec1"},
+		},
+		"movie": []interface{}{
+			map[string]interface{}{"id": "5ee6fe17de7e8d5eb0ae60ea", "dailyrentalrate": "12.1"},
+		},
+	})
+
+	if upgraded["movie_daily_rate"] != 12.1 {
+		t.Fatalf("expected movie_daily_rate 12.1, got %#v", upgraded["movie_daily_rate"])
+	}
+}
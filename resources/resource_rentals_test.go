@@ -0,0 +1,86 @@
+package provider
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/milamice62/terraplugin/api/server"
+)
+
+func init() {
+	resource.AddTestSweepers("store_rentals", &resource.Sweeper{
+		Name: "store_rentals",
+		F:    sweepRentals,
+	})
+}
+
+// sweepRentals deletes the rentals of the customers and movies left behind by the acceptance tests, so that the
+// customers and movies can be swept after them
+func sweepRentals(region string) error {
+	apiClient, err := testEnvClient()
+	if err != nil {
+		return err
+	}
+	rentals, err := apiClient.GetAllRentals()
+	if err != nil {
+		return fmt.Errorf("error listing rentals: %s", err)
+	}
+	var errs []string
+	for _, rental := range rentals {
+		if !(rental.Customer != nil && strings.HasPrefix(rental.Customer.Name, testAccPrefix)) &&
+			!(rental.Movie != nil && strings.HasPrefix(rental.Movie.Title, testAccPrefix)) {
+			continue
+		}
+		log.Printf("[INFO] sweeping rental %s", rental.RentalID)
+		if err := apiClient.DeleteRental(rental.RentalID); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", rental.RentalID, err))
+		}
+	}
+	return sweepErrors("rentals", errs)
+}
+
+func TestRentalCustomizeDiff(t *testing.T) {
+	meta := testStoreMeta(t, &server.Dataset{
+		Genres:    []server.Genre{{ID: "5ee19f2a1363f7c0493761e9", Name: "hhhhh"}},
+		Customers: []server.Customer{{ID: "5ee998a7073cfb0d8696fec1", Name: "foobar", Phone: "123456789"}},
+		Movies: []server.Movie{
+			{ID: "5ee6fe17de7e8d5eb0ae60ea", Title: "sawIII", Genre: server.Genre{ID: "5ee19f2a1363f7c0493761e9"}, NumberInStock: 1},
+			{ID: "5ef199b9edf86a20de80b4a2", Title: "sawIV", Genre: server.Genre{ID: "5ee19f2a1363f7c0493761e9"}},
+		},
+	})
+	config := func(customerID, movieID string) map[string]interface{} {
+		return map[string]interface{}{
+			"customer_id": customerID,
+			"movie_id":    movieID,
+		}
+	}
+
+	cases := []struct {
+		name       string
+		customerID string
+		movieID    string
+		expectErr  string
+	}{
+		{"valid", "5ee998a7073cfb0d8696fec1", "5ee6fe17de7e8d5eb0ae60ea", ""},
+		{"missing customer", "5ee99a19073cfb0d8696fec2", "5ee6fe17de7e8d5eb0ae60ea", `customer "5ee99a19073cfb0d8696fec2" does not exist`},
+		{"missing movie", "5ee998a7073cfb0d8696fec1", "5ee99a19073cfb0d8696fec2", `movie "5ee99a19073cfb0d8696fec2" does not exist`},
+		{"out of stock", "5ee998a7073cfb0d8696fec1", "5ef199b9edf86a20de80b4a2", `movie "sawIV" (5ef199b9edf86a20de80b4a2) is not in stock`},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := testPlan(RentalItem(), config(tc.customerID, tc.movieID), meta)
+			if tc.expectErr == "" {
+				if err != nil {
+					t.Fatalf("expected plan to succeed, got %s", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tc.expectErr) {
+				t.Fatalf("expected error containing %q, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
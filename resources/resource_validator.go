@@ -2,7 +2,13 @@ package provider
 
 import (
 	"fmt"
+	"net/mail"
+	"net/url"
 	"regexp"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
 )
 
 type Model struct {
@@ -25,6 +31,107 @@ func validateName(v interface{}, k string) (ws []string, es []error) {
 	return warns, errs
 }
 
+func validateCustomerName(v interface{}, k string) (ws []string, es []error) {
+	var errs []error
+	var warns []string
+	value, ok := v.(string)
+	if !ok {
+		errs = append(errs, fmt.Errorf("Expected value to be string"))
+		return warns, errs
+	}
+	if len(value) < 5 || len(value) > 50 {
+		errs = append(errs, fmt.Errorf("Expected name to be 5 to 50 characters long. Got %q", value))
+		return warns, errs
+	}
+	if strings.TrimSpace(value) != value {
+		errs = append(errs, fmt.Errorf("Name cannot start or end with whitespace. Got %q", value))
+		return warns, errs
+	}
+	return warns, errs
+}
+
+func validateEmail(v interface{}, k string) (ws []string, es []error) {
+	var errs []error
+	var warns []string
+	value, ok := v.(string)
+	if !ok {
+		errs = append(errs, fmt.Errorf("Expected value to be string"))
+		return warns, errs
+	}
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != value {
+		errs = append(errs, fmt.Errorf("Expected a plain email address such as jane@example.com. Got %s", value))
+		return warns, errs
+	}
+	return warns, errs
+}
+
+func validateDate(v interface{}, k string) (ws []string, es []error) {
+	var errs []error
+	var warns []string
+	value, ok := v.(string)
+	if !ok {
+		errs = append(errs, fmt.Errorf("Expected value to be string"))
+		return warns, errs
+	}
+	if _, err := time.Parse("2006-01-02", value); err != nil {
+		errs = append(errs, fmt.Errorf("Expected a date in YYYY-MM-DD format. Got %s", value))
+		return warns, errs
+	}
+	return warns, errs
+}
+
+// objectID is what the id of a store entity looks like
+var objectID = regexp.MustCompile(`^[0-9a-f]{24}$`)
+
+// validateObjectID accepts the 24 hex digit ids the store gives its entities
+func validateObjectID(v interface{}, k string) (ws []string, es []error) {
+	var errs []error
+	var warns []string
+	value, ok := v.(string)
+	if !ok {
+		errs = append(errs, fmt.Errorf("Expected value to be string"))
+		return warns, errs
+	}
+	if !objectID.MatchString(value) {
+		errs = append(errs, fmt.Errorf("Expected an id of 24 hex digits. Got %q", value))
+		return warns, errs
+	}
+	return warns, errs
+}
+
+func validateURL(v interface{}, k string) (ws []string, es []error) {
+	var errs []error
+	var warns []string
+	value, ok := v.(string)
+	if !ok {
+		errs = append(errs, fmt.Errorf("Expected value to be string"))
+		return warns, errs
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("Expected an http or https URL. Got %s", value))
+		return warns, errs
+	}
+	return warns, errs
+}
+
+// validateReleaseYear accepts the years from the first motion picture, in 1888, until five years from now
+func validateReleaseYear(v interface{}, k string) (ws []string, es []error) {
+	var errs []error
+	var warns []string
+	value, ok := v.(int)
+	if !ok {
+		errs = append(errs, fmt.Errorf("Expected integer number. Got %v", v))
+		return warns, errs
+	}
+	if maxYear := time.Now().Year() + 5; value < 1888 || value > maxYear {
+		errs = append(errs, fmt.Errorf("Expected a year between 1888 and %d. Got %d", maxYear, value))
+		return warns, errs
+	}
+	return warns, errs
+}
+
 func validateInt(v interface{}, k string) (ws []string, es []error) {
 	var errs []error
 	var warns []string
@@ -50,3 +157,53 @@ func validateFloat(v interface{}, k string) (ws []string, es []error) {
 	}
 	return warns, errs
 }
+
+// checkReference reports an error when the entity key refers to cannot be found through get. Nothing is checked
+// while the value of key is unknown, when it is unchanged on an existing resource, or when reference validation is
+// turned off in the provider
+func checkReference(d *schema.ResourceDiff, meta *providerMeta, key, kind string, get func(id string) error) error {
+	if meta.skipReferenceValidation || !d.NewValueKnown(key) {
+		return nil
+	}
+	if d.Id() != "" && !d.HasChange(key) {
+		return nil
+	}
+	id, ok := d.GetOk(key)
+	if !ok {
+		return nil
+	}
+	err := get(id.(string))
+	if err == nil {
+		return nil
+	}
+	if strings.Contains(err.Error(), "not found") {
+		return fmt.Errorf("%s: %s %q does not exist", key, kind, id)
+	}
+	return fmt.Errorf("%s: error checking that %s %q exists, set skip_reference_validation to plan without the store: %s", key, kind, id, err)
+}
+
+// checkReferences is checkReference for a set of references, reporting the first element of key that refers to an
+// entity that cannot be found through get
+func checkReferences(d *schema.ResourceDiff, meta *providerMeta, key, kind string, get func(id string) error) error {
+	if meta.skipReferenceValidation || !d.NewValueKnown(key) {
+		return nil
+	}
+	if d.Id() != "" && !d.HasChange(key) {
+		return nil
+	}
+	set, ok := d.Get(key).(*schema.Set)
+	if !ok {
+		return nil
+	}
+	for _, id := range set.List() {
+		err := get(id.(string))
+		if err == nil {
+			continue
+		}
+		if strings.Contains(err.Error(), "not found") {
+			return fmt.Errorf("%s: %s %q does not exist", key, kind, id)
+		}
+		return fmt.Errorf("%s: error checking that %s %q exists, set skip_reference_validation to plan without the store: %s", key, kind, id, err)
+	}
+	return nil
+}
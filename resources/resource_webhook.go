@@ -0,0 +1,159 @@
+package provider
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/milamice62/terraplugin/api/client"
+)
+
+// webhookAttributes maps the fields of a webhook in the API to the attributes of store_webhook
+var webhookAttributes = map[string]string{
+	"url":    "url",
+	"events": "events",
+	"secret": "secret",
+}
+
+func WebhookItem() *schema.Resource {
+	return &schema.Resource{
+		Schema: map[string]*schema.Schema{
+			"url": {
+				Type:         schema.TypeString,
+				Required:     true,
+				Description:  "The http or https URL the change notifications are POSTed to",
+				ValidateFunc: validateURL,
+			},
+			"events": {
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Description: "The events to be notified of. Every event is notified when it is empty",
+				Elem: &schema.Schema{
+					Type:         schema.TypeString,
+					ValidateFunc: validation.StringInSlice(client.WebhookEvents, false),
+				},
+			},
+			"secret": {
+				Type:         schema.TypeString,
+				Required:     true,
+				Sensitive:    true,
+				Description:  "The secret each notification is signed with, in the X-Store-Signature header",
+				ValidateFunc: validation.StringLenBetween(16, 255),
+			},
+			"version": {
+				Type:        schema.TypeInt,
+				Computed:    true,
+				Description: "The version of the webhook on the server when it was last read",
+			},
+		},
+		Create: createWebhook,
+		Read:   readWebhook,
+		Update: updateWebhook,
+		Delete: deleteWebhook,
+		Exists: existWebhook,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+	}
+}
+
+func createWebhook(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	webhook := &client.Webhook{
+		URL:    d.Get("url").(string),
+		Events: webhookEvents(d),
+		Secret: d.Get("secret").(string),
+	}
+	err := apiClient.NewWebhook(webhook)
+	if err != nil {
+		return fieldError(err, webhookAttributes)
+	}
+
+	d.SetId(webhook.ID)
+	return readWebhook(d, m)
+}
+
+// readWebhook leaves secret as it is in state, since the server never returns it
+func readWebhook(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	webhookID := d.Id()
+	webhook, err := apiClient.GetWebhook(webhookID)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			d.SetId("")
+			return nil
+		}
+		return fmt.Errorf("error finding Webhook with ID %s: %s", webhookID, err)
+	}
+
+	if err := d.Set("url", webhook.URL); err != nil {
+		return err
+	}
+	if err := d.Set("events", webhook.Events); err != nil {
+		return err
+	}
+	if err := d.Set("version", webhook.Version); err != nil {
+		return err
+	}
+	return nil
+}
+
+func updateWebhook(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	webhookID := d.Id()
+	webhook := &client.Webhook{
+		ID:      webhookID,
+		URL:     d.Get("url").(string),
+		Events:  webhookEvents(d),
+		Secret:  d.Get("secret").(string),
+		Version: d.Get("version").(int),
+	}
+
+	err := apiClient.UpdateWebhook(webhook)
+	if errors.Is(err, client.ErrModified) {
+		return fmt.Errorf("webhook %s was modified outside Terraform since it was last read, refresh and retry", webhookID)
+	}
+	if err != nil {
+		return fieldError(err, webhookAttributes)
+	}
+
+	return readWebhook(d, m)
+}
+
+func existWebhook(d *schema.ResourceData, m interface{}) (bool, error) {
+	apiClient := m.(*providerMeta).client
+
+	_, err := apiClient.GetWebhook(d.Id())
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func deleteWebhook(d *schema.ResourceData, m interface{}) error {
+	apiClient := m.(*providerMeta).client
+
+	err := apiClient.DeleteWebhook(d.Id())
+	if err != nil {
+		return err
+	}
+	d.SetId("")
+	return nil
+}
+
+// webhookEvents returns the configured events of a store_webhook
+func webhookEvents(d *schema.ResourceData) []string {
+	events := []string{}
+	for _, event := range d.Get("events").(*schema.Set).List() {
+		events = append(events, event.(string))
+	}
+	return events
+}
@@ -0,0 +1,140 @@
+package provider
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/milamice62/terraplugin/api/client"
+	"github.com/milamice62/terraplugin/api/server"
+)
+
+func Test_Webhook_Update(t *testing.T) {
+	testAccTest(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckWebhookDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccCheckWebhook("rental.opened"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("store_webhook.rentals", "events.#", "1"),
+					resource.TestCheckResourceAttr("store_webhook.rentals", "version", "0"),
+				),
+			},
+			{
+				Config: testAccCheckWebhook("rental.closed"),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttr("store_webhook.rentals", "events.#", "1"),
+					resource.TestCheckResourceAttr("store_webhook.rentals", "version", "1"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckWebhookDestroy(s *terraform.State) error {
+	apiClient := testAccProvider.Meta().(*providerMeta).client
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "store_webhook" {
+			continue
+		}
+		_, err := apiClient.GetWebhook(rs.Primary.ID)
+		if err == nil {
+			return fmt.Errorf("Webhook %s still exists", rs.Primary.ID)
+		}
+	}
+	return nil
+}
+
+func testAccCheckWebhook(event string) string {
+	return fmt.Sprintf(`
+	resource "store_webhook" "rentals" {
+		url    = "http://localhost:8080/hooks/rentals"
+		events = ["%s"]
+		secret = "0123456789abcdef"
+	}
+	`, event)
+}
+
+func TestWebhook_Deliveries(t *testing.T) {
+	secret := "0123456789abcdef"
+	received := make(chan server.WebhookPayload, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if got, want := r.Header.Get("X-Store-Signature"), server.SignWebhookPayload(body, secret); got != want {
+			t.Errorf("expected signature %s, got %s", want, got)
+		}
+		var payload server.WebhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+			return
+		}
+		received <- payload
+	}))
+	defer receiver.Close()
+
+	meta := testStoreMeta(t, nil)
+	d := schema.TestResourceDataRaw(t, WebhookItem().Schema, map[string]interface{}{
+		"url":    receiver.URL,
+		"events": []interface{}{"genre.created"},
+		"secret": secret,
+	})
+	if err := createWebhook(d, meta); err != nil {
+		t.Fatal(err)
+	}
+	if d.Id() == "" {
+		t.Fatal("expected the webhook to have an ID")
+	}
+
+	if _, err := meta.client.NewCustomer(&client.Customer{Name: "foobar", Phone: "12345"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := meta.client.NewGenre(&client.Genre{Name: "comedy"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case payload := <-received:
+		if payload.Event != "genre.created" {
+			t.Errorf("expected only genre.created to be delivered, got %s", payload.Event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the genre.created notification")
+	}
+
+	d.Set("events", []interface{}{"genre.created", "genre.deleted"})
+	if err := updateWebhook(d, meta); err != nil {
+		t.Fatal(err)
+	}
+	if got := d.Get("version").(int); got != 1 {
+		t.Errorf("expected version 1 after the update, got %d", got)
+	}
+	webhook, err := meta.client.GetWebhook(d.Id())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(webhook.Events) != 2 {
+		t.Errorf("expected the webhook to subscribe to 2 events, got %v", webhook.Events)
+	}
+
+	deleted := schema.TestResourceDataRaw(t, WebhookItem().Schema, nil)
+	deleted.SetId(d.Id())
+	if err := deleteWebhook(d, meta); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := existWebhook(deleted, meta); err != nil || exists {
+		t.Errorf("expected the webhook to be gone, got exists %v and error %v", exists, err)
+	}
+}